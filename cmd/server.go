@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/playground"
+	"github.com/k0ch3gar/ozon-task/internal/auth"
 	"github.com/k0ch3gar/ozon-task/internal/config"
 	graph2 "github.com/k0ch3gar/ozon-task/internal/graph"
 	config2 "github.com/k0ch3gar/ozon-task/internal/graph/config"
 	handler2 "github.com/k0ch3gar/ozon-task/internal/handler"
 	"github.com/k0ch3gar/ozon-task/internal/service"
 	"github.com/k0ch3gar/ozon-task/internal/storage"
+	"github.com/k0ch3gar/ozon-task/internal/validation"
 	"go.uber.org/fx"
 )
 
@@ -23,16 +27,36 @@ func main() {
 			params,
 		),
 		storage.NewStorageModule(params),
+		service.NewEventBusModule(params),
 		fx.Provide(
+			auth.NewArgon2PasswordHasher,
+			auth.NewTokenOpt,
+			auth.NewTokenManager,
+			validation.NewValidator,
 			config2.NewResolverConfig,
 			service.NewSubscriptionService,
 			service.NewUserService,
 			service.NewPostService,
+			service.NewNotificationService,
 			service.NewCommentService,
+			service.NewAuthService,
+			service.NewPurgeJob,
+			service.NewWebhookService,
+			service.NewWebhookWorker,
 			graph2.NewResolver,
 			handler2.NewGraphQlServer,
+			handler2.NewAuthMiddleware,
+			handler2.NewDataLoaderMiddleware,
 		),
-		fx.Invoke(func(srv *handler.Server, params config.ApplicationParameters) {
+		fx.Invoke(func(*service.PurgeJob) {}),
+		fx.Invoke(func(*service.WebhookWorker) {}),
+		fx.Invoke(func(
+			lc fx.Lifecycle,
+			srv *handler.Server,
+			authMiddleware handler2.AuthMiddleware,
+			dataLoaderMiddleware handler2.DataLoaderMiddleware,
+			params config.ApplicationParameters,
+		) {
 			port := params.Port
 
 			if params.Debug {
@@ -40,8 +64,25 @@ func main() {
 				log.Printf("connect to http://localhost:%s/ for GraphQL playground", port)
 			}
 
-			http.Handle("/query", srv)
-			log.Fatal(http.ListenAndServe(":"+port, nil))
+			http.Handle("/query", authMiddleware(dataLoaderMiddleware(srv)))
+
+			// The server is started by a hook, so fx gets to start the
+			// background jobs as well.
+			server := &http.Server{Addr: ":" + port}
+			lc.Append(fx.Hook{
+				OnStart: func(context.Context) error {
+					go func() {
+						if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+							log.Fatal(err)
+						}
+					}()
+
+					return nil
+				},
+				OnStop: func(ctx context.Context) error {
+					return server.Shutdown(ctx)
+				},
+			})
 		}),
 	).Run()
 }
@@ -0,0 +1,3 @@
+-- Irreversible: hashed passwords cannot be turned back into plaintext, the
+-- rows stay hashed.
+SELECT 1;
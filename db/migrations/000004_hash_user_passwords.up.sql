@@ -0,0 +1,9 @@
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+-- Passwords used to be stored verbatim. Re-hash every row that is not already
+-- an argon2id hash written by the application with bcrypt, a plaintext
+-- password may itself start with '$'. The application upgrades them to
+-- argon2id on the next successful login.
+UPDATE users
+SET password = crypt(password, gen_salt('bf', 10))
+WHERE password NOT LIKE '$argon2id$%';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_username_lower_idx;
+DROP INDEX IF EXISTS users_email_lower_idx;
\ No newline at end of file
@@ -0,0 +1,5 @@
+-- Usernames and emails are unique regardless of case. Creating the indexes
+-- fails if the table already holds rows that differ only in case, those have
+-- to be resolved by hand first.
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_idx ON users (lower(username));
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx ON users (lower(email));
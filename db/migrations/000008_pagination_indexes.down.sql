@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS posts_created_at_id_idx;
+DROP INDEX IF EXISTS comments_post_created_at_id_idx;
+DROP INDEX IF EXISTS comments_parent_created_at_id_idx;
\ No newline at end of file
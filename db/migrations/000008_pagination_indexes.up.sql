@@ -0,0 +1,4 @@
+-- Keyset pagination walks these indexes in (created_at, id) order.
+CREATE INDEX IF NOT EXISTS posts_created_at_id_idx ON posts (created_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS comments_post_created_at_id_idx ON comments (parent_post_id, created_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS comments_parent_created_at_id_idx ON comments (parent_comment_id, created_at, id) WHERE deleted_at IS NULL;
\ No newline at end of file
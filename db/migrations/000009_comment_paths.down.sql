@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS comments_root_path_idx;
+DROP INDEX IF EXISTS comments_post_created_at_idx;
+CREATE INDEX IF NOT EXISTS comments_post_created_at_id_idx ON comments (parent_post_id, created_at, id) WHERE deleted_at IS NULL;
+
+DROP TRIGGER IF EXISTS comments_set_path ON comments;
+DROP FUNCTION IF EXISTS comments_set_path();
+
+ALTER TABLE comments DROP COLUMN path;
+ALTER TABLE comments DROP COLUMN depth;
+ALTER TABLE comments DROP COLUMN root_id;
+
+-- The sequence default is not restored, top level comments point to
+-- themselves again as before.
+UPDATE comments SET parent_comment_id = id WHERE parent_comment_id IS NULL;
+ALTER TABLE comments ALTER COLUMN parent_comment_id SET NOT NULL;
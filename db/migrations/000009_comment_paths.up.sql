@@ -0,0 +1,76 @@
+-- Top level comments used to get parent_comment_id from its own sequence and
+-- were told apart from replies by parent_comment_id = id, which is how the
+-- application read them. Those become NULL, as do replies whose parent is not
+-- a comment of the same post: the application never showed them in a thread.
+ALTER TABLE comments ALTER COLUMN parent_comment_id DROP DEFAULT;
+ALTER TABLE comments ALTER COLUMN parent_comment_id DROP NOT NULL;
+DROP SEQUENCE IF EXISTS comments_parent_comment_id_seq;
+
+UPDATE comments c
+SET parent_comment_id = NULL
+WHERE c.parent_comment_id = c.id
+   OR NOT EXISTS (
+       SELECT 1 FROM comments p
+       WHERE p.id = c.parent_comment_id AND p.parent_post_id = c.parent_post_id
+   );
+
+-- Replies forming a cycle are not reached from any top level comment. Every
+-- cycle has a reply to a newer comment, those replies become top level.
+WITH RECURSIVE reachable AS (
+    SELECT id FROM comments WHERE parent_comment_id IS NULL
+    UNION
+    SELECT c.id FROM comments c JOIN reachable r ON c.parent_comment_id = r.id
+)
+UPDATE comments
+SET parent_comment_id = NULL
+WHERE parent_comment_id > id AND id NOT IN (SELECT id FROM reachable);
+
+-- path is the chain of zero padded ids from the top level comment, so sorting
+-- by it lists a thread depth first. It is compared bytewise, the descendants
+-- of a comment are then a range of (root_id, path).
+ALTER TABLE comments ADD COLUMN root_id BIGINT REFERENCES comments(id);
+ALTER TABLE comments ADD COLUMN depth INT NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN path TEXT COLLATE "C" NOT NULL DEFAULT '';
+
+WITH RECURSIVE tree AS (
+    SELECT id, id AS root_id, 0 AS depth, lpad(id::text, 19, '0') AS path
+    FROM comments
+    WHERE parent_comment_id IS NULL
+    UNION ALL
+    SELECT c.id, t.root_id, t.depth + 1, t.path || '.' || lpad(c.id::text, 19, '0')
+    FROM comments c
+    JOIN tree t ON c.parent_comment_id = t.id
+)
+UPDATE comments c
+SET root_id = tree.root_id, depth = tree.depth, path = tree.path
+FROM tree
+WHERE c.id = tree.id;
+
+ALTER TABLE comments ALTER COLUMN root_id SET NOT NULL;
+
+CREATE OR REPLACE FUNCTION comments_set_path() RETURNS trigger AS $$
+BEGIN
+    IF NEW.parent_comment_id IS NULL THEN
+        NEW.root_id := NEW.id;
+        NEW.depth := 0;
+        NEW.path := lpad(NEW.id::text, 19, '0');
+    ELSE
+        SELECT p.root_id, p.depth + 1, p.path || '.' || lpad(NEW.id::text, 19, '0')
+        INTO NEW.root_id, NEW.depth, NEW.path
+        FROM comments p
+        WHERE p.id = NEW.parent_comment_id;
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER comments_set_path
+    BEFORE INSERT ON comments
+    FOR EACH ROW EXECUTE FUNCTION comments_set_path();
+
+DROP INDEX IF EXISTS comments_post_created_at_id_idx;
+CREATE INDEX IF NOT EXISTS comments_post_created_at_idx ON comments (parent_post_id, created_at, id) WHERE parent_comment_id IS NULL AND deleted_at IS NULL;
+-- Serves the descendant lookups that see deleted comments too, like the one
+-- of the purge: each expired comment costs one range scan of it.
+CREATE INDEX IF NOT EXISTS comments_root_path_idx ON comments (root_id, path);
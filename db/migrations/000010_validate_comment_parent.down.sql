@@ -0,0 +1,16 @@
+CREATE OR REPLACE FUNCTION comments_set_path() RETURNS trigger AS $$
+BEGIN
+    IF NEW.parent_comment_id IS NULL THEN
+        NEW.root_id := NEW.id;
+        NEW.depth := 0;
+        NEW.path := lpad(NEW.id::text, 19, '0');
+    ELSE
+        SELECT p.root_id, p.depth + 1, p.path || '.' || lpad(NEW.id::text, 19, '0')
+        INTO NEW.root_id, NEW.depth, NEW.path
+        FROM comments p
+        WHERE p.id = NEW.parent_comment_id;
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
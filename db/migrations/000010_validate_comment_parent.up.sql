@@ -0,0 +1,30 @@
+-- Replies must point to a live comment of the same post. The error codes
+-- match what the application maps to NOT_FOUND and VALIDATION.
+CREATE OR REPLACE FUNCTION comments_set_path() RETURNS trigger AS $$
+DECLARE
+    parent comments%ROWTYPE;
+BEGIN
+    IF NEW.parent_comment_id IS NULL THEN
+        NEW.root_id := NEW.id;
+        NEW.depth := 0;
+        NEW.path := lpad(NEW.id::text, 19, '0');
+        RETURN NEW;
+    END IF;
+
+    SELECT * INTO parent FROM comments WHERE id = NEW.parent_comment_id;
+    IF NOT FOUND OR parent.deleted_at IS NOT NULL THEN
+        RAISE EXCEPTION 'parent comment % does not exist', NEW.parent_comment_id
+            USING ERRCODE = 'foreign_key_violation';
+    END IF;
+
+    IF parent.parent_post_id <> NEW.parent_post_id THEN
+        RAISE EXCEPTION 'parent comment % belongs to another post', NEW.parent_comment_id
+            USING ERRCODE = 'check_violation';
+    END IF;
+
+    NEW.root_id := parent.root_id;
+    NEW.depth := parent.depth + 1;
+    NEW.path := parent.path || '.' || lpad(NEW.id::text, 19, '0');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS comments_alive_path_idx;
@@ -0,0 +1,2 @@
+-- Serves the tombstone check: is anything alive below this comment.
+CREATE INDEX IF NOT EXISTS comments_alive_path_idx ON comments (root_id, path text_pattern_ops) WHERE deleted_at IS NULL;
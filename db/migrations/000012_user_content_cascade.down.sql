@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS comments_author_id_idx;
+DROP INDEX IF EXISTS posts_author_id_idx;
+
+-- The sequence defaults are not restored. Anonymized rows have to be removed
+-- before the columns can be required again.
+ALTER TABLE comments ALTER COLUMN author_id SET NOT NULL;
+ALTER TABLE posts ALTER COLUMN author_id SET NOT NULL;
@@ -0,0 +1,10 @@
+-- Content of a deleted user may be anonymized, so it no longer needs an
+-- author. BIGSERIAL gave both columns a sequence default that is dropped too.
+ALTER TABLE posts ALTER COLUMN author_id DROP DEFAULT;
+ALTER TABLE posts ALTER COLUMN author_id DROP NOT NULL;
+
+ALTER TABLE comments ALTER COLUMN author_id DROP DEFAULT;
+ALTER TABLE comments ALTER COLUMN author_id DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS posts_author_id_idx ON posts (author_id);
+CREATE INDEX IF NOT EXISTS comments_author_id_idx ON comments (author_id);
@@ -0,0 +1,8 @@
+-- Messages of the postgres event bus, NOTIFY only carries their ids.
+CREATE TABLE IF NOT EXISTS bus_messages (
+    id         BIGSERIAL PRIMARY KEY,
+    payload    JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS bus_messages_created_at_idx ON bus_messages (created_at);
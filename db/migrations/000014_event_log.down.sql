@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS event_topics;
+
+ALTER INDEX events_created_at_idx RENAME TO bus_messages_created_at_idx;
+ALTER TABLE events RENAME TO bus_messages;
@@ -0,0 +1,14 @@
+-- The bus messages become the events replayed to resuming subscribers, the
+-- replay log of every topic is bounded by the application. Their ids are
+-- kept across restarts, unlike the ones of the in-memory bus which start over
+-- with the process, so a client can only resume against the bus it came from.
+ALTER TABLE bus_messages RENAME TO events;
+ALTER INDEX bus_messages_created_at_idx RENAME TO events_created_at_idx;
+
+CREATE TABLE IF NOT EXISTS event_topics (
+    topic    TEXT NOT NULL,
+    event_id BIGINT NOT NULL REFERENCES events (id) ON DELETE CASCADE,
+    PRIMARY KEY (topic, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS event_topics_event_id_idx ON event_topics (event_id);
@@ -0,0 +1,23 @@
+-- Notifications go away together with the comment they are about, which
+-- only happens when it is purged.
+CREATE TABLE IF NOT EXISTS notifications (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('reply', 'comment', 'mention')),
+    comment_id BIGINT NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    post_id BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    read_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_idx ON notifications (user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS notifications_unread_idx ON notifications (user_id, created_at, id) WHERE read_at IS NULL;
+CREATE INDEX IF NOT EXISTS notifications_comment_id_idx ON notifications (comment_id);
+CREATE INDEX IF NOT EXISTS notifications_post_id_idx ON notifications (post_id);
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    replies BOOLEAN NOT NULL DEFAULT TRUE,
+    comments BOOLEAN NOT NULL DEFAULT TRUE,
+    mentions BOOLEAN NOT NULL DEFAULT TRUE
+);
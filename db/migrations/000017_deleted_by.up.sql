@@ -0,0 +1,5 @@
+-- Who deleted a post or comment decides who may restore it: its author only
+-- brings back what they deleted themselves. Rows deleted before this column
+-- existed have no deleter and are left to moderators.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_by BIGINT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted_by BIGINT REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS comments_alive_path_idx;
+CREATE INDEX IF NOT EXISTS comments_alive_path_idx ON comments (root_id, path text_pattern_ops) WHERE deleted_at IS NULL;
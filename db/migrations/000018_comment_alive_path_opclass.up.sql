@@ -0,0 +1,5 @@
+-- The tombstone check compares paths with < and >, which text_pattern_ops
+-- cannot serve. path is COLLATE "C" already, so the default opclass orders it
+-- the same way byte by byte.
+DROP INDEX IF EXISTS comments_alive_path_idx;
+CREATE INDEX IF NOT EXISTS comments_alive_path_idx ON comments (root_id, path) WHERE deleted_at IS NULL;
@@ -1,8 +1,8 @@
 WITH user_id AS (
     INSERT INTO
-        users (username, email, password)
+        users (username, email, password, role)
     VALUES
-        ('foo', 'foo@mail.ru', '1')
+        ('foo', 'foo@mail.ru', crypt('1', gen_salt('bf', 10)), 'admin')
     RETURNING id
 )
 INSERT INTO posts (author_id, title, body, allow_comments) VALUES ((SELECT id FROM user_id),'foo', 'foofoofoo', true);
@@ -11,7 +11,7 @@ WITH user_id AS (
     INSERT INTO
         users (username, email, password)
         VALUES
-            ('bar', 'bar@mail.ru', '2')
+            ('bar', 'bar@mail.ru', crypt('2', gen_salt('bf', 10)))
         RETURNING id
 )
 INSERT INTO posts (author_id, title, body, allow_comments) VALUES ((SELECT id FROM user_id),'bar', 'barbarbar', false);
@@ -20,7 +20,7 @@ WITH user_id AS (
     INSERT INTO
         users (username, email, password)
         VALUES
-            ('baz', 'baz@mail.ru', '3')
+            ('baz', 'baz@mail.ru', crypt('3', gen_salt('bf', 10)))
         RETURNING id
 )
 INSERT INTO posts (author_id, title, body, allow_comments) VALUES ((SELECT id FROM user_id),'baz', 'bazbazbaz', true);
\ No newline at end of file
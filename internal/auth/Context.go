@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+)
+
+type principalKey struct{}
+
+// Principal is the authenticated caller of the current request.
+type Principal struct {
+	User      *model.User
+	SessionID string
+}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	if !ok || principal == nil || principal.User == nil {
+		return nil, false
+	}
+
+	return principal, true
+}
+
+// WithUser stores user as the caller without an associated session, which is
+// what tests and internal jobs acting on behalf of a user need.
+func WithUser(ctx context.Context, user *model.User) context.Context {
+	return WithPrincipal(ctx, &Principal{User: user})
+}
+
+func UserFromContext(ctx context.Context) (*model.User, bool) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	return principal.User, true
+}
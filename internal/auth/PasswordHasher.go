@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher produces self-describing hashes in the PHC string format
+// ($<algorithm>$<version>$<params>$<salt>$<hash>), so stored hashes can be
+// verified with the parameters they were created with and upgraded later.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password string, hash string) (bool, error)
+	NeedsRehash(hash string) bool
+}
+
+const (
+	argon2idPrefix = "$argon2id$"
+
+	argon2Memory      uint32 = 64 * 1024
+	argon2Iterations  uint32 = 3
+	argon2Parallelism uint8  = 2
+	argon2SaltLength         = 16
+	argon2KeyLength   uint32 = 32
+)
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+type Argon2PasswordHasher struct {
+	params argon2Params
+}
+
+func NewArgon2PasswordHasher() PasswordHasher {
+	return &Argon2PasswordHasher{
+		params: argon2Params{
+			memory:      argon2Memory,
+			iterations:  argon2Iterations,
+			parallelism: argon2Parallelism,
+		},
+	}
+}
+
+func (h *Argon2PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.iterations, h.params.memory, h.params.parallelism, argon2KeyLength)
+
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.params.memory,
+		h.params.iterations,
+		h.params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks password against hash. Besides argon2id it accepts bcrypt
+// hashes, which is what the plaintext migration re-hashes legacy rows into.
+func (h *Argon2PasswordHasher) Verify(password string, hash string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, argon2idPrefix):
+		params, salt, key, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return false, err
+		}
+
+		other := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	case isBcryptHash(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+
+		return err == nil, err
+	default:
+		return false, errors.New("unknown password hash format")
+	}
+}
+
+// NeedsRehash reports whether hash was produced by another algorithm or with
+// weaker parameters than the current ones.
+func (h *Argon2PasswordHasher) NeedsRehash(hash string) bool {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return true
+	}
+
+	params, _, _, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+
+	return params != h.params
+}
+
+func decodeArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, err
+	}
+
+	if version != argon2.Version {
+		return params, nil, nil, errors.New(fmt.Sprintf("unsupported argon2 version: %d", version))
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	return params, salt, key, nil
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashIsNotPlaintext(t *testing.T) {
+	hasher := NewArgon2PasswordHasher()
+
+	hash, err := hasher.Hash("baz")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.NotEqual(t, "baz", hash)
+	assert.True(t, strings.HasPrefix(hash, argon2idPrefix))
+	assert.False(t, hasher.NeedsRehash(hash))
+
+	ok, err := hasher.Verify("baz", hash)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.True(t, ok)
+
+	ok, err = hasher.Verify("qux", hash)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.False(t, ok)
+}
+
+func TestLegacyBcryptHashNeedsRehash(t *testing.T) {
+	hasher := NewArgon2PasswordHasher()
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("baz"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ok, err := hasher.Verify("baz", string(legacy))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.True(t, ok)
+	assert.True(t, hasher.NeedsRehash(string(legacy)))
+}
@@ -0,0 +1,24 @@
+package auth
+
+import "github.com/k0ch3gar/ozon-task/internal/storage/model"
+
+var roleRanks = map[string]int{
+	model.RoleUser:      0,
+	model.RoleModerator: 1,
+	model.RoleAdmin:     2,
+}
+
+// HasRole reports whether user has role or a role above it, so admins pass
+// every moderator check.
+func HasRole(user *model.User, role string) bool {
+	if user == nil {
+		return false
+	}
+
+	rank, ok := roleRanks[user.Role]
+	if !ok {
+		return false
+	}
+
+	return rank >= roleRanks[role]
+}
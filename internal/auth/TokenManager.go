@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+)
+
+type TokenType string
+
+const (
+	AccessToken  TokenType = "access"
+	RefreshToken TokenType = "refresh"
+)
+
+var (
+	ErrInvalidToken = errs.Unauthenticated("invalid token")
+	ErrExpiredToken = errs.Unauthenticated("token is expired")
+)
+
+// Claims is the payload of both access and refresh tokens. A refresh token is
+// only valid while its ID matches the one stored in the session, which makes
+// refresh tokens single use.
+type Claims struct {
+	Subject   string    `json:"sub"`
+	SessionID string    `json:"sid"`
+	ID        string    `json:"jti"`
+	Type      TokenType `json:"typ"`
+	IssuedAt  int64     `json:"iat"`
+	ExpiresAt int64     `json:"exp"`
+}
+
+type TokenOpt struct {
+	Secret          []byte
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+func NewTokenOpt(params config.ApplicationParameters) TokenOpt {
+	secret := []byte(os.Getenv("AUTH_SECRET"))
+	if len(secret) == 0 {
+		log.Printf("AUTH_SECRET is not set, tokens will not survive a restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	}
+
+	return TokenOpt{
+		Secret:          secret,
+		AccessTokenTTL:  params.AccessTokenTTL,
+		RefreshTokenTTL: params.RefreshTokenTTL,
+	}
+}
+
+// TokenManager issues and parses HS256 signed JWTs.
+type TokenManager struct {
+	opt    TokenOpt
+	header string
+}
+
+func NewTokenManager(opt TokenOpt) *TokenManager {
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+
+	return &TokenManager{
+		opt:    opt,
+		header: base64.RawURLEncoding.EncodeToString(header),
+	}
+}
+
+func (tm *TokenManager) Issue(userId string, sessionId string, typ TokenType) (string, *Claims, error) {
+	jti, err := NewTokenId()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		Subject:   userId,
+		SessionID: sessionId,
+		ID:        jti,
+		Type:      typ,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(tm.TTL(typ)).Unix(),
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	unsigned := tm.header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + tm.sign(unsigned), claims, nil
+}
+
+func (tm *TokenManager) TTL(typ TokenType) time.Duration {
+	if typ == RefreshToken {
+		return tm.opt.RefreshTokenTTL
+	}
+
+	return tm.opt.AccessTokenTTL
+}
+
+func (tm *TokenManager) Parse(token string, typ TokenType) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tm.header {
+		return nil, ErrInvalidToken
+	}
+
+	expected := tm.sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Type != typ {
+		return nil, errs.Unauthenticated("expected %s token, got %s", typ, claims.Type)
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+func (tm *TokenManager) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, tm.opt.Secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func NewTokenId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
@@ -1,23 +1,215 @@
 package config
 
-import "flag"
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
 
 type ApplicationParameters struct {
 	PersistentStorageType bool
 	Port                  string
 	StorageShardsCount    uint64
 	PageSize              uint64
+	MaxPageSize           uint64
 	Debug                 bool
+	AccessTokenTTL        time.Duration
+	RefreshTokenTTL       time.Duration
+	MaxUsernameLength     int
+	MaxEmailLength        int
+	MaxPasswordLength     int
+	MaxTitleLength        int
+	MaxPostBodyLength     int
+	MaxCommentBodyLength  int
+	MaxCommentDepth       uint64
+	ThreadDepth           uint64
+	MaxThreadDepth        uint64
+	MaxThreadNodes        uint64
+	DataLoaderWait        time.Duration
+	DataLoaderMaxBatch    int
+	UserContentPolicy     UserContentPolicy
+	RetentionPeriod       time.Duration
+	PurgeInterval         time.Duration
+	SubscriberBufferSize  int
+	SubscriberOverflow    OverflowPolicy
+	EventBus              EventBusType
+	ReplayLogSize         int
+	ReplayLogTTL          time.Duration
+	WebhookTimeout        time.Duration
+	WebhookMaxAttempts    int
+	WebhookBackoff        time.Duration
+	WebhookMaxBackoff     time.Duration
+	WebhookPollInterval   time.Duration
+}
+
+// UserContentPolicy decides what happens to the posts and comments of a
+// deleted user.
+type UserContentPolicy string
+
+const (
+	// AnonymizeUserContent keeps the content but detaches it from the author.
+	AnonymizeUserContent UserContentPolicy = "anonymize"
+	// RemoveUserContent deletes the posts, with their comments, and the
+	// comments of the user.
+	RemoveUserContent UserContentPolicy = "remove"
+)
+
+func (p *UserContentPolicy) String() string {
+	return string(*p)
+}
+
+func (p *UserContentPolicy) Set(value string) error {
+	switch policy := UserContentPolicy(value); policy {
+	case AnonymizeUserContent, RemoveUserContent:
+		*p = policy
+		return nil
+	}
+
+	return fmt.Errorf("must be %q or %q", AnonymizeUserContent, RemoveUserContent)
+}
+
+// OverflowPolicy decides what happens when a subscriber does not keep up and
+// its buffer is full.
+type OverflowPolicy string
+
+const (
+	// DropOldest makes room for the new event by dropping the oldest one.
+	DropOldest OverflowPolicy = "drop-oldest"
+	// Disconnect ends the subscription with an error.
+	Disconnect OverflowPolicy = "disconnect"
+)
+
+func (p *OverflowPolicy) String() string {
+	return string(*p)
+}
+
+func (p *OverflowPolicy) Set(value string) error {
+	switch policy := OverflowPolicy(value); policy {
+	case DropOldest, Disconnect:
+		*p = policy
+		return nil
+	}
+
+	return fmt.Errorf("must be %q or %q", DropOldest, Disconnect)
+}
+
+// EventBusType decides how events reach the subscribers of other instances.
+type EventBusType string
+
+const (
+	// InMemoryEventBus only delivers events to this instance.
+	InMemoryEventBus EventBusType = "memory"
+	// PostgresEventBus delivers events to every instance sharing the
+	// database through LISTEN/NOTIFY.
+	PostgresEventBus EventBusType = "postgres"
+)
+
+func (t *EventBusType) String() string {
+	return string(*t)
+}
+
+func (t *EventBusType) Set(value string) error {
+	switch bus := EventBusType(value); bus {
+	case InMemoryEventBus, PostgresEventBus:
+		*t = bus
+		return nil
+	}
+
+	return fmt.Errorf("must be %q or %q", InMemoryEventBus, PostgresEventBus)
 }
 
 func NewFlagsConfig() ApplicationParameters {
 	var params ApplicationParameters
 	flag.Uint64Var(&params.StorageShardsCount, "shards-count", 16, "storage shards count")
-	flag.Uint64Var(&params.PageSize, "page-size", 20, "page size")
+	flag.Uint64Var(&params.PageSize, "page-size", 20, "default page size")
+	flag.Uint64Var(&params.MaxPageSize, "max-page-size", 100, "max page size a client may request")
 	flag.StringVar(&params.Port, "port", "8080", "application port")
 	flag.BoolVar(&params.PersistentStorageType, "storage-type", true, "storage type where 'true' is persistent storage")
 	flag.BoolVar(&params.Debug, "debug", true, "turns on graphql playground")
+	flag.DurationVar(&params.AccessTokenTTL, "access-token-ttl", 15*time.Minute, "access token lifetime")
+	flag.DurationVar(&params.RefreshTokenTTL, "refresh-token-ttl", 30*24*time.Hour, "refresh token lifetime")
+	flag.IntVar(&params.MaxUsernameLength, "max-username-length", 32, "max username length, must not exceed users.username column")
+	flag.IntVar(&params.MaxEmailLength, "max-email-length", 64, "max email length, must not exceed users.email column")
+	flag.IntVar(&params.MaxPasswordLength, "max-password-length", 128, "max password length")
+	flag.IntVar(&params.MaxTitleLength, "max-title-length", 255, "max post title length, must not exceed posts.title column")
+	flag.IntVar(&params.MaxPostBodyLength, "max-post-body-length", 20000, "max post body length")
+	flag.IntVar(&params.MaxCommentBodyLength, "max-comment-body-length", 2000, "max comment body length, must not exceed comments.body column")
+	flag.Uint64Var(&params.MaxCommentDepth, "max-comment-depth", 8, "deepest reply level, deeper replies are attached to the ancestor at this level")
+	flag.Uint64Var(&params.ThreadDepth, "thread-depth", 2, "default depth of a comment thread")
+	flag.Uint64Var(&params.MaxThreadDepth, "max-thread-depth", 10, "max depth of a comment thread a client may request")
+	flag.Uint64Var(&params.MaxThreadNodes, "max-thread-nodes", 10000, "max comments a single thread request may return")
+	flag.DurationVar(&params.DataLoaderWait, "dataloader-wait", 2*time.Millisecond, "how long nested lookups are collected into one batch")
+	flag.IntVar(&params.DataLoaderMaxBatch, "dataloader-max-batch", 100, "max ids fetched by one batched lookup")
+	params.UserContentPolicy = AnonymizeUserContent
+	flag.Var(&params.UserContentPolicy, "user-content-policy", "what happens to the content of a deleted user, either 'anonymize' or 'remove'")
+	flag.DurationVar(&params.RetentionPeriod, "retention-period", 30*24*time.Hour, "how long deleted content can be restored before it is purged")
+	flag.DurationVar(&params.PurgeInterval, "purge-interval", time.Hour, "how often content deleted longer than the retention period is purged")
+	flag.IntVar(&params.SubscriberBufferSize, "subscriber-buffer", 16, "events buffered per subscriber before the overflow policy applies")
+	params.SubscriberOverflow = DropOldest
+	flag.Var(&params.SubscriberOverflow, "subscriber-overflow", "what happens to a subscriber with a full buffer, either 'drop-oldest' or 'disconnect'")
+	params.EventBus = InMemoryEventBus
+	flag.Var(&params.EventBus, "event-bus", "how events reach other instances, either 'memory' or 'postgres', which needs persistent storage")
+	flag.IntVar(&params.ReplayLogSize, "replay-log-size", 100, "events kept per topic for subscribers resuming with since")
+	flag.DurationVar(&params.ReplayLogTTL, "replay-log-ttl", time.Hour, "how long events are kept for subscribers resuming with since")
+	flag.DurationVar(&params.WebhookTimeout, "webhook-timeout", 10*time.Second, "how long a webhook receiver may take to answer")
+	flag.IntVar(&params.WebhookMaxAttempts, "webhook-max-attempts", 8, "attempts before a webhook delivery is moved to the dead letters")
+	flag.DurationVar(&params.WebhookBackoff, "webhook-backoff", time.Second, "delay before the first webhook retry, doubled with every further one")
+	flag.DurationVar(&params.WebhookMaxBackoff, "webhook-max-backoff", time.Hour, "longest delay between webhook retries")
+	flag.DurationVar(&params.WebhookPollInterval, "webhook-poll-interval", time.Second, "how often due webhook deliveries are looked for")
 	flag.Parse()
 
+	if err := params.Validate(); err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	return params
 }
+
+// Widths of the columns the length limits are stored in, see db/migrations.
+const (
+	usernameColumnWidth    = 32
+	emailColumnWidth       = 64
+	titleColumnWidth       = 255
+	commentBodyColumnWidth = 2000
+)
+
+// Validate rejects values the background jobs cannot run with and length
+// limits the database columns cannot hold.
+func (p ApplicationParameters) Validate() error {
+	limits := []struct {
+		flag  string
+		value int
+		width int
+	}{
+		{"-max-username-length", p.MaxUsernameLength, usernameColumnWidth},
+		{"-max-email-length", p.MaxEmailLength, emailColumnWidth},
+		{"-max-title-length", p.MaxTitleLength, titleColumnWidth},
+		{"-max-comment-body-length", p.MaxCommentBodyLength, commentBodyColumnWidth},
+	}
+	for _, limit := range limits {
+		if limit.value <= 0 || limit.value > limit.width {
+			return fmt.Errorf("%s must be between 1 and the column width %d, got %d", limit.flag, limit.width, limit.value)
+		}
+	}
+
+	if p.PurgeInterval <= 0 {
+		return fmt.Errorf("-purge-interval must be positive, got %s", p.PurgeInterval)
+	}
+
+	if p.WebhookPollInterval <= 0 {
+		return fmt.Errorf("-webhook-poll-interval must be positive, got %s", p.WebhookPollInterval)
+	}
+
+	if p.WebhookMaxAttempts <= 0 {
+		return fmt.Errorf("-webhook-max-attempts must be positive, got %d", p.WebhookMaxAttempts)
+	}
+
+	if p.WebhookTimeout < 0 {
+		return fmt.Errorf("-webhook-timeout must not be negative, got %s", p.WebhookTimeout)
+	}
+
+	return nil
+}
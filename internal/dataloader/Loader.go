@@ -0,0 +1,115 @@
+package dataloader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+)
+
+// BatchFunc fetches all keys in one go. Keys missing from the returned map are
+// reported to their callers as not found.
+type BatchFunc[V any] func(ctx context.Context, keys []string) (map[string]*V, error)
+
+type LoaderOpt struct {
+	Wait     time.Duration
+	MaxBatch int
+}
+
+// Loader collects the keys requested within Wait of each other into a single
+// BatchFunc call. Results are cached for the lifetime of the loader, which is
+// why a loader must only live as long as one request.
+type Loader[V any] struct {
+	name  string
+	fetch BatchFunc[V]
+	opt   LoaderOpt
+
+	mu    sync.Mutex
+	cache map[string]*result[V]
+	batch *batch[V]
+}
+
+type result[V any] struct {
+	done  chan struct{}
+	value *V
+	err   error
+}
+
+type batch[V any] struct {
+	keys    []string
+	results []*result[V]
+	full    chan struct{}
+}
+
+func NewLoader[V any](name string, fetch BatchFunc[V], opt LoaderOpt) *Loader[V] {
+	return &Loader[V]{
+		name:  name,
+		fetch: fetch,
+		opt:   opt,
+		cache: make(map[string]*result[V]),
+	}
+}
+
+func (l *Loader[V]) Load(ctx context.Context, key string) (*V, error) {
+	l.mu.Lock()
+	r, ok := l.cache[key]
+	if !ok {
+		r = &result[V]{done: make(chan struct{})}
+		l.cache[key] = r
+		l.enqueue(ctx, key, r)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// enqueue must be called with l.mu held.
+func (l *Loader[V]) enqueue(ctx context.Context, key string, r *result[V]) {
+	if l.batch == nil {
+		l.batch = &batch[V]{full: make(chan struct{})}
+		go l.dispatch(ctx, l.batch)
+	}
+
+	b := l.batch
+	b.keys = append(b.keys, key)
+	b.results = append(b.results, r)
+	if l.opt.MaxBatch > 0 && len(b.keys) >= l.opt.MaxBatch {
+		l.batch = nil
+		close(b.full)
+	}
+}
+
+func (l *Loader[V]) dispatch(ctx context.Context, b *batch[V]) {
+	timer := time.NewTimer(l.opt.Wait)
+	select {
+	case <-timer.C:
+	case <-b.full:
+		timer.Stop()
+	}
+
+	l.mu.Lock()
+	if l.batch == b {
+		l.batch = nil
+	}
+	l.mu.Unlock()
+
+	values, err := l.fetch(ctx, b.keys)
+	for i, key := range b.keys {
+		r := b.results[i]
+		if err != nil {
+			r.err = err
+		} else if value, ok := values[key]; ok {
+			r.value = value
+		} else {
+			r.err = errs.NotFound("no such %s: %s", l.name, key)
+		}
+
+		close(r.done)
+	}
+}
@@ -0,0 +1,84 @@
+package dataloader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoaderBatchesConcurrentLoads(t *testing.T) {
+	var calls atomic.Int32
+	var batchSizes sync.Map
+	loader := NewLoader("item", func(ctx context.Context, keys []string) (map[string]*string, error) {
+		n := calls.Add(1)
+		batchSizes.Store(n, len(keys))
+
+		values := make(map[string]*string)
+		for _, key := range keys {
+			if key != "missing" {
+				value := "value-" + key
+				values[key] = &value
+			}
+		}
+
+		return values, nil
+	}, LoaderOpt{Wait: 10 * time.Millisecond, MaxBatch: 100})
+
+	keys := []string{"1", "2", "3", "1", "missing"}
+	var wg sync.WaitGroup
+	results := make([]*string, len(keys))
+	errors := make([]error, len(keys))
+	for i, key := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errors[i] = loader.Load(context.Background(), key)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	size, _ := batchSizes.Load(int32(1))
+	assert.Equal(t, 4, size, "duplicate keys are fetched once")
+	assert.Equal(t, "value-1", *results[0])
+	assert.Equal(t, "value-1", *results[3])
+	assert.ErrorIs(t, errors[4], errs.ErrNotFound)
+
+	_, err := loader.Load(context.Background(), "2")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), calls.Load(), "loaded keys are served from the cache")
+}
+
+func TestLoaderSplitsLargeBatches(t *testing.T) {
+	var calls atomic.Int32
+	loader := NewLoader("item", func(ctx context.Context, keys []string) (map[string]*int, error) {
+		calls.Add(1)
+		assert.LessOrEqual(t, len(keys), 2)
+
+		values := make(map[string]*int)
+		for i, key := range keys {
+			values[key] = &i
+		}
+
+		return values, nil
+	}, LoaderOpt{Wait: time.Second, MaxBatch: 2})
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"1", "2", "3", "4"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := loader.Load(context.Background(), key)
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, int32(2), calls.Load())
+}
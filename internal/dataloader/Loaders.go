@@ -0,0 +1,65 @@
+package dataloader
+
+import (
+	"context"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/storage"
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+)
+
+type loadersKey struct{}
+
+// Loaders batch the lookups made by nested field resolvers, so a page of
+// comments costs one query for their authors instead of one per comment.
+type Loaders struct {
+	Users    *Loader[model.User]
+	Posts    *Loader[model.Post]
+	Comments *Loader[model.Comment]
+}
+
+func NewLoaderOpt(params config.ApplicationParameters) LoaderOpt {
+	return LoaderOpt{
+		Wait:     params.DataLoaderWait,
+		MaxBatch: params.DataLoaderMaxBatch,
+	}
+}
+
+func NewLoaders(u storage.UserStorage, p storage.PostStorage, c storage.CommentStorage, opt LoaderOpt) *Loaders {
+	return &Loaders{
+		Users: NewLoader("user", func(ctx context.Context, keys []string) (map[string]*model.User, error) {
+			users, err := u.GetUsersByIds(keys, ctx)
+			return byId(users, err, func(user *model.User) string { return user.ID })
+		}, opt),
+		Posts: NewLoader("post", func(ctx context.Context, keys []string) (map[string]*model.Post, error) {
+			posts, err := p.GetPostsByIds(keys, ctx)
+			return byId(posts, err, func(post *model.Post) string { return post.ID })
+		}, opt),
+		Comments: NewLoader("comment", func(ctx context.Context, keys []string) (map[string]*model.Comment, error) {
+			comments, err := c.GetCommentsByIds(keys, ctx)
+			return byId(comments, err, func(comment *model.Comment) string { return comment.ID })
+		}, opt),
+	}
+}
+
+func WithLoaders(ctx context.Context, loaders *Loaders) context.Context {
+	return context.WithValue(ctx, loadersKey{}, loaders)
+}
+
+func LoadersFromContext(ctx context.Context) (*Loaders, bool) {
+	loaders, ok := ctx.Value(loadersKey{}).(*Loaders)
+	return loaders, ok && loaders != nil
+}
+
+func byId[V any](values []*V, err error, id func(*V) string) (map[string]*V, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*V, len(values))
+	for _, value := range values {
+		result[id(value)] = value
+	}
+
+	return result, nil
+}
@@ -0,0 +1,108 @@
+package errs
+
+import (
+	"fmt"
+)
+
+// Codes are part of the API: they are sent to clients as extensions.code and
+// must not change.
+const (
+	CodeNotFound        = "NOT_FOUND"
+	CodeForbidden       = "FORBIDDEN"
+	CodeUnauthenticated = "UNAUTHENTICATED"
+	CodeValidation      = "VALIDATION"
+	CodeConflict        = "CONFLICT"
+	CodeDeleted         = "DELETED"
+	CodeSlowConsumer    = "SLOW_CONSUMER"
+	CodeInternal        = "INTERNAL"
+)
+
+// Sentinels to compare against with errors.Is, any Error with the same code
+// matches them regardless of the message.
+var (
+	ErrNotFound        = &Error{Code: CodeNotFound, Message: "not found"}
+	ErrForbidden       = &Error{Code: CodeForbidden, Message: "forbidden"}
+	ErrUnauthenticated = &Error{Code: CodeUnauthenticated, Message: "not authenticated"}
+	ErrValidation      = &Error{Code: CodeValidation, Message: "invalid input"}
+	ErrConflict        = &Error{Code: CodeConflict, Message: "conflict"}
+	ErrDeleted         = &Error{Code: CodeDeleted, Message: "deleted"}
+	ErrSlowConsumer    = &Error{Code: CodeSlowConsumer, Message: "subscriber is too slow, events were dropped"}
+)
+
+// FieldError describes why a single input field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an error the client can act on. Anything else reaching the API is
+// treated as an internal error.
+type Error struct {
+	Code    string
+	Message string
+	Fields  []FieldError
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+func (e *Error) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{
+		"code": e.Code,
+	}
+
+	if len(e.Fields) > 0 {
+		extensions["fields"] = e.Fields
+	}
+
+	return extensions
+}
+
+func NotFound(format string, args ...any) error {
+	return newError(CodeNotFound, format, args...)
+}
+
+// Forbidden is returned when the caller is authenticated but is not allowed
+// to perform the action.
+func Forbidden(format string, args ...any) error {
+	return newError(CodeForbidden, format, args...)
+}
+
+func Unauthenticated(format string, args ...any) error {
+	return newError(CodeUnauthenticated, format, args...)
+}
+
+func Conflict(format string, args ...any) error {
+	return newError(CodeConflict, format, args...)
+}
+
+func Deleted(format string, args ...any) error {
+	return newError(CodeDeleted, format, args...)
+}
+
+// Validation is returned when the input is rejected, fields are reported in
+// the extensions so clients can highlight them.
+func Validation(fields []FieldError) error {
+	return &Error{
+		Code:    CodeValidation,
+		Message: "invalid input",
+		Fields:  fields,
+	}
+}
+
+func InvalidField(field string, message string) error {
+	return Validation([]FieldError{{Field: field, Message: message}})
+}
+
+func newError(code string, format string, args ...any) error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,50 @@
+package graph
+
+import (
+	"context"
+	"sync"
+)
+
+type streamErrorKey struct{}
+
+// streamError carries the error a subscription ended with from its resolver
+// to the handler, which sends it before completing the stream.
+type streamError struct {
+	mu  sync.Mutex
+	err error
+}
+
+// WithStreamError prepares ctx of a subscription operation to carry the error
+// it may end with.
+func WithStreamError(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamErrorKey{}, &streamError{})
+}
+
+// TakeStreamError returns the error the subscription ended with, only once.
+func TakeStreamError(ctx context.Context) error {
+	holder, ok := ctx.Value(streamErrorKey{}).(*streamError)
+	if !ok {
+		return nil
+	}
+
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+
+	err := holder.err
+	holder.err = nil
+	return err
+}
+
+// endStream records why the subscription is about to end. It has to be
+// called before the channel returned by the resolver is closed.
+func endStream(ctx context.Context, err error) {
+	holder, ok := ctx.Value(streamErrorKey{}).(*streamError)
+	if !ok || err == nil {
+		return
+	}
+
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+
+	holder.err = err
+}
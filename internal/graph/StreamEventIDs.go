@@ -0,0 +1,57 @@
+package graph
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+type streamEventIDsKey struct{}
+
+// streamEventIDs carries the IDs of the events a subscription sends from its
+// resolver to the handler, which adds them to the responses. The resolver
+// may already queue the next ID while a response is being written, so they
+// are kept in order.
+type streamEventIDs struct {
+	mu  sync.Mutex
+	ids []uint64
+}
+
+// WithStreamEventIDs prepares ctx of a subscription operation to carry the
+// IDs of its events.
+func WithStreamEventIDs(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamEventIDsKey{}, &streamEventIDs{})
+}
+
+// TakeStreamEventID returns the ID of the event of the next response.
+func TakeStreamEventID(ctx context.Context) (string, bool) {
+	holder, ok := ctx.Value(streamEventIDsKey{}).(*streamEventIDs)
+	if !ok {
+		return "", false
+	}
+
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+
+	if len(holder.ids) == 0 {
+		return "", false
+	}
+
+	id := holder.ids[0]
+	holder.ids = holder.ids[1:]
+	return strconv.FormatUint(id, 10), true
+}
+
+// sendingEvent records the ID of the event about to be sent. It has to be
+// called before the value is sent to the channel returned by the resolver.
+func sendingEvent(ctx context.Context, id uint64) {
+	holder, ok := ctx.Value(streamEventIDsKey{}).(*streamEventIDs)
+	if !ok {
+		return
+	}
+
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+
+	holder.ids = append(holder.ids, id)
+}
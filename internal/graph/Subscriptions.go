@@ -0,0 +1,121 @@
+package graph
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+	"github.com/k0ch3gar/ozon-task/internal/service"
+)
+
+// subscribe streams the events of a topic accepted by pick until ctx is done
+// or the broker disconnects the subscriber. With since set, the logged events
+// published after it come first.
+func subscribe[T any](
+	ctx context.Context,
+	ss *service.SubscriptionService,
+	topic string,
+	since *string,
+	pick func(*service.Event) (T, bool),
+) (<-chan T, error) {
+	// Subscribing before reading the log leaves no gap between the replayed
+	// and the live events, the ones in both are sent once.
+	sub := ss.Subscribe(topic, func(event *service.Event) bool {
+		_, ok := pick(event)
+		return ok
+	})
+
+	var replayed []*service.Event
+	if since != nil {
+		sinceId, err := strconv.ParseUint(*since, 10, 64)
+		if err != nil {
+			ss.Unsubscribe(sub)
+			return nil, errs.InvalidField("since", "must be an event id")
+		}
+
+		if replayed, err = ss.Replay(ctx, topic, sinceId); err != nil {
+			ss.Unsubscribe(sub)
+			return nil, err
+		}
+	}
+
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		defer ss.Unsubscribe(sub)
+
+		sent := make(map[uint64]struct{}, len(replayed))
+		// send reports false once ctx is done.
+		send := func(event *service.Event, replay bool) bool {
+			// The broker accepts live events with the same pick, replayed
+			// ones are only filtered here.
+			value, ok := pick(event)
+			if !ok {
+				return true
+			}
+
+			if replay {
+				sent[event.ID] = struct{}{}
+			} else if _, ok := sent[event.ID]; ok {
+				delete(sent, event.ID)
+				return true
+			}
+
+			sendingEvent(ctx, event.ID)
+			select {
+			case out <- value:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, event := range replayed {
+			if !send(event, true) {
+				return
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-sub.Events():
+				if !ok {
+					endStream(ctx, sub.Err())
+					return
+				}
+
+				if !send(event, false) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// commentsOfKind picks the comments of the events of the given kind.
+func commentsOfKind(kind model.CommentEventKind) func(*service.Event) (*model.Comment, bool) {
+	return func(event *service.Event) (*model.Comment, bool) {
+		if event.Comment == nil || event.Comment.Kind != kind {
+			return nil, false
+		}
+
+		return event.Comment.Comment, true
+	}
+}
+
+func commentEvents(event *service.Event) (*model.CommentEvent, bool) {
+	return event.Comment, event.Comment != nil
+}
+
+func postEvents(event *service.Event) (*model.PostEvent, bool) {
+	return event.Post, event.Post != nil
+}
+
+func notifications(event *service.Event) (*model.Notification, bool) {
+	return event.Notification, event.Notification != nil
+}
@@ -0,0 +1,288 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/k0ch3gar/ozon-task/internal/auth"
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+	"github.com/k0ch3gar/ozon-task/internal/service"
+	"github.com/k0ch3gar/ozon-task/internal/storage"
+	model2 "github.com/k0ch3gar/ozon-task/internal/storage/model"
+	"github.com/k0ch3gar/ozon-task/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain warns once per run that the Postgres tests are skipped, go test
+// shows t.Log and t.Skip output only with -v.
+func TestMain(m *testing.M) {
+	if os.Getenv("PG_ADDR") == "" {
+		fmt.Fprintln(os.Stderr, "WARNING: PG_ADDR is not set, the postgres storage and event bus tests are SKIPPED, run `make test-pg` to include them")
+	}
+
+	os.Exit(m.Run())
+}
+
+// testBackends returns resolvers over every storage backend available. The
+// Postgres one needs a migrated database configured through the PG_* variables.
+func testBackends(t *testing.T) map[string]*Resolver {
+	params := newTestParams()
+	backends := map[string]*Resolver{
+		"in-memory": newTestResolver(params),
+	}
+
+	if os.Getenv("PG_ADDR") == "" {
+		t.Log("PG_ADDR is not set, SKIPPING the postgres storage, run `make test-pg` to include it")
+		return backends
+	}
+
+	db, err := storage.NewDbConnection(storage.NewDbOpt())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { db.Close() })
+
+	backends["postgres"] = newTestPostgresResolver(params, db, service.NewInMemoryEventBus(params))
+
+	return backends
+}
+
+func newTestPostgresResolver(params config.ApplicationParameters, db *pg.DB, bus service.EventBus) *Resolver {
+	return newTestResolverWithBus(
+		params,
+		storage.NewDbUserStorage(db),
+		storage.NewDbPostStorage(db),
+		storage.NewDbCommentStorage(db),
+		storage.NewDbSessionStorage(db),
+		storage.NewDbNotificationStorage(db),
+		storage.NewDbWebhookStorage(db),
+		storage.NewDbLifecycleStorage(db),
+		bus,
+	)
+}
+
+func createTestUser(t *testing.T, resolver *Resolver, role string) context.Context {
+	userInput := model.UserInput{
+		Username: fmt.Sprintf("u%d", time.Now().UnixNano()%1_000_000_000_000),
+		Email:    fmt.Sprintf("%d@mail.ru", time.Now().UnixNano()),
+		Password: "baz",
+	}
+
+	user, err := resolver.Mutation().CreateUser(context.Background(), userInput)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	storageUser := utils.FromApiUser(user)
+	storageUser.Role = role
+	return auth.WithUser(context.Background(), storageUser)
+}
+
+func assertForbidden(t *testing.T, err error) {
+	assert.ErrorIs(t, err, errs.ErrForbidden)
+}
+
+func TestOwnershipChecks(t *testing.T) {
+	for name, resolver := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			authorCtx := createTestUser(t, resolver, model2.RoleUser)
+			strangerCtx := createTestUser(t, resolver, model2.RoleUser)
+			moderatorCtx := createTestUser(t, resolver, model2.RoleModerator)
+			adminCtx := createTestUser(t, resolver, model2.RoleAdmin)
+
+			post, err := resolver.Mutation().CreatePost(authorCtx, model.PostInput{Title: "title1", Body: "body1"})
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			comment, err := resolver.Mutation().CreateComment(authorCtx, model.CommentInput{ParentPostID: post.ID, Body: "body2"})
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			_, err = resolver.Mutation().UpdatePostTitle(strangerCtx, post.ID, "title2")
+			assertForbidden(t, err)
+
+			_, err = resolver.Mutation().UpdatePostBody(strangerCtx, post.ID, "body2")
+			assertForbidden(t, err)
+
+			allow := false
+			_, err = resolver.Mutation().UpdatePostCommentsAllowance(strangerCtx, post.ID, &allow)
+			assertForbidden(t, err)
+
+			_, err = resolver.Mutation().UpdateCommentBody(strangerCtx, comment.ID, "body3")
+			assertForbidden(t, err)
+
+			_, err = resolver.Mutation().DeleteComment(strangerCtx, comment.ID)
+			assertForbidden(t, err)
+
+			_, err = resolver.Mutation().DeletePost(strangerCtx, post.ID)
+			assertForbidden(t, err)
+
+			_, err = resolver.Mutation().UpdatePostTitle(context.Background(), post.ID, "title2")
+			assert.ErrorIs(t, err, errs.ErrUnauthenticated)
+
+			updatedPost, err := resolver.Mutation().UpdatePostTitle(authorCtx, post.ID, "title2")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.Equal(t, "title2", updatedPost.Title)
+
+			allow = false
+			if _, err = resolver.Mutation().UpdatePostCommentsAllowance(authorCtx, post.ID, &allow); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			_, err = resolver.Mutation().UpdateCommentBody(authorCtx, comment.ID, "body3")
+			assertForbidden(t, err)
+
+			// Moderators still act on posts with comments turned off.
+			updatedComment, err := resolver.Mutation().UpdateCommentBody(moderatorCtx, comment.ID, "body3")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.Equal(t, "body3", updatedComment.Body)
+
+			_, err = resolver.Mutation().DeleteComment(adminCtx, comment.ID)
+			assert.NoError(t, err)
+
+			_, err = resolver.Mutation().DeletePost(authorCtx, post.ID)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestModeratorDeletionsStick(t *testing.T) {
+	for name, resolver := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			authorCtx := createTestUser(t, resolver, model2.RoleUser)
+			moderatorCtx := createTestUser(t, resolver, model2.RoleModerator)
+
+			post, err := resolver.Mutation().CreatePost(authorCtx, model.PostInput{Title: "title1", Body: "body1"})
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			comment, err := resolver.Mutation().CreateComment(authorCtx, model.CommentInput{ParentPostID: post.ID, Body: "body2"})
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if _, err = resolver.Mutation().DeleteComment(moderatorCtx, comment.ID); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			_, err = resolver.Mutation().RestoreComment(authorCtx, comment.ID)
+			assertForbidden(t, err)
+
+			if _, err = resolver.Mutation().RestoreComment(moderatorCtx, comment.ID); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			// Authors still undo their own deletions.
+			if _, err = resolver.Mutation().DeleteComment(authorCtx, comment.ID); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if _, err = resolver.Mutation().RestoreComment(authorCtx, comment.ID); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if _, err = resolver.Mutation().DeletePost(moderatorCtx, post.ID); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			_, err = resolver.Mutation().RestorePost(authorCtx, post.ID)
+			assertForbidden(t, err)
+
+			restored, err := resolver.Mutation().RestorePost(moderatorCtx, post.ID)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.False(t, restored.Deleted)
+		})
+	}
+}
+
+func TestGrantAndRevokeRole(t *testing.T) {
+	for name, resolver := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			adminCtx := createTestUser(t, resolver, model2.RoleAdmin)
+			userCtx := createTestUser(t, resolver, model2.RoleUser)
+			user, _ := auth.UserFromContext(userCtx)
+			admin, _ := auth.UserFromContext(adminCtx)
+
+			granted, err := resolver.Mutation().GrantRole(adminCtx, user.ID, model.RoleModerator)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.Equal(t, model.RoleModerator, granted.Role)
+
+			revoked, err := resolver.Mutation().RevokeRole(adminCtx, user.ID, model.RoleModerator)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.Equal(t, model.RoleUser, revoked.Role)
+
+			_, err = resolver.Mutation().RevokeRole(adminCtx, admin.ID, model.RoleAdmin)
+			assertForbidden(t, err)
+
+			_, err = resolver.Mutation().GrantRole(adminCtx, admin.ID, model.RoleUser)
+			assertForbidden(t, err)
+
+			_, err = resolver.Mutation().DeleteUser(userCtx, admin.ID)
+			assertForbidden(t, err)
+
+			_, err = resolver.Mutation().DeleteUser(context.Background(), admin.ID)
+			assert.ErrorIs(t, err, errs.ErrUnauthenticated)
+
+			deleted, err := resolver.Mutation().DeleteUser(userCtx, user.ID)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.True(t, deleted.Deleted)
+		})
+	}
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	for name, resolver := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			user, _ := auth.UserFromContext(createTestUser(t, resolver, model2.RoleUser))
+
+			current, err := resolver.Mutation().Login(context.Background(), user.Username, "baz")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			other, err := resolver.Mutation().Login(context.Background(), user.Username, "baz")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			principal, err := resolver.as.Authenticate(context.Background(), current.AccessToken)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if _, err = resolver.Mutation().ChangePassword(auth.WithPrincipal(context.Background(), principal), "baz", "quux"); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			_, err = resolver.Mutation().RefreshToken(context.Background(), other.RefreshToken)
+			assert.Error(t, err, "sessions opened with the old password must be revoked")
+
+			_, err = resolver.as.Authenticate(context.Background(), other.AccessToken)
+			assert.Error(t, err)
+
+			_, err = resolver.Mutation().RefreshToken(context.Background(), current.RefreshToken)
+			assert.NoError(t, err, "the session changing the password stays signed in")
+		})
+	}
+}
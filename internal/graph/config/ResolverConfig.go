@@ -1,9 +1,36 @@
 package config
 
-import graph2 "github.com/k0ch3gar/ozon-task/internal/graph"
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/k0ch3gar/ozon-task/internal/auth"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	graph2 "github.com/k0ch3gar/ozon-task/internal/graph"
+	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+	"github.com/k0ch3gar/ozon-task/internal/utils"
+)
 
 func NewResolverConfig(resolver *graph2.Resolver) graph2.Config {
 	return graph2.Config{
 		Resolvers: resolver,
+		Directives: graph2.DirectiveRoot{
+			HasRole: HasRole,
+		},
+	}
+}
+
+// HasRole implements @hasRole: the field resolves only for callers with the
+// given role or a higher one.
+func HasRole(ctx context.Context, obj any, next graphql.Resolver, role model.Role) (any, error) {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errs.ErrUnauthenticated
 	}
+
+	if !auth.HasRole(user, utils.ToStorageRole(role)) {
+		return nil, errs.Forbidden("%s role is required", role)
+	}
+
+	return next(ctx)
 }
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"context"
+	"testing"
+
+	"github.com/k0ch3gar/ozon-task/internal/auth"
+	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+	model2 "github.com/k0ch3gar/ozon-task/internal/storage/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasRoleDirective(t *testing.T) {
+	next := func(ctx context.Context) (any, error) {
+		return "ok", nil
+	}
+
+	_, err := HasRole(context.Background(), nil, next, model.RoleModerator)
+	assert.Error(t, err)
+
+	userCtx := auth.WithUser(context.Background(), &model2.User{ID: "0", Role: model2.RoleUser})
+	_, err = HasRole(userCtx, nil, next, model.RoleModerator)
+	assert.Error(t, err)
+
+	moderatorCtx := auth.WithUser(context.Background(), &model2.User{ID: "1", Role: model2.RoleModerator})
+	res, err := HasRole(moderatorCtx, nil, next, model.RoleModerator)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res)
+
+	_, err = HasRole(moderatorCtx, nil, next, model.RoleAdmin)
+	assert.Error(t, err)
+
+	adminCtx := auth.WithUser(context.Background(), &model2.User{ID: "2", Role: model2.RoleAdmin})
+	_, err = HasRole(adminCtx, nil, next, model.RoleModerator)
+	assert.NoError(t, err)
+}
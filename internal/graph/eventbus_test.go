@@ -0,0 +1,161 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+	"github.com/k0ch3gar/ozon-task/internal/service"
+	"github.com/k0ch3gar/ozon-task/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/fx/fxtest"
+)
+
+// TestEventsReachOtherInstances runs two servers on one database: the
+// changes made through one of them reach the subscribers of the other.
+func TestEventsReachOtherInstances(t *testing.T) {
+	if os.Getenv("PG_ADDR") == "" {
+		t.Skip("PG_ADDR is not set, the postgres event bus needs a database, run `make test-pg` to include it")
+	}
+
+	params := newTestParams()
+	newInstance := func() *Resolver {
+		db, err := storage.NewDbConnection(storage.NewDbOpt())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		t.Cleanup(func() { db.Close() })
+
+		lc := fxtest.NewLifecycle(t)
+		resolver := newTestPostgresResolver(params, db, service.NewDbEventBus(lc, params, db))
+		lc.RequireStart()
+		t.Cleanup(lc.RequireStop)
+
+		return resolver
+	}
+
+	first := newInstance()
+	second := newInstance()
+
+	ctx := createTestUser(t, first, "user")
+	post, err := first.Mutation().CreatePost(ctx, model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	local, _ := first.Subscription().CommentEvents(subCtx, post.ID, nil)
+	remote, _ := second.Subscription().CommentEvents(subCtx, post.ID, nil)
+	activity, _ := second.Subscription().MyActivity(subCtx, nil)
+	postEvents, _ := second.Subscription().PostUpdated(subCtx, post.ID, nil)
+
+	commenter := createTestUser(t, second, "user")
+	comment, err := second.Mutation().CreateComment(commenter, model.CommentInput{Body: "body", ParentPostID: post.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, comment.ID, receive(t, local).Comment.ID)
+	assert.Equal(t, comment.ID, receive(t, remote).Comment.ID)
+
+	event := receive(t, activity)
+	assert.Equal(t, model.CommentEventKindCreated, event.Kind)
+	assert.Equal(t, post.ID, event.Comment.ParentPostID)
+
+	// The replay log is shared as well.
+	since := "0"
+	replayed, err := first.Subscription().CommentCreated(subCtx, post.ID, &since)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, comment.ID, receive(t, replayed).ID)
+
+	if _, err = first.Mutation().DeletePost(ctx, post.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	postEvent := receive(t, postEvents)
+	assert.Equal(t, model.PostEventKindDeleted, postEvent.Kind)
+	assert.Equal(t, post.ID, postEvent.Post.ID)
+}
+
+// TestConcurrentPublishesBecomeVisibleInOrder checks that an event is never
+// visible in a replay log before the earlier events of its topic, a client
+// resuming after it would skip them for good.
+func TestConcurrentPublishesBecomeVisibleInOrder(t *testing.T) {
+	if os.Getenv("PG_ADDR") == "" {
+		t.Skip("PG_ADDR is not set, the postgres event bus needs a database, run `make test-pg` to include it")
+	}
+
+	db, err := storage.NewDbConnection(storage.NewDbOpt())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer db.Close()
+
+	const publishers = 50
+	params := newTestParams()
+	params.ReplayLogSize = publishers
+	bus := service.NewDbEventBus(fxtest.NewLifecycle(t), params, db)
+	topic := service.PostTopic(fmt.Sprintf("concurrent-%d", time.Now().UnixNano()))
+
+	ctx := context.Background()
+	done := make(chan struct{})
+	var snapshots [][]uint64
+	var readErr error
+	go func() {
+		defer close(done)
+		for len(snapshots) == 0 || len(snapshots[len(snapshots)-1]) < publishers {
+			messages, err := bus.Replay(topic, 0, ctx)
+			if err != nil {
+				readErr = err
+				return
+			}
+
+			ids := make([]uint64, 0, len(messages))
+			for _, message := range messages {
+				ids = append(ids, message.ID)
+			}
+			snapshots = append(snapshots, ids)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range publishers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, bus.Publish(&service.BusMessage{Topics: []string{topic}, Event: &service.Event{}}, ctx))
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the published events")
+	}
+
+	if readErr != nil {
+		t.Fatal(readErr.Error())
+	}
+
+	all := snapshots[len(snapshots)-1]
+	for _, ids := range snapshots {
+		if len(ids) == 0 {
+			continue
+		}
+
+		// Every event published before the latest visible one is visible too.
+		last := slices.Index(all, ids[len(ids)-1])
+		assert.Equal(t, all[:last+1], ids)
+	}
+}
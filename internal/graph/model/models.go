@@ -0,0 +1,29 @@
+package model
+
+var DeadComment = &Comment{
+	ID:              "-1",
+	AuthorID:        nil,
+	Body:            "DELETED",
+	ParentCommentID: nil,
+	CreatedAt:       "DELETED",
+	Deleted:         true,
+}
+
+var DeadPost = &Post{
+	ID:            "-1",
+	AuthorID:      nil,
+	Title:         "DELETED",
+	Body:          "DELETED",
+	AllowComments: false,
+	CreatedAt:     "DELETED",
+	Deleted:       true,
+}
+
+var DeadUser = &User{
+	ID:        "-1",
+	Username:  "DELETED",
+	Email:     "DELETED",
+	Role:      RoleUser,
+	CreatedAt: "DELETED",
+	Deleted:   true,
+}
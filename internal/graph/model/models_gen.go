@@ -2,6 +2,20 @@
 
 package model
 
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type AuthPayload struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresAt    string `json:"expiresAt"`
+	User         *User  `json:"user"`
+}
+
 type Comment struct {
 	ID              string  `json:"id"`
 	AuthorID        *string `json:"authorId,omitempty"`
@@ -12,49 +26,115 @@ type Comment struct {
 	Deleted         bool    `json:"deleted"`
 }
 
+type CommentConnection struct {
+	Edges      []*CommentEdge `json:"edges"`
+	PageInfo   *PageInfo      `json:"pageInfo"`
+	TotalCount int32          `json:"totalCount"`
+}
+
+type CommentEdge struct {
+	Cursor string   `json:"cursor"`
+	Node   *Comment `json:"node"`
+}
+
+type CommentEvent struct {
+	ID      string           `json:"id"`
+	Kind    CommentEventKind `json:"kind"`
+	Comment *Comment         `json:"comment"`
+}
+
 type CommentInput struct {
-	AuthorID        string  `json:"authorId"`
 	Body            string  `json:"body"`
 	ParentPostID    string  `json:"parentPostId"`
 	ParentCommentID *string `json:"parentCommentId,omitempty"`
 }
 
-var DeadComment = &Comment {
-	ID: "-1",
-	AuthorID: nil,
-	Body: "DELETED",
-	ParentCommentID: nil,
-	CreatedAt: "DELETED",
-	Deleted: true,
+type CommentThread struct {
+	Nodes        []*CommentThreadNode `json:"nodes"`
+	HasMoreRoots bool                 `json:"hasMoreRoots"`
+	EndCursor    *string              `json:"endCursor,omitempty"`
+}
+
+type CommentThreadNode struct {
+	Comment         *Comment `json:"comment"`
+	Depth           int32    `json:"depth"`
+	Path            []string `json:"path"`
+	Cursor          string   `json:"cursor"`
+	HasMoreChildren bool     `json:"hasMoreChildren"`
 }
 
 type Mutation struct {
 }
 
+type Notification struct {
+	ID        string           `json:"id"`
+	Kind      NotificationKind `json:"kind"`
+	CommentID string           `json:"commentId"`
+	PostID    string           `json:"postId"`
+	CreatedAt string           `json:"createdAt"`
+	Read      bool             `json:"read"`
+}
+
+type NotificationConnection struct {
+	Edges      []*NotificationEdge `json:"edges"`
+	PageInfo   *PageInfo           `json:"pageInfo"`
+	TotalCount int32               `json:"totalCount"`
+}
+
+type NotificationEdge struct {
+	Cursor string        `json:"cursor"`
+	Node   *Notification `json:"node"`
+}
+
+type NotificationPreferences struct {
+	Replies  bool `json:"replies"`
+	Comments bool `json:"comments"`
+	Mentions bool `json:"mentions"`
+}
+
+type NotificationPreferencesInput struct {
+	Replies  *bool `json:"replies,omitempty"`
+	Comments *bool `json:"comments,omitempty"`
+	Mentions *bool `json:"mentions,omitempty"`
+}
+
+type PageInfo struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor,omitempty"`
+	EndCursor       *string `json:"endCursor,omitempty"`
+}
+
 type Post struct {
-	ID            string    `json:"id"`
-	AuthorID      *string   `json:"authorId,omitempty"`
-	Title         string    `json:"title"`
-	Body          string    `json:"body"`
-	AllowComments bool      `json:"allowComments"`
-	CreatedAt     string    `json:"createdAt"`
-	Deleted       bool      `json:"deleted"`
+	ID            string  `json:"id"`
+	AuthorID      *string `json:"authorId,omitempty"`
+	Title         string  `json:"title"`
+	Body          string  `json:"body"`
+	AllowComments bool    `json:"allowComments"`
+	CreatedAt     string  `json:"createdAt"`
+	Deleted       bool    `json:"deleted"`
+}
+
+type PostConnection struct {
+	Edges      []*PostEdge `json:"edges"`
+	PageInfo   *PageInfo   `json:"pageInfo"`
+	TotalCount int32       `json:"totalCount"`
+}
+
+type PostEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *Post  `json:"node"`
 }
 
-var DeadPost = &Post {
-	ID: "-1",
-	AuthorID: nil,
-	Title: "DELETED",
-	Body: "DELETED",
-	AllowComments: false,
-	CreatedAt: "DELETED",
-	Deleted: true,
+type PostEvent struct {
+	ID   string        `json:"id"`
+	Kind PostEventKind `json:"kind"`
+	Post *Post         `json:"post"`
 }
 
 type PostInput struct {
-	AuthorID string `json:"authorId"`
-	Title    string `json:"title"`
-	Body     string `json:"body"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
 }
 
 type Query struct {
@@ -67,20 +147,407 @@ type User struct {
 	ID        string `json:"id"`
 	Username  string `json:"username"`
 	Email     string `json:"email"`
+	Role      Role   `json:"role"`
 	CreatedAt string `json:"createdAt"`
 	Deleted   bool   `json:"deleted"`
 }
 
-var DeadUser = &User {
-	ID: "-1",
-	Username: "DELETED",
-	Email: "DELETED",
-	CreatedAt: "DELETED",
-	Deleted: true,
-}
-
 type UserInput struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+
+type Webhook struct {
+	ID        string             `json:"id"`
+	URL       string             `json:"url"`
+	Events    []WebhookEventType `json:"events"`
+	CreatedAt string             `json:"createdAt"`
+}
+
+type WebhookDelivery struct {
+	ID             string                `json:"id"`
+	WebhookID      string                `json:"webhookId"`
+	Event          WebhookEventType      `json:"event"`
+	Payload        string                `json:"payload"`
+	Status         WebhookDeliveryStatus `json:"status"`
+	Attempts       int32                 `json:"attempts"`
+	NextAttemptAt  *string               `json:"nextAttemptAt,omitempty"`
+	LastError      *string               `json:"lastError,omitempty"`
+	LastStatusCode *int32                `json:"lastStatusCode,omitempty"`
+	CreatedAt      string                `json:"createdAt"`
+	DeliveredAt    *string               `json:"deliveredAt,omitempty"`
+}
+
+type WebhookDeliveryConnection struct {
+	Edges      []*WebhookDeliveryEdge `json:"edges"`
+	PageInfo   *PageInfo              `json:"pageInfo"`
+	TotalCount int32                  `json:"totalCount"`
+}
+
+type WebhookDeliveryEdge struct {
+	Cursor string           `json:"cursor"`
+	Node   *WebhookDelivery `json:"node"`
+}
+
+type WebhookInput struct {
+	URL    string             `json:"url"`
+	Events []WebhookEventType `json:"events"`
+	Secret string             `json:"secret"`
+}
+
+type CommentEventKind string
+
+const (
+	CommentEventKindCreated  CommentEventKind = "CREATED"
+	CommentEventKindUpdated  CommentEventKind = "UPDATED"
+	CommentEventKindDeleted  CommentEventKind = "DELETED"
+	CommentEventKindRestored CommentEventKind = "RESTORED"
+)
+
+var AllCommentEventKind = []CommentEventKind{
+	CommentEventKindCreated,
+	CommentEventKindUpdated,
+	CommentEventKindDeleted,
+	CommentEventKindRestored,
+}
+
+func (e CommentEventKind) IsValid() bool {
+	switch e {
+	case CommentEventKindCreated, CommentEventKindUpdated, CommentEventKindDeleted, CommentEventKindRestored:
+		return true
+	}
+	return false
+}
+
+func (e CommentEventKind) String() string {
+	return string(e)
+}
+
+func (e *CommentEventKind) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = CommentEventKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid CommentEventKind", str)
+	}
+	return nil
+}
+
+func (e CommentEventKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *CommentEventKind) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e CommentEventKind) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type NotificationKind string
+
+const (
+	NotificationKindReply   NotificationKind = "REPLY"
+	NotificationKindComment NotificationKind = "COMMENT"
+	NotificationKindMention NotificationKind = "MENTION"
+)
+
+var AllNotificationKind = []NotificationKind{
+	NotificationKindReply,
+	NotificationKindComment,
+	NotificationKindMention,
+}
+
+func (e NotificationKind) IsValid() bool {
+	switch e {
+	case NotificationKindReply, NotificationKindComment, NotificationKindMention:
+		return true
+	}
+	return false
+}
+
+func (e NotificationKind) String() string {
+	return string(e)
+}
+
+func (e *NotificationKind) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = NotificationKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid NotificationKind", str)
+	}
+	return nil
+}
+
+func (e NotificationKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *NotificationKind) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e NotificationKind) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type PostEventKind string
+
+const (
+	PostEventKindCreated  PostEventKind = "CREATED"
+	PostEventKindUpdated  PostEventKind = "UPDATED"
+	PostEventKindDeleted  PostEventKind = "DELETED"
+	PostEventKindRestored PostEventKind = "RESTORED"
+)
+
+var AllPostEventKind = []PostEventKind{
+	PostEventKindCreated,
+	PostEventKindUpdated,
+	PostEventKindDeleted,
+	PostEventKindRestored,
+}
+
+func (e PostEventKind) IsValid() bool {
+	switch e {
+	case PostEventKindCreated, PostEventKindUpdated, PostEventKindDeleted, PostEventKindRestored:
+		return true
+	}
+	return false
+}
+
+func (e PostEventKind) String() string {
+	return string(e)
+}
+
+func (e *PostEventKind) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = PostEventKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid PostEventKind", str)
+	}
+	return nil
+}
+
+func (e PostEventKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *PostEventKind) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e PostEventKind) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type Role string
+
+const (
+	RoleUser      Role = "USER"
+	RoleModerator Role = "MODERATOR"
+	RoleAdmin     Role = "ADMIN"
+)
+
+var AllRole = []Role{
+	RoleUser,
+	RoleModerator,
+	RoleAdmin,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *Role) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e Role) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryStatusPending   WebhookDeliveryStatus = "PENDING"
+	WebhookDeliveryStatusDelivered WebhookDeliveryStatus = "DELIVERED"
+	WebhookDeliveryStatusDead      WebhookDeliveryStatus = "DEAD"
+)
+
+var AllWebhookDeliveryStatus = []WebhookDeliveryStatus{
+	WebhookDeliveryStatusPending,
+	WebhookDeliveryStatusDelivered,
+	WebhookDeliveryStatusDead,
+}
+
+func (e WebhookDeliveryStatus) IsValid() bool {
+	switch e {
+	case WebhookDeliveryStatusPending, WebhookDeliveryStatusDelivered, WebhookDeliveryStatusDead:
+		return true
+	}
+	return false
+}
+
+func (e WebhookDeliveryStatus) String() string {
+	return string(e)
+}
+
+func (e *WebhookDeliveryStatus) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookDeliveryStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookDeliveryStatus", str)
+	}
+	return nil
+}
+
+func (e WebhookDeliveryStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *WebhookDeliveryStatus) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e WebhookDeliveryStatus) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type WebhookEventType string
+
+const (
+	WebhookEventTypePostCreated     WebhookEventType = "POST_CREATED"
+	WebhookEventTypePostUpdated     WebhookEventType = "POST_UPDATED"
+	WebhookEventTypePostDeleted     WebhookEventType = "POST_DELETED"
+	WebhookEventTypePostRestored    WebhookEventType = "POST_RESTORED"
+	WebhookEventTypeCommentCreated  WebhookEventType = "COMMENT_CREATED"
+	WebhookEventTypeCommentUpdated  WebhookEventType = "COMMENT_UPDATED"
+	WebhookEventTypeCommentDeleted  WebhookEventType = "COMMENT_DELETED"
+	WebhookEventTypeCommentRestored WebhookEventType = "COMMENT_RESTORED"
+)
+
+var AllWebhookEventType = []WebhookEventType{
+	WebhookEventTypePostCreated,
+	WebhookEventTypePostUpdated,
+	WebhookEventTypePostDeleted,
+	WebhookEventTypePostRestored,
+	WebhookEventTypeCommentCreated,
+	WebhookEventTypeCommentUpdated,
+	WebhookEventTypeCommentDeleted,
+	WebhookEventTypeCommentRestored,
+}
+
+func (e WebhookEventType) IsValid() bool {
+	switch e {
+	case WebhookEventTypePostCreated, WebhookEventTypePostUpdated, WebhookEventTypePostDeleted, WebhookEventTypePostRestored, WebhookEventTypeCommentCreated, WebhookEventTypeCommentUpdated, WebhookEventTypeCommentDeleted, WebhookEventTypeCommentRestored:
+		return true
+	}
+	return false
+}
+
+func (e WebhookEventType) String() string {
+	return string(e)
+}
+
+func (e *WebhookEventType) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookEventType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookEventType", str)
+	}
+	return nil
+}
+
+func (e WebhookEventType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *WebhookEventType) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e WebhookEventType) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
@@ -13,6 +13,9 @@ type Resolver struct {
 	ps *service.PostService
 	cs *service.CommentService
 	ss *service.SubscriptionService
+	as *service.AuthService
+	ns *service.NotificationService
+	ws *service.WebhookService
 }
 
 func NewResolver(
@@ -20,11 +23,17 @@ func NewResolver(
 	ps *service.PostService,
 	cs *service.CommentService,
 	ss *service.SubscriptionService,
+	as *service.AuthService,
+	ns *service.NotificationService,
+	ws *service.WebhookService,
 ) *Resolver {
 	return &Resolver{
 		us: us,
 		ps: ps,
 		cs: cs,
 		ss: ss,
+		as: as,
+		ns: ns,
+		ws: ws,
 	}
 }
@@ -2,50 +2,172 @@ package graph
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/k0ch3gar/ozon-task/internal/auth"
 	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/dataloader"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
 	"github.com/k0ch3gar/ozon-task/internal/graph/model"
 	"github.com/k0ch3gar/ozon-task/internal/service"
 	"github.com/k0ch3gar/ozon-task/internal/storage"
+	model2 "github.com/k0ch3gar/ozon-task/internal/storage/model"
+	"github.com/k0ch3gar/ozon-task/internal/utils"
+	"github.com/k0ch3gar/ozon-task/internal/validation"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/fx/fxtest"
 )
 
-func TestUserCreated(t *testing.T) {
-	params := config.ApplicationParameters{
+func newTestParams() config.ApplicationParameters {
+	return config.ApplicationParameters{
 		StorageShardsCount:    6,
 		Port:                  "8080",
 		PersistentStorageType: false,
 		Debug:                 true,
 		PageSize:              1,
+		MaxPageSize:           10,
+		AccessTokenTTL:        time.Minute,
+		RefreshTokenTTL:       time.Hour,
+		MaxUsernameLength:     32,
+		MaxEmailLength:        64,
+		MaxPasswordLength:     128,
+		MaxTitleLength:        255,
+		MaxPostBodyLength:     20000,
+		MaxCommentBodyLength:  2000,
+		MaxCommentDepth:       2,
+		ThreadDepth:           2,
+		MaxThreadDepth:        5,
+		MaxThreadNodes:        100,
+		DataLoaderWait:        time.Millisecond,
+		DataLoaderMaxBatch:    100,
+		UserContentPolicy:     config.AnonymizeUserContent,
+		RetentionPeriod:       time.Hour,
+		PurgeInterval:         time.Hour,
+		SubscriberBufferSize:  2,
+		SubscriberOverflow:    config.DropOldest,
+		EventBus:              config.InMemoryEventBus,
+		ReplayLogSize:         3,
+		ReplayLogTTL:          time.Hour,
+		WebhookTimeout:        time.Second,
+		WebhookMaxAttempts:    3,
+		WebhookBackoff:        time.Millisecond,
+		WebhookMaxBackoff:     time.Millisecond,
+		WebhookPollInterval:   time.Hour,
 	}
+}
 
-	u := storage.NewInMemoryUserStorage(params)
-	p := storage.NewInMemoryPostStorage(params)
-	c := storage.NewInMemoryCommentStorage(params)
+func newTestResolver(params config.ApplicationParameters) *Resolver {
+	return newTestResolverWithStorage(
+		params,
+		storage.NewInMemoryUserStorage(params),
+		storage.NewInMemoryPostStorage(params),
+		storage.NewInMemoryCommentStorage(params),
+		storage.NewInMemoryNotificationStorage(params),
+		storage.NewInMemorySessionStorage(params),
+	)
+}
+
+func newTestResolverWithStorage(
+	params config.ApplicationParameters,
+	u *storage.UserStorageInMemory,
+	p *storage.PostStorageInMemory,
+	c *storage.CommentStorageInMemory,
+	n *storage.NotificationStorageInMemory,
+	sessions storage.SessionStorage,
+) *Resolver {
+	return newTestResolverWithBus(
+		params,
+		u,
+		p,
+		c,
+		sessions,
+		n,
+		storage.NewInMemoryWebhookStorage(params),
+		storage.NewInMemoryLifecycleStorage(u, p, c, n),
+		service.NewInMemoryEventBus(params),
+	)
+}
 
-	resolver := NewResolver(
+func newTestResolverWithBus(
+	params config.ApplicationParameters,
+	u storage.UserStorage,
+	p storage.PostStorage,
+	c storage.CommentStorage,
+	sessions storage.SessionStorage,
+	n storage.NotificationStorage,
+	w storage.WebhookStorage,
+	lifecycle storage.LifecycleStorage,
+	bus service.EventBus,
+) *Resolver {
+	hasher := auth.NewArgon2PasswordHasher()
+	validator := validation.NewValidator(params)
+	tokens := auth.NewTokenManager(auth.TokenOpt{
+		Secret:          []byte("secret"),
+		AccessTokenTTL:  params.AccessTokenTTL,
+		RefreshTokenTTL: params.RefreshTokenTTL,
+	})
+
+	events := service.NewSubscriptionService(params, bus)
+	notifications := service.NewNotificationService(params, n, u, events)
+	return NewResolver(
 		service.NewUserService(
+			params,
 			u,
+			lifecycle,
+			sessions,
+			hasher,
+			validator,
+			events,
 		),
 		service.NewPostService(
 			params,
 			p,
 			u,
+			lifecycle,
+			events,
+			validator,
 		),
 		service.NewCommentService(
 			u,
+			p,
 			c,
+			lifecycle,
+			events,
+			notifications,
+			validator,
 			params,
 		),
-		service.NewSubscriptionService(),
+		events,
+		service.NewAuthService(
+			u,
+			sessions,
+			hasher,
+			tokens,
+		),
+		notifications,
+		service.NewWebhookService(params, w, events, validator),
 	)
+}
+
+func TestUserCreated(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
 
 	userInput := model.UserInput{
 		Username: "foo",
-		Email:    "bar",
+		Email:    "bar@mail.ru",
 		Password: "baz",
 	}
 
@@ -60,38 +182,11 @@ func TestUserCreated(t *testing.T) {
 }
 
 func TestUserExistence(t *testing.T) {
-	params := config.ApplicationParameters{
-		StorageShardsCount:    6,
-		Port:                  "8080",
-		PersistentStorageType: false,
-		Debug:                 true,
-		PageSize:              1,
-	}
-
-	u := storage.NewInMemoryUserStorage(params)
-	p := storage.NewInMemoryPostStorage(params)
-	c := storage.NewInMemoryCommentStorage(params)
-
-	resolver := NewResolver(
-		service.NewUserService(
-			u,
-		),
-		service.NewPostService(
-			params,
-			p,
-			u,
-		),
-		service.NewCommentService(
-			u,
-			c,
-			params,
-		),
-		service.NewSubscriptionService(),
-	)
+	resolver := newTestResolver(newTestParams())
 
 	userInput := model.UserInput{
 		Username: "foo",
-		Email:    "bar",
+		Email:    "bar@mail.ru",
 		Password: "baz",
 	}
 
@@ -111,38 +206,11 @@ func TestUserExistence(t *testing.T) {
 }
 
 func TestPostCreationAndExistence(t *testing.T) {
-	params := config.ApplicationParameters{
-		StorageShardsCount:    6,
-		Port:                  "8080",
-		PersistentStorageType: false,
-		Debug:                 true,
-		PageSize:              1,
-	}
-
-	u := storage.NewInMemoryUserStorage(params)
-	p := storage.NewInMemoryPostStorage(params)
-	c := storage.NewInMemoryCommentStorage(params)
-
-	resolver := NewResolver(
-		service.NewUserService(
-			u,
-		),
-		service.NewPostService(
-			params,
-			p,
-			u,
-		),
-		service.NewCommentService(
-			u,
-			c,
-			params,
-		),
-		service.NewSubscriptionService(),
-	)
+	resolver := newTestResolver(newTestParams())
 
 	userInput := model.UserInput{
 		Username: "foo",
-		Email:    "bar",
+		Email:    "bar@mail.ru",
 		Password: "baz",
 	}
 
@@ -152,10 +220,10 @@ func TestPostCreationAndExistence(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
 	postInput := model.PostInput{
-		AuthorID: user.ID,
-		Title:    "title1",
-		Body:     "body1",
+		Title: "title1",
+		Body:  "body1",
 	}
 
 	post, err := resolver.Mutation().CreatePost(ctx, postInput)
@@ -177,39 +245,24 @@ func TestPostCreationAndExistence(t *testing.T) {
 	assert.Equal(t, post.Title, postInput.Title)
 }
 
-func TestCommentCreationAndExistence(t *testing.T) {
-	params := config.ApplicationParameters{
-		StorageShardsCount:    6,
-		Port:                  "8080",
-		PersistentStorageType: false,
-		Debug:                 true,
-		PageSize:              1,
+func TestPostCreationRequiresAuthentication(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	postInput := model.PostInput{
+		Title: "title1",
+		Body:  "body1",
 	}
 
-	u := storage.NewInMemoryUserStorage(params)
-	p := storage.NewInMemoryPostStorage(params)
-	c := storage.NewInMemoryCommentStorage(params)
+	_, err := resolver.Mutation().CreatePost(context.Background(), postInput)
+	assert.Error(t, err)
+}
 
-	resolver := NewResolver(
-		service.NewUserService(
-			u,
-		),
-		service.NewPostService(
-			params,
-			p,
-			u,
-		),
-		service.NewCommentService(
-			u,
-			c,
-			params,
-		),
-		service.NewSubscriptionService(),
-	)
+func TestCommentCreationAndExistence(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
 
 	userInput := model.UserInput{
 		Username: "foo",
-		Email:    "bar",
+		Email:    "bar@mail.ru",
 		Password: "baz",
 	}
 
@@ -219,10 +272,10 @@ func TestCommentCreationAndExistence(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
 	postInput := model.PostInput{
-		AuthorID: user.ID,
-		Title:    "title1",
-		Body:     "body1",
+		Title: "title1",
+		Body:  "body1",
 	}
 
 	post, err := resolver.Mutation().CreatePost(ctx, postInput)
@@ -231,7 +284,6 @@ func TestCommentCreationAndExistence(t *testing.T) {
 	}
 
 	commentInput := model.CommentInput{
-		AuthorID:     user.ID,
 		ParentPostID: post.ID,
 		Body:         "body2",
 	}
@@ -247,38 +299,11 @@ func TestCommentCreationAndExistence(t *testing.T) {
 	assert.True(t, comment.ParentCommentID == nil)
 }
 func TestCommentSubscription(t *testing.T) {
-	params := config.ApplicationParameters{
-		StorageShardsCount:    6,
-		Port:                  "8080",
-		PersistentStorageType: false,
-		Debug:                 true,
-		PageSize:              1,
-	}
-
-	u := storage.NewInMemoryUserStorage(params)
-	p := storage.NewInMemoryPostStorage(params)
-	c := storage.NewInMemoryCommentStorage(params)
-
-	resolver := NewResolver(
-		service.NewUserService(
-			u,
-		),
-		service.NewPostService(
-			params,
-			p,
-			u,
-		),
-		service.NewCommentService(
-			u,
-			c,
-			params,
-		),
-		service.NewSubscriptionService(),
-	)
+	resolver := newTestResolver(newTestParams())
 
 	userInput := model.UserInput{
 		Username: "foo",
-		Email:    "bar",
+		Email:    "bar@mail.ru",
 		Password: "baz",
 	}
 
@@ -288,10 +313,10 @@ func TestCommentSubscription(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
 	postInput := model.PostInput{
-		AuthorID: user.ID,
-		Title:    "title1",
-		Body:     "body1",
+		Title: "title1",
+		Body:  "body1",
 	}
 
 	post, err := resolver.Mutation().CreatePost(ctx, postInput)
@@ -300,12 +325,11 @@ func TestCommentSubscription(t *testing.T) {
 	}
 
 	commentInput := model.CommentInput{
-		AuthorID:     user.ID,
 		ParentPostID: post.ID,
 		Body:         "body2",
 	}
 
-	ch, err := resolver.Subscription().CommentCreated(ctx, post.ID)
+	ch, err := resolver.Subscription().CommentCreated(ctx, post.ID, nil)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
@@ -343,3 +367,1797 @@ func TestCommentSubscription(t *testing.T) {
 	assert.Equal(t, comment.ParentPostID, post.ID)
 	assert.True(t, comment.ParentCommentID == nil)
 }
+
+func TestLoginRefreshAndLogout(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	userInput := model.UserInput{
+		Username: "foo",
+		Email:    "bar@mail.ru",
+		Password: "baz",
+	}
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, userInput)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = resolver.Mutation().Login(ctx, userInput.Username, "qux")
+	assert.Error(t, err)
+
+	payload, err := resolver.Mutation().Login(ctx, userInput.Username, userInput.Password)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, user.ID, payload.User.ID)
+
+	principal, err := resolver.as.Authenticate(ctx, payload.AccessToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	authCtx := auth.WithPrincipal(ctx, principal)
+	me, err := resolver.Query().Me(authCtx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, user.ID, me.ID)
+
+	refreshed, err := resolver.Mutation().RefreshToken(ctx, payload.RefreshToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = resolver.Mutation().RefreshToken(ctx, payload.RefreshToken)
+	assert.Error(t, err, "rotated refresh token must not be accepted twice")
+
+	_, err = resolver.Mutation().RefreshToken(ctx, refreshed.RefreshToken)
+	assert.Error(t, err, "session must be revoked after refresh token reuse")
+
+	payload, err = resolver.Mutation().Login(ctx, userInput.Username, userInput.Password)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	principal, err = resolver.as.Authenticate(ctx, payload.AccessToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ok, err := resolver.Mutation().Logout(auth.WithPrincipal(ctx, principal))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.True(t, ok)
+
+	_, err = resolver.as.Authenticate(ctx, payload.AccessToken)
+	assert.Error(t, err)
+}
+
+func TestUpdateUser(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "foo", Email: "foo@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "bar", Email: "bar@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
+
+	_, err = resolver.Mutation().UpdateUsername(ctx, "bar")
+	assert.Error(t, err)
+
+	_, err = resolver.Mutation().UpdateEmail(ctx, "bar@mail.ru")
+	assert.Error(t, err)
+
+	renamed, err := resolver.Mutation().UpdateUsername(ctx, "qux")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, "qux", renamed.Username)
+
+	_, err = resolver.Query().UserByName(ctx, "foo")
+	assert.Error(t, err, "old username must not resolve after rename")
+
+	byName, err := resolver.Query().UserByName(ctx, "qux")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, user.ID, byName.ID)
+
+	_, err = resolver.Mutation().CreateUser(context.Background(), model.UserInput{Username: "foo", Email: "foo2@mail.ru", Password: "baz"})
+	assert.NoError(t, err, "old username must be free after rename")
+
+	updated, err := resolver.Mutation().UpdateEmail(ctx, "qux@mail.ru")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, "qux@mail.ru", updated.Email)
+
+	_, err = resolver.Mutation().ChangePassword(ctx, "wrong", "quux")
+	assert.Error(t, err)
+
+	ok, err := resolver.Mutation().ChangePassword(ctx, "baz", "quux")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.True(t, ok)
+
+	_, err = resolver.Mutation().Login(context.Background(), "qux", "baz")
+	assert.Error(t, err)
+
+	_, err = resolver.Mutation().Login(context.Background(), "qux", "quux")
+	assert.NoError(t, err)
+}
+
+func TestUserKeysAreCaseInsensitive(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "Foo", Email: "Foo@Mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "fOO", Email: "other@mail.ru", Password: "baz"})
+	assert.Error(t, err)
+
+	_, err = resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "other", Email: "foo@mail.RU", Password: "baz"})
+	assert.Error(t, err)
+
+	byName, err := resolver.Query().UserByName(ctx, "foo")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, user.ID, byName.ID)
+	assert.Equal(t, "Foo", byName.Username)
+
+	byEmail, err := resolver.Query().UserByEmail(ctx, "FOO@MAIL.RU")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, user.ID, byEmail.ID)
+
+	_, err = resolver.Mutation().Login(ctx, "FOO", "baz")
+	assert.NoError(t, err)
+}
+
+func TestPostsCursorPagination(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "foo", Email: "bar@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
+	var ids []string
+	for _, title := range []string{"title1", "title2", "title3", "title4", "title5"} {
+		post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: title, Body: "body"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		ids = append(ids, post.ID)
+	}
+
+	nodeIds := func(connection *model.PostConnection) []string {
+		var nodes []string
+		for _, edge := range connection.Edges {
+			nodes = append(nodes, edge.Node.ID)
+		}
+
+		return nodes
+	}
+
+	two := int32(2)
+	first, err := resolver.Query().ListPosts(ctx, &two, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, ids[:2], nodeIds(first))
+	assert.Equal(t, int32(5), first.TotalCount)
+	assert.True(t, first.PageInfo.HasNextPage)
+
+	// New rows must not shift the pages that follow an existing cursor.
+	_, err = resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title6", Body: "body"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	second, err := resolver.Query().ListPosts(ctx, &two, first.PageInfo.EndCursor, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, ids[2:4], nodeIds(second))
+	assert.Equal(t, int32(6), second.TotalCount)
+
+	previous, err := resolver.Query().ListPosts(ctx, nil, nil, &two, second.PageInfo.StartCursor)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, ids[:2], nodeIds(previous))
+	assert.False(t, previous.PageInfo.HasPreviousPage)
+
+	byDefault, err := resolver.Query().ListPosts(ctx, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Len(t, byDefault.Edges, 1)
+
+	invalid := "invalid"
+	_, err = resolver.Query().ListPosts(ctx, &two, &invalid, nil, nil)
+	assert.ErrorIs(t, err, errs.ErrValidation)
+
+	tooMany := int32(11)
+	_, err = resolver.Query().ListPosts(ctx, &tooMany, nil, nil, nil)
+	assert.ErrorIs(t, err, errs.ErrValidation)
+}
+
+func TestObjectGraphResolvers(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "foo", Email: "bar@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
+	post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	comment, err := resolver.Mutation().CreateComment(ctx, model.CommentInput{Body: "comment", ParentPostID: post.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	reply, err := resolver.Mutation().CreateComment(ctx, model.CommentInput{Body: "reply", ParentPostID: post.ID, ParentCommentID: &comment.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	author, err := resolver.Post().Author(ctx, post)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, user.ID, author.ID)
+
+	comments, err := resolver.Post().Comments(ctx, post, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, int32(1), comments.TotalCount)
+	assert.Equal(t, comment.ID, comments.Edges[0].Node.ID)
+
+	replies, err := resolver.Comment().Replies(ctx, comment, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, reply.ID, replies.Edges[0].Node.ID)
+
+	parent, err := resolver.Comment().Parent(ctx, reply)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, comment.ID, parent.ID)
+
+	parent, err = resolver.Comment().Parent(ctx, comment)
+	assert.NoError(t, err)
+	assert.Nil(t, parent)
+
+	commented, err := resolver.Comment().Post(ctx, reply)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, post.ID, commented.ID)
+
+	posts, err := resolver.User().Posts(ctx, user, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, int32(1), posts.TotalCount)
+
+	userComments, err := resolver.User().Comments(ctx, user, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, int32(2), userComments.TotalCount)
+
+	_, err = resolver.Mutation().DeleteUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	author, err = resolver.Comment().Author(ctx, comment)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.True(t, author.Deleted)
+	assert.Equal(t, user.ID, author.ID)
+	assert.Equal(t, "-1", model.DeadUser.ID, "the shared placeholder must not be modified")
+}
+
+type countingUserStorage struct {
+	storage.UserStorage
+	byId  atomic.Int32
+	byIds atomic.Int32
+}
+
+func (s *countingUserStorage) GetUserById(userId string, ctx context.Context) (*model2.User, error) {
+	s.byId.Add(1)
+	return s.UserStorage.GetUserById(userId, ctx)
+}
+
+func (s *countingUserStorage) GetUsersByIds(userIds []string, ctx context.Context) ([]*model2.User, error) {
+	s.byIds.Add(1)
+	return s.UserStorage.GetUsersByIds(userIds, ctx)
+}
+
+func TestAuthorsAreLoadedInBatches(t *testing.T) {
+	params := newTestParams()
+	params.DataLoaderWait = 20 * time.Millisecond
+	plainUsers := storage.NewInMemoryUserStorage(params)
+	users := &countingUserStorage{UserStorage: plainUsers}
+	posts := storage.NewInMemoryPostStorage(params)
+	comments := storage.NewInMemoryCommentStorage(params)
+	n := storage.NewInMemoryNotificationStorage(params)
+	resolver := newTestResolverWithBus(
+		params,
+		users,
+		posts,
+		comments,
+		storage.NewInMemorySessionStorage(params),
+		n,
+		storage.NewInMemoryWebhookStorage(params),
+		storage.NewInMemoryLifecycleStorage(plainUsers, posts, comments, n),
+		service.NewInMemoryEventBus(params),
+	)
+
+	var authors []*model.User
+	for _, name := range []string{"foo", "bar"} {
+		user, err := resolver.Mutation().CreateUser(context.Background(), model.UserInput{Username: name, Email: name + "@mail.ru", Password: "baz"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		authors = append(authors, user)
+	}
+
+	var created []*model.Post
+	for i := 0; i < 10; i++ {
+		ctx := auth.WithUser(context.Background(), utils.FromApiUser(authors[i%2]))
+		post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title", Body: "body"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		created = append(created, post)
+	}
+
+	ctx := auth.WithUser(context.Background(), utils.FromApiUser(authors[1]))
+	if _, err := resolver.Mutation().DeleteUser(ctx, authors[1].ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	users.byId.Store(0)
+	ctx = dataloader.WithLoaders(context.Background(), dataloader.NewLoaders(users, posts, comments, dataloader.NewLoaderOpt(params)))
+
+	var wg sync.WaitGroup
+	resolved := make([]*model.User, len(created))
+	for i, post := range created {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			author, err := resolver.Post().Author(ctx, post)
+			assert.NoError(t, err)
+			resolved[i] = author
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, int32(0), users.byId.Load())
+	assert.Equal(t, int32(1), users.byIds.Load())
+	for i, author := range resolved {
+		assert.Equal(t, authors[i%2].ID, author.ID)
+		assert.Equal(t, i%2 == 1, author.Deleted)
+	}
+}
+
+func TestCommentThread(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "foo", Email: "bar@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
+	post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	comment := func(body string, parent *model.Comment) *model.Comment {
+		input := model.CommentInput{Body: body, ParentPostID: post.ID}
+		if parent != nil {
+			input.ParentCommentID = &parent.ID
+		}
+
+		created, err := resolver.Mutation().CreateComment(ctx, input)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		return created
+	}
+
+	root1 := comment("root1", nil)
+	reply1 := comment("reply1", root1)
+	comment("reply2", root1)
+	comment("nested", reply1)
+	root2 := comment("root2", nil)
+	root3 := comment("root3", nil)
+
+	depth, children := int32(1), int32(2)
+	thread, err := resolver.Query().CommentThread(ctx, post.ID, &depth, &children, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var bodies []string
+	for _, node := range thread.Nodes {
+		bodies = append(bodies, node.Comment.Body)
+	}
+
+	assert.Equal(t, []string{"root1", "reply1", "reply2", "root2"}, bodies)
+	assert.Equal(t, []string{root1.ID, reply1.ID}, thread.Nodes[1].Path)
+	assert.Equal(t, int32(1), thread.Nodes[1].Depth)
+	assert.True(t, thread.Nodes[1].HasMoreChildren, "replies below maxDepth are left to load")
+	assert.False(t, thread.Nodes[0].HasMoreChildren)
+	assert.True(t, thread.HasMoreRoots)
+
+	rest, err := resolver.Query().CommentThread(ctx, post.ID, &depth, &children, thread.EndCursor)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Len(t, rest.Nodes, 1)
+	assert.Equal(t, root3.ID, rest.Nodes[0].Comment.ID)
+	assert.False(t, rest.HasMoreRoots)
+
+	one := int32(1)
+	narrow, err := resolver.Query().CommentThread(ctx, post.ID, &depth, &one, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Len(t, narrow.Nodes, 2)
+	assert.True(t, narrow.Nodes[0].HasMoreChildren, "replies beyond maxChildrenPerNode are left to load")
+	assert.NotEqual(t, root2.ID, narrow.Nodes[1].Comment.ID)
+
+	tooDeep := int32(6)
+	_, err = resolver.Query().CommentThread(ctx, post.ID, &tooDeep, nil, nil)
+	assert.ErrorIs(t, err, errs.ErrValidation)
+
+	deep, wide := int32(5), int32(10)
+	_, err = resolver.Query().CommentThread(ctx, post.ID, &deep, &wide, nil)
+	assert.ErrorIs(t, err, errs.ErrValidation)
+}
+
+func TestReplyParentage(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "foo", Email: "bar@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
+	post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	otherPost, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title2", Body: "body2"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	reply := func(postId string, parentId *string) (*model.Comment, error) {
+		return resolver.Mutation().CreateComment(ctx, model.CommentInput{Body: "body", ParentPostID: postId, ParentCommentID: parentId})
+	}
+
+	root, err := reply(post.ID, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = reply(otherPost.ID, &root.ID)
+	assert.ErrorIs(t, err, errs.ErrValidation)
+
+	missing := "100500"
+	_, err = reply(post.ID, &missing)
+	assert.ErrorIs(t, err, errs.ErrNotFound)
+
+	deleted, err := reply(post.ID, &root.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err = resolver.Mutation().DeleteComment(ctx, deleted.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = reply(post.ID, &deleted.ID)
+	assert.ErrorIs(t, err, errs.ErrNotFound)
+
+	level1, err := reply(post.ID, &root.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	level2, err := reply(post.ID, &level1.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, level1.ID, *level2.ParentCommentID)
+
+	flattened, err := reply(post.ID, &level2.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, level1.ID, *flattened.ParentCommentID, "replies beyond the max depth go to the deepest allowed ancestor")
+
+	if _, err = resolver.Mutation().DeleteComment(ctx, level1.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	aboveTombstone, err := reply(post.ID, &level2.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, root.ID, *aboveTombstone.ParentCommentID, "a deleted ancestor is skipped for the nearest live one")
+
+	if _, err = resolver.Mutation().DeleteComment(ctx, root.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	topLevel, err := reply(post.ID, &level2.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Nil(t, topLevel.ParentCommentID, "without live ancestors the reply starts a new thread")
+}
+
+func TestDeletedCommentsBecomeTombstones(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "foo", Email: "bar@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
+	post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	reply := func(parentId *string) *model.Comment {
+		comment, err := resolver.Mutation().CreateComment(ctx, model.CommentInput{Body: "body", ParentPostID: post.ID, ParentCommentID: parentId})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		return comment
+	}
+
+	mentioned, err := resolver.Mutation().CreateUser(context.Background(), model.UserInput{Username: "mentioned", Email: "mentioned@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mentionedCtx := auth.WithUser(context.Background(), utils.FromApiUser(mentioned))
+	root := reply(nil)
+	child := reply(&root.ID)
+	leaf, err := resolver.Mutation().CreateComment(ctx, model.CommentInput{Body: "@mentioned", ParentPostID: post.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	inboxSize := func() int32 {
+		inbox, err := resolver.Query().Notifications(mentionedCtx, nil, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		return inbox.TotalCount
+	}
+
+	assert.Equal(t, int32(1), inboxSize())
+
+	for _, id := range []string{root.ID, leaf.ID} {
+		if _, err = resolver.Mutation().DeleteComment(ctx, id); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	first := int32(10)
+	comments, err := resolver.Query().PostComments(ctx, post.ID, &first, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, int32(1), comments.TotalCount, "fully deleted branches are pruned")
+	tombstone := comments.Edges[0].Node
+	assert.Equal(t, root.ID, tombstone.ID)
+	assert.True(t, tombstone.Deleted)
+	assert.Equal(t, "DELETED", tombstone.Body)
+	assert.Nil(t, tombstone.AuthorID)
+
+	replies, err := resolver.Query().ChildComments(ctx, root.ID, &first, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, int32(1), replies.TotalCount)
+	assert.Equal(t, child.ID, replies.Edges[0].Node.ID)
+
+	thread, err := resolver.Query().CommentThread(ctx, post.ID, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Len(t, thread.Nodes, 2)
+	assert.True(t, thread.Nodes[0].Comment.Deleted)
+	assert.Equal(t, child.ID, thread.Nodes[1].Comment.ID)
+
+	_, err = resolver.Mutation().UpdateCommentBody(ctx, root.ID, "body")
+	assert.ErrorIs(t, err, errs.ErrDeleted)
+
+	_, err = resolver.Mutation().DeleteComment(ctx, root.ID)
+	assert.ErrorIs(t, err, errs.ErrDeleted)
+
+	if _, err = resolver.Mutation().DeleteComment(ctx, child.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	comments, err = resolver.Query().PostComments(ctx, post.ID, &first, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, int32(0), comments.TotalCount, "the tombstone goes away with its last reply")
+}
+
+func TestDeletionCascades(t *testing.T) {
+	for _, policy := range []config.UserContentPolicy{config.AnonymizeUserContent, config.RemoveUserContent} {
+		t.Run(string(policy), func(t *testing.T) {
+			params := newTestParams()
+			params.UserContentPolicy = policy
+			resolver := newTestResolver(params)
+
+			ctx := context.Background()
+			newUser := func(name string) (*model.User, context.Context) {
+				user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: name, Email: name + "@mail.ru", Password: "baz"})
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+
+				return user, auth.WithUser(ctx, utils.FromApiUser(user))
+			}
+
+			author, authorCtx := newUser("author")
+			reader, readerCtx := newUser("reader")
+
+			newPost := func(ctx context.Context) *model.Post {
+				post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title", Body: "body"})
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+
+				return post
+			}
+
+			newComment := func(ctx context.Context, postId string) *model.Comment {
+				comment, err := resolver.Mutation().CreateComment(ctx, model.CommentInput{Body: "body", ParentPostID: postId})
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+
+				return comment
+			}
+
+			deletedPost := newPost(authorCtx)
+			cascadedComment := newComment(readerCtx, deletedPost.ID)
+			authorPost := newPost(authorCtx)
+			newComment(readerCtx, authorPost.ID)
+			readerPost := newPost(readerCtx)
+			authorComment := newComment(authorCtx, readerPost.ID)
+
+			subCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			cascadedComments, _ := resolver.Subscription().CommentDeleted(subCtx, deletedPost.ID, nil)
+			authorPostEvents, _ := resolver.Subscription().PostUpdated(subCtx, authorPost.ID, nil)
+			readerPostComments, _ := resolver.Subscription().CommentDeleted(subCtx, readerPost.ID, nil)
+
+			first := int32(10)
+			readerComments := func() int32 {
+				comments, err := resolver.User().Comments(ctx, reader, &first, nil)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+
+				return comments.TotalCount
+			}
+
+			if _, err := resolver.Mutation().DeletePost(authorCtx, deletedPost.ID); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			assert.Equal(t, int32(1), readerComments(), "comments of a deleted post are deleted with it")
+			tombstone := receive(t, cascadedComments)
+			assert.Equal(t, cascadedComment.ID, tombstone.ID)
+			assert.True(t, tombstone.Deleted)
+
+			_, err := resolver.Mutation().DeletePost(authorCtx, deletedPost.ID)
+			assert.ErrorIs(t, err, errs.ErrDeleted)
+
+			if _, err = resolver.Mutation().DeleteUser(authorCtx, author.ID); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			post, postErr := resolver.Query().Post(ctx, authorPost.ID)
+			comments, err := resolver.Query().PostComments(ctx, readerPost.ID, &first, nil, nil, nil)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			switch policy {
+			case config.AnonymizeUserContent:
+				assert.NoError(t, postErr)
+				assert.Nil(t, post.AuthorID)
+				assert.Equal(t, int32(1), comments.TotalCount)
+				assert.Equal(t, authorComment.ID, comments.Edges[0].Node.ID)
+				assert.Nil(t, comments.Edges[0].Node.AuthorID)
+				assert.Equal(t, int32(1), readerComments())
+			case config.RemoveUserContent:
+				assert.ErrorIs(t, postErr, errs.ErrDeleted)
+				assert.Equal(t, int32(0), comments.TotalCount)
+				assert.Equal(t, int32(0), readerComments(), "comments of removed posts are deleted as well")
+
+				postEvent := receive(t, authorPostEvents)
+				assert.Equal(t, model.PostEventKindDeleted, postEvent.Kind)
+				assert.Equal(t, authorPost.ID, postEvent.Post.ID)
+				assert.Equal(t, authorComment.ID, receive(t, readerPostComments).ID)
+			}
+
+			_, err = resolver.Mutation().DeleteUser(authorCtx, author.ID)
+			assert.ErrorIs(t, err, errs.ErrDeleted)
+		})
+	}
+}
+
+func TestRestoreAndPurge(t *testing.T) {
+	params := newTestParams()
+	params.UserContentPolicy = config.RemoveUserContent
+	u := storage.NewInMemoryUserStorage(params)
+	p := storage.NewInMemoryPostStorage(params)
+	c := storage.NewInMemoryCommentStorage(params)
+	n := storage.NewInMemoryNotificationStorage(params)
+	resolver := newTestResolverWithStorage(params, u, p, c, n, storage.NewInMemorySessionStorage(params))
+
+	// Everything deleted so far is already past a negative retention period.
+	expiredParams := params
+	expiredParams.RetentionPeriod = -time.Hour
+	expired := newTestResolverWithStorage(expiredParams, u, p, c, n, storage.NewInMemorySessionStorage(params))
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "foo", Email: "bar@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
+	post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	reply := func(parentId *string) *model.Comment {
+		comment, err := resolver.Mutation().CreateComment(ctx, model.CommentInput{Body: "body", ParentPostID: post.ID, ParentCommentID: parentId})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		return comment
+	}
+
+	mentioned, err := resolver.Mutation().CreateUser(context.Background(), model.UserInput{Username: "mentioned", Email: "mentioned@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mentionedCtx := auth.WithUser(context.Background(), utils.FromApiUser(mentioned))
+	root := reply(nil)
+	child := reply(&root.ID)
+	leaf, err := resolver.Mutation().CreateComment(ctx, model.CommentInput{Body: "@mentioned", ParentPostID: post.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	inboxSize := func() int32 {
+		inbox, err := resolver.Query().Notifications(mentionedCtx, nil, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		return inbox.TotalCount
+	}
+
+	assert.Equal(t, int32(1), inboxSize())
+
+	first := int32(10)
+	commentCount := func() int32 {
+		comments, err := resolver.Query().PostComments(ctx, post.ID, &first, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		return comments.TotalCount
+	}
+
+	_, err = resolver.Mutation().RestorePost(ctx, post.ID)
+	assert.ErrorIs(t, err, errs.ErrConflict)
+
+	if _, err = resolver.Mutation().DeleteComment(ctx, leaf.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err = resolver.Mutation().DeletePost(ctx, post.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = expired.Mutation().RestorePost(ctx, post.ID)
+	assert.ErrorIs(t, err, errs.ErrDeleted, "the retention period is over")
+
+	restored, err := resolver.Mutation().RestorePost(ctx, post.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.False(t, restored.Deleted)
+	assert.Equal(t, int32(1), commentCount(), "comments deleted on their own stay deleted")
+
+	if _, err = resolver.Mutation().RestoreComment(ctx, leaf.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, int32(2), commentCount())
+
+	admin := utils.FromApiUser(user)
+	admin.Role = model2.RoleAdmin
+	adminCtx := auth.WithUser(context.Background(), admin)
+	if _, err = resolver.Mutation().DeleteUser(ctx, user.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	restoredUser, err := resolver.Mutation().RestoreUser(adminCtx, user.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.False(t, restoredUser.Deleted)
+	assert.Equal(t, int32(2), commentCount(), "content removed with the user comes back with them")
+
+	for _, id := range []string{root.ID, leaf.ID} {
+		if _, err = resolver.Mutation().DeleteComment(ctx, id); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	job := service.NewPurgeJob(fxtest.NewLifecycle(t), expiredParams, storage.NewInMemoryLifecycleStorage(u, p, c, n))
+	result, err := job.Purge(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, storage.PurgeResult{Comments: 1}, result, "the tombstone with a live reply stays")
+	assert.Equal(t, int32(1), commentCount())
+	assert.Equal(t, int32(0), inboxSize(), "notifications go away with the purged comment")
+
+	replies, err := resolver.Query().ChildComments(ctx, root.ID, &first, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, child.ID, replies.Edges[0].Node.ID)
+
+	_, err = resolver.Mutation().RestoreComment(ctx, leaf.ID)
+	assert.ErrorIs(t, err, errs.ErrNotFound)
+
+	if _, err = resolver.Mutation().DeleteUser(ctx, user.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result, err = job.Purge(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, storage.PurgeResult{Users: 1, Posts: 1, Comments: 2}, result)
+
+	_, err = resolver.Query().UserByID(ctx, user.ID)
+	assert.ErrorIs(t, err, errs.ErrNotFound)
+}
+
+func TestSubscriberOverflow(t *testing.T) {
+	params := newTestParams()
+	comments := make([]*model.Comment, 4)
+	for i := range comments {
+		comments[i] = &model.Comment{ID: strconv.Itoa(i), ParentPostID: "1"}
+	}
+
+	dropped := func() int64 {
+		metric, ok := expvar.Get("subscriptions").(*expvar.Map).Get("dropped").(*expvar.Int)
+		if !ok {
+			return 0
+		}
+
+		return metric.Value()
+	}
+
+	ss := service.NewSubscriptionService(params, service.NewInMemoryEventBus(params))
+	sub := ss.Subscribe(service.PostTopic("1"), nil)
+	before := dropped()
+	for _, comment := range comments {
+		ss.PublishComment(context.Background(), model.CommentEventKindCreated, comment)
+	}
+
+	assert.Equal(t, int64(2), dropped()-before)
+	assert.Equal(t, comments[2], (<-sub.Events()).Comment.Comment, "the oldest comments are dropped")
+	assert.Equal(t, comments[3], (<-sub.Events()).Comment.Comment)
+
+	ss.Unsubscribe(sub)
+	ss.Unsubscribe(sub)
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+	assert.NoError(t, sub.Err())
+
+	params.SubscriberOverflow = config.Disconnect
+	ss = service.NewSubscriptionService(params, service.NewInMemoryEventBus(params))
+	sub = ss.Subscribe(service.PostTopic("1"), nil)
+	for _, comment := range comments {
+		ss.PublishComment(context.Background(), model.CommentEventKindCreated, comment)
+	}
+
+	var received []*model.Comment
+	for event := range sub.Events() {
+		received = append(received, event.Comment.Comment)
+	}
+
+	assert.Equal(t, comments[:2], received, "buffered comments are still delivered")
+	assert.ErrorIs(t, sub.Err(), errs.ErrSlowConsumer)
+	ss.Unsubscribe(sub)
+
+	// Events the subscriber does not accept never take up its buffer.
+	created := func(event *service.Event) bool {
+		return event.Comment != nil && event.Comment.Kind == model.CommentEventKindCreated
+	}
+	sub = ss.Subscribe(service.PostTopic("1"), created)
+	before = dropped()
+	for _, comment := range comments {
+		ss.PublishComment(context.Background(), model.CommentEventKindUpdated, comment)
+	}
+	ss.PublishPost(context.Background(), model.PostEventKindUpdated, &model.Post{ID: "1"})
+	ss.PublishComment(context.Background(), model.CommentEventKindCreated, comments[0])
+
+	assert.Equal(t, int64(0), dropped()-before)
+	assert.NoError(t, sub.Err())
+	assert.Equal(t, comments[0], (<-sub.Events()).Comment.Comment)
+	ss.Unsubscribe(sub)
+}
+
+func TestSlowSubscriptionEndsWithError(t *testing.T) {
+	params := newTestParams()
+	params.SubscriberBufferSize = 1
+	params.SubscriberOverflow = config.Disconnect
+	resolver := newTestResolver(params)
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "foo", Email: "bar@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
+	post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	subCtx, cancel := context.WithCancel(WithStreamError(ctx))
+	defer cancel()
+
+	ch, err := resolver.Subscription().CommentCreated(subCtx, post.ID, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err = resolver.Mutation().CreateComment(ctx, model.CommentInput{Body: "body", ParentPostID: post.ID}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	for range ch {
+	}
+
+	assert.ErrorIs(t, TakeStreamError(subCtx), errs.ErrSlowConsumer)
+	assert.NoError(t, TakeStreamError(subCtx), "the error is reported once")
+}
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+
+	var zero T
+	return zero
+}
+
+func TestMutationsPublishEvents(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "foo", Email: "bar@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
+	post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, _ := resolver.Subscription().CommentEvents(subCtx, post.ID, nil)
+	updated, _ := resolver.Subscription().CommentUpdated(subCtx, post.ID, nil)
+	deleted, _ := resolver.Subscription().CommentDeleted(subCtx, post.ID, nil)
+	postEvents, _ := resolver.Subscription().PostUpdated(subCtx, post.ID, nil)
+
+	comment, err := resolver.Mutation().CreateComment(ctx, model.CommentInput{Body: "body", ParentPostID: post.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	event := receive(t, events)
+	assert.Equal(t, model.CommentEventKindCreated, event.Kind)
+	assert.Equal(t, comment.ID, event.Comment.ID)
+
+	if _, err = resolver.Mutation().UpdateCommentBody(ctx, comment.ID, "edited"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, model.CommentEventKindUpdated, receive(t, events).Kind)
+	assert.Equal(t, "edited", receive(t, updated).Body)
+
+	if _, err = resolver.Mutation().DeleteComment(ctx, comment.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, model.CommentEventKindDeleted, receive(t, events).Kind)
+	tombstone := receive(t, deleted)
+	assert.Equal(t, comment.ID, tombstone.ID)
+	assert.True(t, tombstone.Deleted)
+
+	if _, err = resolver.Mutation().RestoreComment(ctx, comment.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, model.CommentEventKindRestored, receive(t, events).Kind)
+
+	allow := false
+	if _, err = resolver.Mutation().UpdatePostCommentsAllowance(ctx, post.ID, &allow); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	postEvent := receive(t, postEvents)
+	assert.Equal(t, model.PostEventKindUpdated, postEvent.Kind)
+	assert.False(t, postEvent.Post.AllowComments)
+
+	if _, err = resolver.Mutation().DeletePost(ctx, post.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	postEvent = receive(t, postEvents)
+	assert.Equal(t, model.PostEventKindDeleted, postEvent.Kind)
+	assert.Equal(t, post.ID, postEvent.Post.ID)
+}
+
+// cancelAwareBus fails like a database bus does once the context is canceled.
+type cancelAwareBus struct {
+	service.EventBus
+}
+
+func (b cancelAwareBus) Publish(message *service.BusMessage, ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return b.EventBus.Publish(message, ctx)
+}
+
+func TestEventsOutliveCanceledRequests(t *testing.T) {
+	params := newTestParams()
+	u := storage.NewInMemoryUserStorage(params)
+	p := storage.NewInMemoryPostStorage(params)
+	c := storage.NewInMemoryCommentStorage(params)
+	n := storage.NewInMemoryNotificationStorage(params)
+	resolver := newTestResolverWithBus(
+		params,
+		u,
+		p,
+		c,
+		storage.NewInMemorySessionStorage(params),
+		n,
+		storage.NewInMemoryWebhookStorage(params),
+		storage.NewInMemoryLifecycleStorage(u, p, c, n),
+		cancelAwareBus{service.NewInMemoryEventBus(params)},
+	)
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "foo", Email: "bar@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
+	post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	subCtx, cancelSub := context.WithCancel(ctx)
+	defer cancelSub()
+
+	postEvents, _ := resolver.Subscription().PostUpdated(subCtx, post.ID, nil)
+
+	// The client going away after the change is made must not swallow its event.
+	requestCtx, cancelRequest := context.WithCancel(ctx)
+	cancelRequest()
+	if _, err = resolver.Mutation().DeletePost(requestCtx, post.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, model.PostEventKindDeleted, receive(t, postEvents).Kind)
+}
+
+func TestReplyAndActivitySubscriptions(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	ctx := context.Background()
+	_, err := resolver.Subscription().MyActivity(ctx, nil)
+	assert.ErrorIs(t, err, errs.ErrUnauthenticated)
+
+	alice, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "alice", Email: "alice@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	bob, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "bob", Email: "bob@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	aliceCtx := auth.WithUser(ctx, utils.FromApiUser(alice))
+	bobCtx := auth.WithUser(ctx, utils.FromApiUser(bob))
+	post, err := resolver.Mutation().CreatePost(aliceCtx, model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	comment, err := resolver.Mutation().CreateComment(aliceCtx, model.CommentInput{Body: "body", ParentPostID: post.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	subCtx, cancel := context.WithCancel(aliceCtx)
+	defer cancel()
+
+	replies, err := resolver.Subscription().CommentReplied(subCtx, comment.ID, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	activity, err := resolver.Subscription().MyActivity(subCtx, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	reply, err := resolver.Mutation().CreateComment(bobCtx, model.CommentInput{Body: "reply", ParentPostID: post.ID, ParentCommentID: &comment.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, reply.ID, receive(t, replies).ID)
+	event := receive(t, activity)
+	assert.Equal(t, model.CommentEventKindCreated, event.Kind)
+	assert.Equal(t, reply.ID, event.Comment.ID)
+
+	ownReply, err := resolver.Mutation().CreateComment(aliceCtx, model.CommentInput{Body: "own reply", ParentPostID: post.ID, ParentCommentID: &comment.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, ownReply.ID, receive(t, replies).ID)
+
+	topLevel, err := resolver.Mutation().CreateComment(bobCtx, model.CommentInput{Body: "top level", ParentPostID: post.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// A reply to alice's comment on her own post arrives once, and her own
+	// reply does not arrive at all.
+	assert.Equal(t, topLevel.ID, receive(t, activity).Comment.ID)
+}
+
+func TestSubscriptionReplay(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "foo", Email: "bar@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx = auth.WithUser(ctx, utils.FromApiUser(user))
+	post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	createComment := func(body string) *model.Comment {
+		comment, err := resolver.Mutation().CreateComment(ctx, model.CommentInput{Body: body, ParentPostID: post.ID})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		return comment
+	}
+
+	subCtx, cancel := context.WithCancel(WithStreamEventIDs(ctx))
+	events, err := resolver.Subscription().CommentEvents(subCtx, post.ID, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	first := createComment("first")
+	event := receive(t, events)
+	assert.Equal(t, first.ID, event.Comment.ID)
+	eventId, ok := TakeStreamEventID(subCtx)
+	assert.True(t, ok)
+	assert.Equal(t, event.ID, eventId)
+	cancel()
+
+	// Missed while disconnected.
+	second := createComment("second")
+	third := createComment("third")
+	if _, err = resolver.Mutation().UpdateCommentBody(ctx, first.ID, "edited"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	subCtx, cancel = context.WithCancel(WithStreamEventIDs(ctx))
+	defer cancel()
+
+	created, err := resolver.Subscription().CommentCreated(subCtx, post.ID, &eventId)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, second.ID, receive(t, created).ID)
+	assert.Equal(t, third.ID, receive(t, created).ID)
+
+	fourth := createComment("fourth")
+	assert.Equal(t, fourth.ID, receive(t, created).ID)
+
+	var ids []uint64
+	for range 3 {
+		id, ok := TakeStreamEventID(subCtx)
+		assert.True(t, ok)
+
+		parsed, err := strconv.ParseUint(id, 10, 64)
+		assert.NoError(t, err)
+		ids = append(ids, parsed)
+	}
+	assert.IsIncreasing(t, ids)
+
+	// The log keeps the last three events of the post.
+	since := "0"
+	replayed, err := resolver.Subscription().CommentEvents(subCtx, post.ID, &since)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	event = receive(t, replayed)
+	assert.Equal(t, model.CommentEventKindCreated, event.Kind)
+	assert.Equal(t, third.ID, event.Comment.ID)
+	assert.Equal(t, model.CommentEventKindUpdated, receive(t, replayed).Kind)
+	assert.Equal(t, fourth.ID, receive(t, replayed).Comment.ID)
+
+	since = "latest"
+	_, err = resolver.Subscription().CommentEvents(subCtx, post.ID, &since)
+	assert.ErrorIs(t, err, errs.ErrValidation)
+}
+
+func TestNotifications(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	ctx := context.Background()
+	_, err := resolver.Query().Notifications(ctx, nil, nil, nil, nil, nil)
+	assert.ErrorIs(t, err, errs.ErrUnauthenticated)
+
+	users := make(map[string]context.Context)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: name, Email: name + "@mail.ru", Password: "baz"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		users[name] = auth.WithUser(ctx, utils.FromApiUser(user))
+	}
+
+	post, err := resolver.Mutation().CreatePost(users["alice"], model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	comment, err := resolver.Mutation().CreateComment(users["alice"], model.CommentInput{Body: "body", ParentPostID: post.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	subCtx, cancel := context.WithCancel(users["alice"])
+	defer cancel()
+
+	received, err := resolver.Subscription().NotificationReceived(subCtx, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Alice is replied to on her own post, she hears about it once.
+	reply, err := resolver.Mutation().CreateComment(users["bob"], model.CommentInput{Body: "@carol look", ParentPostID: post.ID, ParentCommentID: &comment.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	notification := receive(t, received)
+	assert.Equal(t, model.NotificationKindReply, notification.Kind)
+	assert.Equal(t, reply.ID, notification.CommentID)
+
+	carolInbox, err := resolver.Query().Notifications(users["carol"], nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, int32(1), carolInbox.TotalCount)
+	assert.Equal(t, model.NotificationKindMention, carolInbox.Edges[0].Node.Kind)
+
+	off := false
+	preferences, err := resolver.Mutation().UpdateNotificationPreferences(users["carol"], model.NotificationPreferencesInput{Mentions: &off})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, &model.NotificationPreferences{Replies: true, Comments: true, Mentions: false}, preferences)
+
+	topLevel, err := resolver.Mutation().CreateComment(users["bob"], model.CommentInput{Body: "@carol again", ParentPostID: post.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	notification = receive(t, received)
+	assert.Equal(t, model.NotificationKindComment, notification.Kind)
+	assert.Equal(t, topLevel.ID, notification.CommentID)
+
+	// Nobody is notified about their own comment.
+	if _, err = resolver.Mutation().CreateComment(users["alice"], model.CommentInput{Body: "@alice", ParentPostID: post.ID}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	carolInbox, err = resolver.Query().Notifications(users["carol"], nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, int32(1), carolInbox.TotalCount)
+
+	unreadOnly := true
+	first := int32(10)
+	inbox, err := resolver.Query().Notifications(users["alice"], &first, nil, nil, nil, &unreadOnly)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, int32(2), inbox.TotalCount)
+	commented, err := resolver.Notification().Comment(users["alice"], inbox.Edges[0].Node)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, reply.ID, commented.ID)
+
+	// Ids of someone else's notifications and malformed ids are ignored.
+	read, err := resolver.Mutation().MarkNotificationsRead(users["alice"], []string{inbox.Edges[0].Node.ID, carolInbox.Edges[0].Node.ID, "not-an-id"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Len(t, read, 1)
+	assert.True(t, read[0].Read)
+
+	inbox, err = resolver.Query().Notifications(users["alice"], &first, nil, nil, nil, &unreadOnly)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, int32(1), inbox.TotalCount)
+	assert.Equal(t, topLevel.ID, inbox.Edges[0].Node.CommentID)
+
+	carolInbox, err = resolver.Query().Notifications(users["carol"], nil, nil, nil, nil, &unreadOnly)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, int32(1), carolInbox.TotalCount)
+
+	// A reply past MaxCommentDepth is attached to bob's comment, still it is
+	// carol who is replied to.
+	deepest, err := resolver.Mutation().CreateComment(users["carol"], model.CommentInput{Body: "deep", ParentPostID: post.ID, ParentCommentID: &reply.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	flattened, err := resolver.Mutation().CreateComment(users["bob"], model.CommentInput{Body: "deeper", ParentPostID: post.ID, ParentCommentID: &deepest.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, &reply.ID, flattened.ParentCommentID)
+
+	carolInbox, err = resolver.Query().Notifications(users["carol"], &first, nil, nil, nil, &unreadOnly)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, int32(2), carolInbox.TotalCount)
+	assert.Equal(t, model.NotificationKindReply, carolInbox.Edges[1].Node.Kind)
+	assert.Equal(t, flattened.ID, carolInbox.Edges[1].Node.CommentID)
+}
+
+type receivedWebhook struct {
+	event     string
+	signature string
+	body      []byte
+}
+
+func TestMentionAtEndOfSentence(t *testing.T) {
+	resolver := newTestResolver(newTestParams())
+
+	ctx := context.Background()
+	users := make(map[string]context.Context)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: name, Email: name + "@mail.ru", Password: "baz"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		users[name] = auth.WithUser(ctx, utils.FromApiUser(user))
+	}
+
+	post, err := resolver.Mutation().CreatePost(users["alice"], model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	comment, err := resolver.Mutation().CreateComment(users["bob"], model.CommentInput{Body: "thanks @carol.", ParentPostID: post.ID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	carolInbox, err := resolver.Query().Notifications(users["carol"], nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, int32(1), carolInbox.TotalCount)
+	assert.Equal(t, model.NotificationKindMention, carolInbox.Edges[0].Node.Kind)
+	assert.Equal(t, comment.ID, carolInbox.Edges[0].Node.CommentID)
+}
+
+func TestWebhooks(t *testing.T) {
+	params := newTestParams()
+	// Failed deliveries are due again right away.
+	params.WebhookBackoff = 0
+	params.WebhookMaxBackoff = 0
+
+	var mu sync.Mutex
+	var received []receivedWebhook
+	var failing atomic.Bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		mu.Lock()
+		received = append(received, receivedWebhook{
+			event:     r.Header.Get(service.WebhookEventHeader),
+			signature: r.Header.Get(service.WebhookSignatureHeader),
+			body:      body,
+		})
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	u := storage.NewInMemoryUserStorage(params)
+	p := storage.NewInMemoryPostStorage(params)
+	c := storage.NewInMemoryCommentStorage(params)
+	w := storage.NewInMemoryWebhookStorage(params)
+	n := storage.NewInMemoryNotificationStorage(params)
+	resolver := newTestResolverWithBus(
+		params,
+		u,
+		p,
+		c,
+		storage.NewInMemorySessionStorage(params),
+		n,
+		w,
+		storage.NewInMemoryLifecycleStorage(u, p, c, n),
+		service.NewInMemoryEventBus(params),
+	)
+	worker := service.NewWebhookWorker(fxtest.NewLifecycle(t), params, w)
+
+	ctx := context.Background()
+	user, err := resolver.Mutation().CreateUser(ctx, model.UserInput{Username: "foo", Email: "bar@mail.ru", Password: "baz"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	admin := utils.FromApiUser(user)
+	admin.Role = model2.RoleAdmin
+	ctx = auth.WithUser(ctx, admin)
+
+	secret := "0123456789abcdef"
+	events := []model.WebhookEventType{model.WebhookEventTypePostCreated, model.WebhookEventTypeCommentCreated}
+	_, err = resolver.Mutation().CreateWebhook(ctx, model.WebhookInput{URL: "not a url", Events: events, Secret: secret})
+	assert.ErrorIs(t, err, errs.ErrValidation)
+
+	webhook, err := resolver.Mutation().CreateWebhook(ctx, model.WebhookInput{URL: receiver.URL, Events: events, Secret: secret})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	webhooks, err := resolver.Query().Webhooks(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, []*model.Webhook{webhook}, webhooks)
+
+	post, err := resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title1", Body: "body1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err = resolver.Mutation().CreateComment(ctx, model.CommentInput{Body: "body", ParentPostID: post.ID}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Not subscribed to, nothing is queued.
+	if _, err = resolver.Mutation().UpdatePostTitle(ctx, post.ID, "title2"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	deliver := func(expected int) {
+		attempted, err := worker.DeliverDue(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		assert.Equal(t, expected, attempted)
+	}
+
+	first := int32(10)
+	deliveries := func(status model.WebhookDeliveryStatus) []*model.WebhookDelivery {
+		connection, err := resolver.Query().WebhookDeliveries(ctx, &webhook.ID, &status, &first, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		nodes := make([]*model.WebhookDelivery, len(connection.Edges))
+		for i, edge := range connection.Edges {
+			nodes[i] = edge.Node
+		}
+
+		return nodes
+	}
+
+	failing.Store(true)
+	deliver(2)
+	pending := deliveries(model.WebhookDeliveryStatusPending)
+	if assert.Len(t, pending, 2) {
+		assert.Equal(t, int32(1), pending[0].Attempts)
+		assert.Equal(t, int32(http.StatusServiceUnavailable), *pending[0].LastStatusCode)
+	}
+
+	failing.Store(false)
+	deliver(2)
+	deliver(0)
+
+	delivered := deliveries(model.WebhookDeliveryStatusDelivered)
+	if assert.Len(t, delivered, 2) {
+		assert.Equal(t, int32(2), delivered[0].Attempts)
+		assert.Nil(t, delivered[0].LastError)
+		assert.NotNil(t, delivered[0].DeliveredAt)
+	}
+
+	mu.Lock()
+	assert.Len(t, received, 2)
+	kinds := make(map[string]string)
+	for _, r := range received {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(r.body)
+		assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), r.signature)
+
+		var payload service.WebhookPayload
+		if err = json.Unmarshal(r.body, &payload); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		assert.Equal(t, r.event, payload.Event)
+		kinds[payload.Event] = r.event
+	}
+	mu.Unlock()
+
+	assert.Contains(t, kinds, "post_created")
+	assert.Contains(t, kinds, "comment_created")
+
+	// Every attempt fails until the delivery is dead.
+	failing.Store(true)
+	if _, err = resolver.Mutation().CreatePost(ctx, model.PostInput{Title: "title3", Body: "body3"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for range params.WebhookMaxAttempts {
+		deliver(1)
+	}
+	deliver(0)
+
+	dead := deliveries(model.WebhookDeliveryStatusDead)
+	if assert.Len(t, dead, 1) {
+		assert.Equal(t, int32(params.WebhookMaxAttempts), dead[0].Attempts)
+		assert.Nil(t, dead[0].NextAttemptAt)
+		assert.NotNil(t, dead[0].LastError)
+	}
+
+	retried, err := resolver.Mutation().RetryWebhookDelivery(ctx, dead[0].ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, model.WebhookDeliveryStatusPending, retried.Status)
+	assert.Equal(t, int32(0), retried.Attempts)
+
+	// Only dead letters are retried, a pending or delivered one is left to
+	// the worker.
+	_, err = resolver.Mutation().RetryWebhookDelivery(ctx, retried.ID)
+	assert.ErrorIs(t, err, errs.ErrConflict)
+	_, err = resolver.Mutation().RetryWebhookDelivery(ctx, delivered[0].ID)
+	assert.ErrorIs(t, err, errs.ErrConflict)
+
+	failing.Store(false)
+	deliver(1)
+	assert.Len(t, deliveries(model.WebhookDeliveryStatusDelivered), 3)
+	assert.Empty(t, deliveries(model.WebhookDeliveryStatusDead))
+
+	if _, err = resolver.Mutation().DeleteWebhook(ctx, webhook.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	log, err := resolver.Query().WebhookDeliveries(ctx, nil, nil, &first, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Zero(t, log.TotalCount, "the log goes with the webhook")
+}
+
+// failingDeliveryStorage loses the outcome of the first delivery sent.
+type failingDeliveryStorage struct {
+	storage.WebhookStorage
+	failed atomic.Bool
+}
+
+func (s *failingDeliveryStorage) UpdateDelivery(delivery *model2.WebhookDelivery, ctx context.Context) error {
+	if s.failed.CompareAndSwap(false, true) {
+		return errors.New("storage is unavailable")
+	}
+
+	return s.WebhookStorage.UpdateDelivery(delivery, ctx)
+}
+
+func TestWebhookWorkerGoesOnAfterStorageErrors(t *testing.T) {
+	params := newTestParams()
+	receiver := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer receiver.Close()
+
+	w := &failingDeliveryStorage{WebhookStorage: storage.NewInMemoryWebhookStorage(params)}
+	ctx := context.Background()
+	err := w.InsertWebhook(&model2.Webhook{URL: receiver.URL, Events: []string{"post_created"}, Secret: "0123456789abcdef"}, ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	webhooks, err := w.GetWebhooks(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	deliveries := []*model2.WebhookDelivery{
+		{WebhookID: webhooks[0].ID, Event: "post_created", Payload: "{}"},
+		{WebhookID: webhooks[0].ID, Event: "post_created", Payload: "{}"},
+	}
+	if err = w.InsertDeliveries(deliveries, ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	worker := service.NewWebhookWorker(fxtest.NewLifecycle(t), params, w)
+	attempted, err := worker.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempted)
+
+	status := model2.DeliveryDelivered
+	delivered, err := w.CountDeliveries(storage.DeliveryFilter{Status: &status}, ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, uint64(1), delivered, "the delivery whose outcome was lost stays leased")
+}
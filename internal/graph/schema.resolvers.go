@@ -7,53 +7,105 @@ package graph
 import (
 	"context"
 
+	"github.com/k0ch3gar/ozon-task/internal/auth"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
 	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+	"github.com/k0ch3gar/ozon-task/internal/service"
+	"github.com/k0ch3gar/ozon-task/internal/utils"
 )
 
+// Author is the resolver for the author field.
+func (r *commentResolver) Author(ctx context.Context, obj *model.Comment) (*model.User, error) {
+	return r.us.GetAuthor(ctx, obj.AuthorID)
+}
+
+// Post is the resolver for the post field.
+func (r *commentResolver) Post(ctx context.Context, obj *model.Comment) (*model.Post, error) {
+	return r.ps.GetCommentedPost(obj.ParentPostID, ctx)
+}
+
+// Parent is the resolver for the parent field.
+func (r *commentResolver) Parent(ctx context.Context, obj *model.Comment) (*model.Comment, error) {
+	return r.cs.GetParentComment(obj, ctx)
+}
+
+// Replies is the resolver for the replies field.
+func (r *commentResolver) Replies(ctx context.Context, obj *model.Comment, first *int32, after *string) (*model.CommentConnection, error) {
+	return r.cs.GetReplies(obj, service.PageArgs{First: first, After: after}, ctx)
+}
+
 // CreateUser is the resolver for the createUser field.
 func (r *mutationResolver) CreateUser(ctx context.Context, user model.UserInput) (*model.User, error) {
 	usr, err := r.us.CreateUser(ctx, user)
 	return usr, err
 }
 
-// CreatePost is the resolver for the createPost field.
-func (r *mutationResolver) CreatePost(ctx context.Context, postInput model.PostInput) (*model.Post, error) {
-	post, err := r.ps.CreatePost(postInput, ctx)
-	return post, err
+// Login is the resolver for the login field.
+func (r *mutationResolver) Login(ctx context.Context, username string, password string) (*model.AuthPayload, error) {
+	return r.as.Login(ctx, username, password)
 }
 
-// DeletePost is the resolver for the deletePost field.
-func (r *mutationResolver) DeletePost(ctx context.Context, postID string) (*string, error) {
-	return r.ps.DeletePost(ctx, postID)
+// Logout is the resolver for the logout field.
+func (r *mutationResolver) Logout(ctx context.Context) (bool, error) {
+	return r.as.Logout(ctx)
 }
 
-// CreateComment is the resolver for the createComment field.
-func (r *mutationResolver) CreateComment(ctx context.Context, comment model.CommentInput) (*model.Comment, error) {
-	com, err := r.cs.CreateComment(comment, ctx)
-	if err == nil {
-		r.ss.PubComment(com.ParentPostID, com)
-	}
+// RefreshToken is the resolver for the refreshToken field.
+func (r *mutationResolver) RefreshToken(ctx context.Context, refreshToken string) (*model.AuthPayload, error) {
+	return r.as.RefreshToken(ctx, refreshToken)
+}
 
-	return com, err
+// UpdateUsername is the resolver for the updateUsername field.
+func (r *mutationResolver) UpdateUsername(ctx context.Context, username string) (*model.User, error) {
+	return r.us.UpdateUsername(ctx, username)
 }
 
-// DeleteComment is the resolver for the deleteComment field.
-func (r *mutationResolver) DeleteComment(ctx context.Context, commentID string) (*string, error) {
-	return r.cs.DeleteComment(commentID, ctx)
+// UpdateEmail is the resolver for the updateEmail field.
+func (r *mutationResolver) UpdateEmail(ctx context.Context, email string) (*model.User, error) {
+	return r.us.UpdateEmail(ctx, email)
+}
+
+// ChangePassword is the resolver for the changePassword field.
+func (r *mutationResolver) ChangePassword(ctx context.Context, oldPassword string, newPassword string) (bool, error) {
+	return r.us.ChangePassword(ctx, oldPassword, newPassword)
 }
 
+// DeleteUser is the resolver for the deleteUser field.
 func (r *mutationResolver) DeleteUser(ctx context.Context, userID string) (*model.User, error) {
 	return r.us.DeleteUser(ctx, userID)
 }
 
+// RestoreUser is the resolver for the restoreUser field.
+func (r *mutationResolver) RestoreUser(ctx context.Context, userID string) (*model.User, error) {
+	return r.us.RestoreUser(ctx, userID)
+}
+
+// GrantRole is the resolver for the grantRole field.
+func (r *mutationResolver) GrantRole(ctx context.Context, userID string, role model.Role) (*model.User, error) {
+	return r.us.GrantRole(ctx, userID, utils.ToStorageRole(role))
+}
+
+// RevokeRole is the resolver for the revokeRole field.
+func (r *mutationResolver) RevokeRole(ctx context.Context, userID string, role model.Role) (*model.User, error) {
+	return r.us.RevokeRole(ctx, userID, utils.ToStorageRole(role))
+}
+
+// CreatePost is the resolver for the createPost field.
+func (r *mutationResolver) CreatePost(ctx context.Context, post model.PostInput) (*model.Post, error) {
+	return r.ps.CreatePost(post, ctx)
+}
+
+// UpdatePostTitle is the resolver for the updatePostTitle field.
 func (r *mutationResolver) UpdatePostTitle(ctx context.Context, postID string, title string) (*model.Post, error) {
 	return r.ps.UpdatePostTitle(ctx, postID, title)
 }
 
+// UpdatePostBody is the resolver for the updatePostBody field.
 func (r *mutationResolver) UpdatePostBody(ctx context.Context, postID string, body string) (*model.Post, error) {
 	return r.ps.UpdatePostBody(ctx, postID, body)
 }
 
+// UpdatePostCommentsAllowance is the resolver for the updatePostCommentsAllowance field.
 func (r *mutationResolver) UpdatePostCommentsAllowance(ctx context.Context, postID string, allow *bool) (*model.Post, error) {
 	if allow == nil {
 		return nil, nil
@@ -62,14 +114,101 @@ func (r *mutationResolver) UpdatePostCommentsAllowance(ctx context.Context, post
 	return r.ps.UpdatePostCommentsAllowance(ctx, postID, *allow)
 }
 
+// DeletePost is the resolver for the deletePost field.
+func (r *mutationResolver) DeletePost(ctx context.Context, postID string) (*string, error) {
+	return r.ps.DeletePost(ctx, postID)
+}
+
+// RestorePost is the resolver for the restorePost field.
+func (r *mutationResolver) RestorePost(ctx context.Context, postID string) (*model.Post, error) {
+	return r.ps.RestorePost(ctx, postID)
+}
+
+// CreateComment is the resolver for the createComment field.
+func (r *mutationResolver) CreateComment(ctx context.Context, comment model.CommentInput) (*model.Comment, error) {
+	return r.cs.CreateComment(comment, ctx)
+}
+
+// UpdateCommentBody is the resolver for the updateCommentBody field.
 func (r *mutationResolver) UpdateCommentBody(ctx context.Context, commentID string, body string) (*model.Comment, error) {
 	return r.cs.UpdateCommentBody(commentID, body, ctx)
 }
 
+// DeleteComment is the resolver for the deleteComment field.
+func (r *mutationResolver) DeleteComment(ctx context.Context, commentID string) (*string, error) {
+	return r.cs.DeleteComment(commentID, ctx)
+}
+
+// RestoreComment is the resolver for the restoreComment field.
+func (r *mutationResolver) RestoreComment(ctx context.Context, commentID string) (*model.Comment, error) {
+	return r.cs.RestoreComment(commentID, ctx)
+}
+
+// MarkNotificationsRead is the resolver for the markNotificationsRead field.
+func (r *mutationResolver) MarkNotificationsRead(ctx context.Context, ids []string) ([]*model.Notification, error) {
+	return r.ns.MarkNotificationsRead(ctx, ids)
+}
+
+// UpdateNotificationPreferences is the resolver for the updateNotificationPreferences field.
+func (r *mutationResolver) UpdateNotificationPreferences(ctx context.Context, preferences model.NotificationPreferencesInput) (*model.NotificationPreferences, error) {
+	return r.ns.UpdatePreferences(ctx, preferences)
+}
+
+// CreateWebhook is the resolver for the createWebhook field.
+func (r *mutationResolver) CreateWebhook(ctx context.Context, webhook model.WebhookInput) (*model.Webhook, error) {
+	return r.ws.CreateWebhook(ctx, webhook)
+}
+
+// DeleteWebhook is the resolver for the deleteWebhook field.
+func (r *mutationResolver) DeleteWebhook(ctx context.Context, webhookID string) (*string, error) {
+	return r.ws.DeleteWebhook(ctx, webhookID)
+}
+
+// RetryWebhookDelivery is the resolver for the retryWebhookDelivery field.
+func (r *mutationResolver) RetryWebhookDelivery(ctx context.Context, deliveryID string) (*model.WebhookDelivery, error) {
+	return r.ws.RetryDelivery(ctx, deliveryID)
+}
+
+// Comment is the resolver for the comment field.
+func (r *notificationResolver) Comment(ctx context.Context, obj *model.Notification) (*model.Comment, error) {
+	return r.cs.GetNotificationComment(obj, ctx)
+}
+
+// Author is the resolver for the author field.
+func (r *postResolver) Author(ctx context.Context, obj *model.Post) (*model.User, error) {
+	return r.us.GetAuthor(ctx, obj.AuthorID)
+}
+
+// Comments is the resolver for the comments field.
+func (r *postResolver) Comments(ctx context.Context, obj *model.Post, first *int32, after *string) (*model.CommentConnection, error) {
+	return r.cs.GetCommentsOfPost(obj, service.PageArgs{First: first, After: after}, ctx)
+}
+
+// Me is the resolver for the me field.
+func (r *queryResolver) Me(ctx context.Context) (*model.User, error) {
+	return r.us.Me(ctx)
+}
+
+// UserByID is the resolver for the userById field.
+func (r *queryResolver) UserByID(ctx context.Context, userID string) (*model.User, error) {
+	usr, err := r.us.GetUserById(ctx, userID)
+	return usr, err
+}
+
+// UserByName is the resolver for the userByName field.
+func (r *queryResolver) UserByName(ctx context.Context, username string) (*model.User, error) {
+	usr, err := r.us.GetUserByName(ctx, username)
+	return usr, err
+}
+
+// UserByEmail is the resolver for the userByEmail field.
+func (r *queryResolver) UserByEmail(ctx context.Context, email string) (*model.User, error) {
+	return r.us.GetUserByEmail(ctx, email)
+}
+
 // ListPosts is the resolver for the listPosts field.
-func (r *queryResolver) ListPosts(ctx context.Context, page int32) ([]*model.Post, error) {
-	post, err := r.ps.GetPostsByPage(uint64(page), ctx)
-	return post, err
+func (r *queryResolver) ListPosts(ctx context.Context, first *int32, after *string, last *int32, before *string) (*model.PostConnection, error) {
+	return r.ps.GetPosts(service.PageArgs{First: first, After: after, Last: last, Before: before}, ctx)
 }
 
 // Post is the resolver for the post field.
@@ -79,55 +218,125 @@ func (r *queryResolver) Post(ctx context.Context, postID string) (*model.Post, e
 }
 
 // PostComments is the resolver for the postComments field.
-func (r *queryResolver) PostComments(ctx context.Context, page int32, postID string) ([]*model.Comment, error) {
-	return r.cs.GetPostCommentsByPage(postID, uint64(page), ctx)
+func (r *queryResolver) PostComments(ctx context.Context, postID string, first *int32, after *string, last *int32, before *string) (*model.CommentConnection, error) {
+	return r.cs.GetPostComments(postID, service.PageArgs{First: first, After: after, Last: last, Before: before}, ctx)
 }
 
 // ChildComments is the resolver for the childComments field.
-func (r *queryResolver) ChildComments(ctx context.Context, page int32, commentID string) ([]*model.Comment, error) {
-	return r.cs.GetChildCommentsByPage(commentID, uint64(page), ctx)
+func (r *queryResolver) ChildComments(ctx context.Context, commentID string, first *int32, after *string, last *int32, before *string) (*model.CommentConnection, error) {
+	return r.cs.GetChildComments(commentID, service.PageArgs{First: first, After: after, Last: last, Before: before}, ctx)
 }
 
-func (r *queryResolver) UserByID(ctx context.Context, userID string) (*model.User, error) {
-	usr, err := r.us.GetUserById(ctx, userID)
-	return usr, err
+// CommentThread is the resolver for the commentThread field.
+func (r *queryResolver) CommentThread(ctx context.Context, postID string, maxDepth *int32, maxChildrenPerNode *int32, after *string) (*model.CommentThread, error) {
+	return r.cs.GetCommentThread(postID, maxDepth, maxChildrenPerNode, after, ctx)
 }
 
-func (r *queryResolver) UserByName(ctx context.Context, username string) (*model.User, error) {
-	usr, err := r.us.GetUserByName(ctx, username)
-	return usr, err
+// Notifications is the resolver for the notifications field.
+func (r *queryResolver) Notifications(ctx context.Context, first *int32, after *string, last *int32, before *string, unreadOnly *bool) (*model.NotificationConnection, error) {
+	return r.ns.GetNotifications(ctx, service.PageArgs{First: first, After: after, Last: last, Before: before}, unreadOnly)
+}
+
+// NotificationPreferences is the resolver for the notificationPreferences field.
+func (r *queryResolver) NotificationPreferences(ctx context.Context) (*model.NotificationPreferences, error) {
+	return r.ns.GetPreferences(ctx)
+}
+
+// Webhooks is the resolver for the webhooks field.
+func (r *queryResolver) Webhooks(ctx context.Context) ([]*model.Webhook, error) {
+	return r.ws.GetWebhooks(ctx)
+}
+
+// WebhookDeliveries is the resolver for the webhookDeliveries field.
+func (r *queryResolver) WebhookDeliveries(ctx context.Context, webhookID *string, status *model.WebhookDeliveryStatus, first *int32, after *string, last *int32, before *string) (*model.WebhookDeliveryConnection, error) {
+	return r.ws.GetDeliveries(ctx, webhookID, status, service.PageArgs{First: first, After: after, Last: last, Before: before})
 }
 
 // CommentCreated is the resolver for the commentCreated field.
-func (r *subscriptionResolver) CommentCreated(ctx context.Context, postId string) (<-chan *model.Comment, error) {
-	comments := make(chan *model.Comment, 5)
+func (r *subscriptionResolver) CommentCreated(ctx context.Context, postID string, since *string) (<-chan *model.Comment, error) {
+	return subscribe(ctx, r.ss, service.PostTopic(postID), since, commentsOfKind(model.CommentEventKindCreated))
+}
+
+// CommentUpdated is the resolver for the commentUpdated field.
+func (r *subscriptionResolver) CommentUpdated(ctx context.Context, postID string, since *string) (<-chan *model.Comment, error) {
+	return subscribe(ctx, r.ss, service.PostTopic(postID), since, commentsOfKind(model.CommentEventKindUpdated))
+}
 
-	go func() {
-		defer r.ss.Unsubscribe(postId, comments)
-		r.ss.Subscribe(postId, comments)
+// CommentDeleted is the resolver for the commentDeleted field.
+func (r *subscriptionResolver) CommentDeleted(ctx context.Context, postID string, since *string) (<-chan *model.Comment, error) {
+	return subscribe(ctx, r.ss, service.PostTopic(postID), since, commentsOfKind(model.CommentEventKindDeleted))
+}
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
+// CommentEvents is the resolver for the commentEvents field.
+func (r *subscriptionResolver) CommentEvents(ctx context.Context, postID string, since *string) (<-chan *model.CommentEvent, error) {
+	return subscribe(ctx, r.ss, service.PostTopic(postID), since, commentEvents)
+}
+
+// PostUpdated is the resolver for the postUpdated field.
+func (r *subscriptionResolver) PostUpdated(ctx context.Context, postID string, since *string) (<-chan *model.PostEvent, error) {
+	return subscribe(ctx, r.ss, service.PostTopic(postID), since, postEvents)
+}
+
+// CommentReplied is the resolver for the commentReplied field.
+func (r *subscriptionResolver) CommentReplied(ctx context.Context, commentID string, since *string) (<-chan *model.Comment, error) {
+	return subscribe(ctx, r.ss, service.RepliesTopic(commentID), since, commentsOfKind(model.CommentEventKindCreated))
+}
+
+// MyActivity is the resolver for the myActivity field.
+func (r *subscriptionResolver) MyActivity(ctx context.Context, since *string) (<-chan *model.CommentEvent, error) {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errs.ErrUnauthenticated
+	}
 
-	return comments, nil
+	return subscribe(ctx, r.ss, service.UserTopic(user.ID), since, commentEvents)
 }
 
+// NotificationReceived is the resolver for the notificationReceived field.
+func (r *subscriptionResolver) NotificationReceived(ctx context.Context, since *string) (<-chan *model.Notification, error) {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errs.ErrUnauthenticated
+	}
+
+	return subscribe(ctx, r.ss, service.NotificationsTopic(user.ID), since, notifications)
+}
+
+// Posts is the resolver for the posts field.
+func (r *userResolver) Posts(ctx context.Context, obj *model.User, first *int32, after *string) (*model.PostConnection, error) {
+	return r.ps.GetPostsByAuthor(obj.ID, service.PageArgs{First: first, After: after}, ctx)
+}
+
+// Comments is the resolver for the comments field.
+func (r *userResolver) Comments(ctx context.Context, obj *model.User, first *int32, after *string) (*model.CommentConnection, error) {
+	return r.cs.GetCommentsByAuthor(obj.ID, service.PageArgs{First: first, After: after}, ctx)
+}
+
+// Comment returns CommentResolver implementation.
+func (r *Resolver) Comment() CommentResolver { return &commentResolver{r} }
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 
+// Notification returns NotificationResolver implementation.
+func (r *Resolver) Notification() NotificationResolver { return &notificationResolver{r} }
+
+// Post returns PostResolver implementation.
+func (r *Resolver) Post() PostResolver { return &postResolver{r} }
+
 // Query returns QueryResolver implementation.
 func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
 
 // Subscription returns SubscriptionResolver implementation.
 func (r *Resolver) Subscription() SubscriptionResolver { return &subscriptionResolver{r} }
 
-type mutationResolver struct{ *Resolver }
+// User returns UserResolver implementation.
+func (r *Resolver) User() UserResolver { return &userResolver{r} }
 
+type commentResolver struct{ *Resolver }
+type mutationResolver struct{ *Resolver }
+type notificationResolver struct{ *Resolver }
+type postResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
-
 type subscriptionResolver struct{ *Resolver }
+type userResolver struct{ *Resolver }
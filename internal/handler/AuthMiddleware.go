@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/k0ch3gar/ozon-task/internal/auth"
+	"github.com/k0ch3gar/ozon-task/internal/service"
+)
+
+type AuthMiddleware func(next http.Handler) http.Handler
+
+// NewAuthMiddleware resolves the bearer token of a request into the current
+// user. Requests without a token are passed through anonymously, requests with
+// an invalid one are rejected.
+func NewAuthMiddleware(as *service.AuthService) AuthMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r.Header.Get("Authorization"))
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal, err := as.Authenticate(r.Context(), token)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// newWebsocketInitFunc authenticates subscriptions, since browsers cannot set
+// headers on websocket upgrades and send the token in connection_init instead.
+func newWebsocketInitFunc(as *service.AuthService) transport.WebsocketInitFunc {
+	return func(ctx context.Context, initPayload transport.InitPayload) (context.Context, *transport.InitPayload, error) {
+		if _, ok := auth.PrincipalFromContext(ctx); ok {
+			return ctx, nil, nil
+		}
+
+		token, ok := bearerToken(initPayload.Authorization())
+		if !ok {
+			return ctx, nil, nil
+		}
+
+		principal, err := as.Authenticate(ctx, token)
+		if err != nil {
+			return ctx, nil, err
+		}
+
+		return auth.WithPrincipal(ctx, principal), nil, nil
+	}
+}
+
+func bearerToken(header string) (string, bool) {
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	return strings.TrimSpace(header[len(prefix):]), true
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/dataloader"
+	"github.com/k0ch3gar/ozon-task/internal/storage"
+)
+
+type DataLoaderMiddleware func(next http.Handler) http.Handler
+
+// NewDataLoaderMiddleware gives every request its own loaders, so cached rows
+// never leak between requests. Websocket connections are skipped: they live
+// for the whole subscription and would keep serving stale rows.
+func NewDataLoaderMiddleware(
+	u storage.UserStorage,
+	p storage.PostStorage,
+	c storage.CommentStorage,
+	params config.ApplicationParameters,
+) DataLoaderMiddleware {
+	opt := dataloader.NewLoaderOpt(params)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if websocket.IsWebSocketUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			loaders := dataloader.NewLoaders(u, p, c, opt)
+			next.ServeHTTP(w, r.WithContext(dataloader.WithLoaders(r.Context(), loaders)))
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const internalErrorMessage = "internal server error"
+
+// NewErrorPresenter maps domain errors to stable extensions.code values.
+// Errors that are not domain errors are logged and, unless debug is on,
+// replaced with a generic message so storage details do not leak to clients.
+func NewErrorPresenter(debug bool) graphql.ErrorPresenterFunc {
+	return func(ctx context.Context, err error) *gqlerror.Error {
+		gqlErr := graphql.DefaultErrorPresenter(ctx, err)
+
+		var domainErr *errs.Error
+		if errors.As(err, &domainErr) {
+			gqlErr.Message = domainErr.Message
+			gqlErr.Extensions = domainErr.Extensions()
+			return gqlErr
+		}
+
+		// Errors produced by gqlgen itself, such as query validation or
+		// argument parsing, are already meant for the client.
+		if gqlErr.Err == nil || gqlErr.Extensions["code"] != nil {
+			return gqlErr
+		}
+
+		log.Printf("internal error at %s: %v", gqlErr.Path, err)
+		if !debug {
+			gqlErr.Message = internalErrorMessage
+		}
+
+		gqlErr.Extensions = map[string]interface{}{
+			"code": errs.CodeInternal,
+		}
+
+		return gqlErr
+	}
+}
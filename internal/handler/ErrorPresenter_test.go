@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+func TestErrorPresenterMapsDomainErrors(t *testing.T) {
+	presenter := NewErrorPresenter(false)
+	ctx := graphql.WithResponseContext(context.Background(), graphql.DefaultErrorPresenter, nil)
+
+	gqlErr := presenter(ctx, errs.NotFound("no such post: %s", "1"))
+	assert.Equal(t, "no such post: 1", gqlErr.Message)
+	assert.Equal(t, errs.CodeNotFound, gqlErr.Extensions["code"])
+
+	gqlErr = presenter(ctx, errs.InvalidField("title", "must not be empty"))
+	assert.Equal(t, errs.CodeValidation, gqlErr.Extensions["code"])
+	assert.Equal(t, []errs.FieldError{{Field: "title", Message: "must not be empty"}}, gqlErr.Extensions["fields"])
+}
+
+func TestErrorPresenterMasksInternalErrors(t *testing.T) {
+	ctx := graphql.WithResponseContext(context.Background(), graphql.DefaultErrorPresenter, nil)
+	err := errors.New(`pq: relation "posts" does not exist`)
+
+	gqlErr := NewErrorPresenter(false)(ctx, err)
+	assert.Equal(t, internalErrorMessage, gqlErr.Message)
+	assert.Equal(t, errs.CodeInternal, gqlErr.Extensions["code"])
+
+	gqlErr = NewErrorPresenter(true)(ctx, err)
+	assert.Equal(t, err.Error(), gqlErr.Message)
+	assert.Equal(t, errs.CodeInternal, gqlErr.Extensions["code"])
+
+	gqlErr = NewErrorPresenter(false)(ctx, gqlerror.Errorf("Cannot query field \"foo\""))
+	assert.Equal(t, "Cannot query field \"foo\"", gqlErr.Message)
+}
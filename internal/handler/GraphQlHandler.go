@@ -8,11 +8,13 @@ import (
 	"github.com/99designs/gqlgen/graphql/handler/lru"
 	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/gorilla/websocket"
+	config2 "github.com/k0ch3gar/ozon-task/internal/config"
 	graph2 "github.com/k0ch3gar/ozon-task/internal/graph"
+	"github.com/k0ch3gar/ozon-task/internal/service"
 	"github.com/vektah/gqlparser/v2/ast"
 )
 
-func NewGraphQlServer(config graph2.Config) *handler.Server {
+func NewGraphQlServer(config graph2.Config, as *service.AuthService, params config2.ApplicationParameters) *handler.Server {
 	srv := handler.New(graph2.NewExecutableSchema(config))
 
 	srv.AddTransport(transport.Options{})
@@ -22,11 +24,15 @@ func NewGraphQlServer(config graph2.Config) *handler.Server {
 		Upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
+		InitFunc: newWebsocketInitFunc(as),
 	})
 
+	srv.SetErrorPresenter(NewErrorPresenter(params.Debug))
 	srv.SetQueryCache(lru.New[*ast.QueryDocument](1000))
 
 	srv.Use(extension.Introspection{})
+	srv.Use(StreamErrors{})
+	srv.Use(StreamEventIDs{})
 	srv.Use(extension.AutomaticPersistedQuery{
 		Cache: lru.New[string](100),
 	})
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	graph2 "github.com/k0ch3gar/ozon-task/internal/graph"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// StreamErrors sends the error a subscription ended with as its last
+// response. Without it a closed subscription only completes, and the client
+// cannot tell it was disconnected.
+type StreamErrors struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationInterceptor
+	graphql.ResponseInterceptor
+} = StreamErrors{}
+
+func (StreamErrors) ExtensionName() string {
+	return "StreamErrors"
+}
+
+func (StreamErrors) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (StreamErrors) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	opCtx := graphql.GetOperationContext(ctx)
+	if opCtx.Operation == nil || opCtx.Operation.Operation != ast.Subscription {
+		return next(ctx)
+	}
+
+	return next(graph2.WithStreamError(ctx))
+}
+
+func (StreamErrors) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	resp := next(ctx)
+	if resp != nil {
+		return resp
+	}
+
+	if err := graph2.TakeStreamError(ctx); err != nil {
+		graphql.AddError(ctx, err)
+		return &graphql.Response{Errors: graphql.GetErrors(ctx)}
+	}
+
+	return nil
+}
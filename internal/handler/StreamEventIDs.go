@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	graph2 "github.com/k0ch3gar/ozon-task/internal/graph"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// StreamEventIDs adds the ID of the event behind every subscription response
+// as extensions.eventId, clients pass the last one as since to resume.
+type StreamEventIDs struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationInterceptor
+	graphql.ResponseInterceptor
+} = StreamEventIDs{}
+
+func (StreamEventIDs) ExtensionName() string {
+	return "StreamEventIDs"
+}
+
+func (StreamEventIDs) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (StreamEventIDs) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	opCtx := graphql.GetOperationContext(ctx)
+	if opCtx.Operation == nil || opCtx.Operation.Operation != ast.Subscription {
+		return next(ctx)
+	}
+
+	return next(graph2.WithStreamEventIDs(ctx))
+}
+
+func (StreamEventIDs) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	resp := next(ctx)
+	if resp == nil || resp.Data == nil {
+		return resp
+	}
+
+	if id, ok := graph2.TakeStreamEventID(ctx); ok {
+		if resp.Extensions == nil {
+			resp.Extensions = make(map[string]any)
+		}
+
+		resp.Extensions["eventId"] = id
+	}
+
+	return resp
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/auth"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+	"github.com/k0ch3gar/ozon-task/internal/storage"
+	model2 "github.com/k0ch3gar/ozon-task/internal/storage/model"
+	"github.com/k0ch3gar/ozon-task/internal/utils"
+)
+
+var errInvalidCredentials = errs.Unauthenticated("invalid username or password")
+
+type AuthService struct {
+	us     storage.UserStorage
+	ss     storage.SessionStorage
+	hasher auth.PasswordHasher
+	tokens *auth.TokenManager
+}
+
+func NewAuthService(
+	us storage.UserStorage,
+	ss storage.SessionStorage,
+	hasher auth.PasswordHasher,
+	tokens *auth.TokenManager,
+) *AuthService {
+	return &AuthService{
+		us:     us,
+		ss:     ss,
+		hasher: hasher,
+		tokens: tokens,
+	}
+}
+
+func (as *AuthService) Login(ctx context.Context, username string, password string) (*model.AuthPayload, error) {
+	user, err := as.us.GetUserByName(username, ctx)
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+
+	ok, err := as.hasher.Verify(password, user.Password)
+	if err != nil || !ok {
+		return nil, errInvalidCredentials
+	}
+
+	if as.hasher.NeedsRehash(user.Password) {
+		hash, err := as.hasher.Hash(password)
+		if err != nil {
+			return nil, err
+		}
+
+		user.Password = hash
+		if err = as.us.UpdateUser(user, ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	session := &model2.Session{
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(as.tokens.TTL(auth.RefreshToken)).Format(time.RFC3339),
+	}
+	if err = as.ss.InsertSession(session, ctx); err != nil {
+		return nil, err
+	}
+
+	return as.issueTokens(ctx, user, session)
+}
+
+func (as *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*model.AuthPayload, error) {
+	claims, err := as.tokens.Parse(refreshToken, auth.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := as.ss.GetSessionById(claims.SessionID, ctx)
+	if err != nil {
+		return nil, auth.ErrInvalidToken
+	}
+
+	if session.RefreshTokenID != claims.ID {
+		// A rotated refresh token is being replayed, so the whole session is
+		// considered compromised.
+		if err = as.ss.RevokeSession(session.ID, ctx); err != nil {
+			return nil, err
+		}
+
+		return nil, auth.ErrInvalidToken
+	}
+
+	user, err := as.us.GetUserById(session.UserID, ctx)
+	if err != nil {
+		return nil, auth.ErrInvalidToken
+	}
+
+	return as.issueTokens(ctx, user, session)
+}
+
+func (as *AuthService) Logout(ctx context.Context) (bool, error) {
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok || principal.SessionID == "" {
+		return false, errs.ErrUnauthenticated
+	}
+
+	if err := as.ss.RevokeSession(principal.SessionID, ctx); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Authenticate resolves an access token into the caller it was issued to.
+func (as *AuthService) Authenticate(ctx context.Context, accessToken string) (*auth.Principal, error) {
+	claims, err := as.tokens.Parse(accessToken, auth.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = as.ss.GetSessionById(claims.SessionID, ctx); err != nil {
+		return nil, auth.ErrInvalidToken
+	}
+
+	user, err := as.us.GetUserById(claims.Subject, ctx)
+	if err != nil {
+		return nil, auth.ErrInvalidToken
+	}
+
+	return &auth.Principal{
+		User:      user,
+		SessionID: claims.SessionID,
+	}, nil
+}
+
+func (as *AuthService) issueTokens(ctx context.Context, user *model2.User, session *model2.Session) (*model.AuthPayload, error) {
+	accessToken, accessClaims, err := as.tokens.Issue(user.ID, session.ID, auth.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, refreshClaims, err := as.tokens.Issue(user.ID, session.ID, auth.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	session.RefreshTokenID = refreshClaims.ID
+	session.ExpiresAt = time.Unix(refreshClaims.ExpiresAt, 0).Format(time.RFC3339)
+	if err = as.ss.UpdateSession(session, ctx); err != nil {
+		return nil, err
+	}
+
+	return &model.AuthPayload{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    time.Unix(accessClaims.ExpiresAt, 0).Format(time.RFC3339),
+		User:         utils.FromStorageUser(user),
+	}, nil
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+
+	"github.com/k0ch3gar/ozon-task/internal/auth"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+)
+
+// authorizeAuthorOrModerator lets the caller change a resource only if they
+// wrote it or are at least a moderator.
+func authorizeAuthorOrModerator(ctx context.Context, authorId *string) (*model.User, error) {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errs.ErrUnauthenticated
+	}
+
+	if auth.HasRole(user, model.RoleModerator) {
+		return user, nil
+	}
+
+	if authorId != nil && *authorId == user.ID {
+		return user, nil
+	}
+
+	return nil, errs.Forbidden("only the author or a moderator can do this")
+}
+
+// authorizeRestore lets authors bring back only what they deleted themselves,
+// content removed by anyone else takes a moderator. Live content is left to
+// the storage to report.
+func authorizeRestore(ctx context.Context, authorId *string, deletedAt *string, deletedBy *string) error {
+	user, err := authorizeAuthorOrModerator(ctx, authorId)
+	if err != nil {
+		return err
+	}
+
+	if deletedAt == nil || auth.HasRole(user, model.RoleModerator) || (deletedBy != nil && *deletedBy == user.ID) {
+		return nil
+	}
+
+	return errs.Forbidden("only a moderator can restore content someone else deleted")
+}
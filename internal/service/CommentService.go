@@ -4,108 +4,368 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
+	"time"
 
+	"github.com/k0ch3gar/ozon-task/internal/auth"
 	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/dataloader"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
 	"github.com/k0ch3gar/ozon-task/internal/graph/model"
 	"github.com/k0ch3gar/ozon-task/internal/storage"
+	model2 "github.com/k0ch3gar/ozon-task/internal/storage/model"
 	"github.com/k0ch3gar/ozon-task/internal/utils"
+	"github.com/k0ch3gar/ozon-task/internal/validation"
 )
 
 type CommentService struct {
-	u        storage.UserStorage
-	p        storage.PostStorage
-	c        storage.CommentStorage
-	pageSize uint64
+	u             storage.UserStorage
+	p             storage.PostStorage
+	c             storage.CommentStorage
+	l             storage.LifecycleStorage
+	events        *SubscriptionService
+	notifications *NotificationService
+	validator     *validation.Validator
+	pages         pagination
+	threads       threadLimits
+	// maxCommentDepth is the deepest level a reply may be created at.
+	maxCommentDepth uint64
+	// retention is how long deleted comments can be restored.
+	retention time.Duration
+}
+
+type threadLimits struct {
+	depth    uint64
+	maxDepth uint64
+	maxNodes uint64
 }
 
 func NewCommentService(
 	u storage.UserStorage,
 	p storage.PostStorage,
 	c storage.CommentStorage,
+	l storage.LifecycleStorage,
+	events *SubscriptionService,
+	notifications *NotificationService,
+	validator *validation.Validator,
 	params config.ApplicationParameters,
 ) *CommentService {
 	return &CommentService{
-		u:        u,
-		c:        c,
-		p:        p,
-		pageSize: params.PageSize,
+		u:             u,
+		c:             c,
+		p:             p,
+		l:             l,
+		events:        events,
+		notifications: notifications,
+		validator:     validator,
+		pages:         newPagination(params),
+		threads: threadLimits{
+			depth:    params.ThreadDepth,
+			maxDepth: params.MaxThreadDepth,
+			maxNodes: params.MaxThreadNodes,
+		},
+		maxCommentDepth: params.MaxCommentDepth,
+		retention:       params.RetentionPeriod,
 	}
 }
 
-func (cs *CommentService) GetPostCommentsByPage(postId string, page uint64, ctx context.Context) ([]*model.Comment, error) {
-	post, err := cs.p.GetPostById(postId, ctx)
+func (cs *CommentService) GetPostComments(postId string, args PageArgs, ctx context.Context) (*model.CommentConnection, error) {
+	_, err := cs.getCommentablePost(postId, ctx)
 	if err != nil {
-		return nil, errors.New("no such post")
+		return nil, err
 	}
 
-	if !post.AllowComments {
-		return nil, errors.New("comments are not allowed")
+	query, err := cs.pages.query(args)
+	if err != nil {
+		return nil, err
 	}
 
-	comments, err := cs.c.GetFirstCommentsByPost(postId, page*cs.pageSize, cs.pageSize, ctx)
+	comments, err := cs.c.GetCommentsByPost(postId, query, ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(comments) == 0 {
-		return nil, nil
+	total, err := cs.c.CountCommentsByPost(postId, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCommentConnection(comments, query, total), nil
+}
+
+func (cs *CommentService) GetChildComments(commentId string, args PageArgs, ctx context.Context) (*model.CommentConnection, error) {
+	comment, err := cs.GetCommentById(commentId, ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	apiComments := make([]*model.Comment, len(comments))
-	for i := range apiComments {
-		apiComments[i] = utils.FromStorageComment(comments[i])
+	_, err = cs.getCommentablePost(comment.ParentPostID, ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return apiComments, nil
+	query, err := cs.pages.query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := cs.c.GetCommentsByComment(commentId, query, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := cs.c.CountCommentsByComment(commentId, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCommentConnection(comments, query, total), nil
 }
 
-func (cs *CommentService) GetChildCommentsByPage(commentId string, page uint64, ctx context.Context) ([]*model.Comment, error) {
-	comment, err := cs.GetCommentById(commentId, ctx)
+// GetCommentThread returns the top level comments of a post together with
+// their replies, depth first. Nodes cut off by the limits are marked, their
+// replies can be loaded with childComments starting after the last one shown.
+func (cs *CommentService) GetCommentThread(postId string, maxDepth *int32, maxChildren *int32, after *string, ctx context.Context) (*model.CommentThread, error) {
+	_, err := cs.getCommentablePost(postId, ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	post, err := cs.p.GetPostById(comment.ParentPostID, ctx)
+	query, err := cs.threadQuery(maxDepth, maxChildren, after)
 	if err != nil {
-		return nil, errors.New("no such post")
+		return nil, err
 	}
 
-	if !post.AllowComments {
-		return nil, errors.New("comments are not allowed")
+	thread, err := cs.c.GetCommentThread(postId, query, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.CommentThread{
+		Nodes:        make([]*model.CommentThreadNode, len(thread.Nodes)),
+		HasMoreRoots: thread.HasMoreRoots,
+	}
+
+	for i, node := range thread.Nodes {
+		cursor := encodeCursor(commentCursor(node.Comment))
+		result.Nodes[i] = &model.CommentThreadNode{
+			Comment:         utils.FromStorageComment(node.Comment),
+			Depth:           int32(node.Depth),
+			Path:            node.Path,
+			Cursor:          cursor,
+			HasMoreChildren: node.HasMoreChildren,
+		}
+
+		if node.Depth == 0 {
+			result.EndCursor = &cursor
+		}
+	}
+
+	return result, nil
+}
+
+func (cs *CommentService) threadQuery(maxDepth *int32, maxChildren *int32, after *string) (storage.ThreadQuery, error) {
+	query := storage.ThreadQuery{
+		MaxDepth:    cs.threads.depth,
+		MaxChildren: cs.pages.pageSize,
+	}
+
+	if maxDepth != nil {
+		if *maxDepth < 0 {
+			return storage.ThreadQuery{}, errs.InvalidField("maxDepth", "must not be negative")
+		}
+
+		if uint64(*maxDepth) > cs.threads.maxDepth {
+			return storage.ThreadQuery{}, errs.InvalidField("maxDepth", fmt.Sprintf("must be at most %d", cs.threads.maxDepth))
+		}
+
+		query.MaxDepth = uint64(*maxDepth)
+	}
+
+	if maxChildren != nil {
+		size, err := cs.pages.size("maxChildrenPerNode", *maxChildren)
+		if err != nil {
+			return storage.ThreadQuery{}, err
+		}
+
+		query.MaxChildren = size
+	}
+
+	if threadSize(query.MaxChildren, query.MaxDepth, cs.threads.maxNodes) > cs.threads.maxNodes {
+		return storage.ThreadQuery{}, errs.InvalidField("maxDepth", fmt.Sprintf("thread may not exceed %d comments, lower maxDepth or maxChildrenPerNode", cs.threads.maxNodes))
+	}
+
+	cursor, err := decodeCursor("after", after)
+	if err != nil {
+		return storage.ThreadQuery{}, err
+	}
+
+	query.After = cursor
+	return query, nil
+}
+
+// threadSize is the most comments a thread can hold, it stops counting once
+// limit is exceeded so large limits cannot overflow.
+func threadSize(children uint64, depth uint64, limit uint64) uint64 {
+	var total uint64
+	level := uint64(1)
+	for d := uint64(0); d <= depth; d++ {
+		if children != 0 && level > limit/children {
+			return limit + 1
+		}
+
+		level *= children
+		total += level
+		if total > limit {
+			return total
+		}
+	}
+
+	return total
+}
+
+// GetCommentsOfPost lists the top level comments of an already resolved post.
+// Unlike GetPostComments it does not fail when comments are turned off, the
+// post just has no visible comments then.
+func (cs *CommentService) GetCommentsOfPost(post *model.Post, args PageArgs, ctx context.Context) (*model.CommentConnection, error) {
+	query, err := cs.pages.query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if post.Deleted || !post.AllowComments {
+		return newCommentConnection(nil, query, 0), nil
+	}
+
+	comments, err := cs.c.GetCommentsByPost(post.ID, query, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := cs.c.CountCommentsByPost(post.ID, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCommentConnection(comments, query, total), nil
+}
+
+func (cs *CommentService) GetReplies(comment *model.Comment, args PageArgs, ctx context.Context) (*model.CommentConnection, error) {
+	query, err := cs.pages.query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if comment.Deleted {
+		return newCommentConnection(nil, query, 0), nil
+	}
+
+	comments, err := cs.c.GetCommentsByComment(comment.ID, query, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := cs.c.CountCommentsByComment(comment.ID, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCommentConnection(comments, query, total), nil
+}
+
+func (cs *CommentService) GetCommentsByAuthor(authorId string, args PageArgs, ctx context.Context) (*model.CommentConnection, error) {
+	query, err := cs.pages.query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := cs.c.GetCommentsByAuthor(authorId, query, ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	comments, err := cs.c.GetFirstCommentsByComment(commentId, page*cs.pageSize, cs.pageSize, ctx)
+	total, err := cs.c.CountCommentsByAuthor(authorId, ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(comments) == 0 {
+	return newCommentConnection(comments, query, total), nil
+}
+
+// GetParentComment resolves the comment a reply was left under, a deleted
+// parent is replaced with model.DeadComment.
+func (cs *CommentService) GetParentComment(comment *model.Comment, ctx context.Context) (*model.Comment, error) {
+	if comment.ParentCommentID == nil {
 		return nil, nil
 	}
 
-	apiComments := make([]*model.Comment, len(comments))
-	for i := range apiComments {
-		apiComments[i] = utils.FromStorageComment(comments[i])
+	parentId := *comment.ParentCommentID
+	parent, err := cs.loadComment(ctx, parentId)
+	if errors.Is(err, errs.ErrDeleted) || errors.Is(err, errs.ErrNotFound) || (err == nil && parent.DeletedAt != nil) {
+		return utils.NewDeadComment(parentId, comment.ParentPostID), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return utils.FromStorageComment(parent), nil
+}
+
+// GetNotificationComment resolves the comment a notification is about, a
+// deleted or purged comment is replaced with model.DeadComment.
+func (cs *CommentService) GetNotificationComment(notification *model.Notification, ctx context.Context) (*model.Comment, error) {
+	comment, err := cs.loadComment(ctx, notification.CommentID)
+	if errors.Is(err, errs.ErrDeleted) || errors.Is(err, errs.ErrNotFound) {
+		return utils.NewDeadComment(notification.CommentID, notification.PostID), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return utils.FromStorageComment(comment), nil
+}
+
+func (cs *CommentService) loadComment(ctx context.Context, commentId string) (*model2.Comment, error) {
+	if loaders, ok := dataloader.LoadersFromContext(ctx); ok {
+		return loaders.Comments.Load(ctx, commentId)
 	}
 
-	return apiComments, nil
+	return cs.c.GetCommentById(commentId, ctx)
 }
 
 func (cs *CommentService) CreateComment(commentInput model.CommentInput, ctx context.Context) (*model.Comment, error) {
-	post, err := cs.p.GetPostById(commentInput.ParentPostID, ctx)
-	if err != nil {
-		return nil, errors.New("no such post")
+	author, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errs.ErrUnauthenticated
 	}
 
-	if !post.AllowComments {
-		return nil, errors.New("comments are not allowed")
+	if err := cs.validator.CommentInput(commentInput); err != nil {
+		return nil, err
+	}
+
+	post, err := cs.getCommentablePost(commentInput.ParentPostID, ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	comment := utils.FromCommentInput(&commentInput)
+	comment := utils.FromCommentInput(&commentInput, author.ID)
 	if ok, err := cs.u.ContainsById(*comment.AuthorID, ctx); err != nil {
 		return nil, err
 	} else if !ok {
-		return nil, errors.New(fmt.Sprintf("author does not exists: %s", *comment.AuthorID))
+		return nil, errs.NotFound("author does not exist: %s", *comment.AuthorID)
+	}
+
+	// The reply may be attached higher up than the comment it answers, whose
+	// author is still the one notified.
+	var requested, parent *model2.Comment
+	if comment.ParentCommentID != nil {
+		requested, parent, err = cs.replyParent(*comment.ParentCommentID, comment.ParentPostID, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		comment.ParentCommentID = nil
+		if parent != nil {
+			comment.ParentCommentID = &parent.ID
+		}
 	}
 
 	err = cs.c.InsertComment(comment, ctx)
@@ -113,7 +373,84 @@ func (cs *CommentService) CreateComment(commentInput model.CommentInput, ctx con
 		return nil, err
 	}
 
-	return utils.FromStorageComment(comment), nil
+	created := utils.FromStorageComment(comment)
+	cs.events.PublishComment(ctx, model.CommentEventKindCreated, created, activityTopics(author.ID, post, requested, parent)...)
+
+	// The comment is created already, a failed notification must not make
+	// the client create it again.
+	if err = cs.notifications.Notify(ctx, comment, post, requested); err != nil {
+		log.Printf("unable to notify about comment %s: %v", comment.ID, err)
+	}
+
+	return created, nil
+}
+
+// activityTopics routes a new comment to the threads of its parents and to
+// the authors of the post and of the parents, unless they wrote it.
+func activityTopics(authorId string, post *model2.Post, parents ...*model2.Comment) []string {
+	var topics []string
+	notify := func(userId *string) {
+		if userId != nil && *userId != authorId {
+			topics = append(topics, UserTopic(*userId))
+		}
+	}
+
+	notify(post.AuthorID)
+	for _, parent := range parents {
+		if parent != nil {
+			topics = append(topics, RepliesTopic(parent.ID))
+			notify(parent.AuthorID)
+		}
+	}
+
+	return topics
+}
+
+// replyParent checks the comment being replied to and returns it together
+// with the one the reply is attached to. Once maxCommentDepth is reached the
+// reply is attached to the deepest live ancestor that may still have replies,
+// so threads grow longer instead of deeper. A nil attached parent makes the
+// reply a top level comment.
+func (cs *CommentService) replyParent(parentId string, postId string, ctx context.Context) (*model2.Comment, *model2.Comment, error) {
+	parent, err := cs.c.GetCommentById(parentId, ctx)
+	if errors.Is(err, errs.ErrNotFound) || errors.Is(err, errs.ErrDeleted) || (err == nil && parent.DeletedAt != nil) {
+		return nil, nil, errs.NotFound("no such parent comment: %s", parentId)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if parent.ParentPostID != postId {
+		return nil, nil, errs.InvalidField("parentCommentId", "belongs to another post")
+	}
+
+	if parent.Depth < cs.maxCommentDepth {
+		return parent, parent, nil
+	}
+
+	if cs.maxCommentDepth == 0 {
+		return parent, nil, nil
+	}
+
+	ancestors := storage.PathIds(parent.Path)
+	if uint64(len(ancestors)) < cs.maxCommentDepth {
+		return parent, parent, nil
+	}
+
+	// Tombstones take no replies, the nearest live ancestor above them does.
+	for i := int(cs.maxCommentDepth) - 1; i >= 0; i-- {
+		ancestor, err := cs.c.GetCommentById(ancestors[i], ctx)
+		if errors.Is(err, errs.ErrNotFound) || errors.Is(err, errs.ErrDeleted) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		if ancestor.DeletedAt == nil {
+			return parent, ancestor, nil
+		}
+	}
+
+	return parent, nil, nil
 }
 
 func (cs *CommentService) GetCommentById(commentId string, ctx context.Context) (*model.Comment, error) {
@@ -122,31 +459,26 @@ func (cs *CommentService) GetCommentById(commentId string, ctx context.Context)
 		return nil, err
 	}
 
-	post, err := cs.p.GetPostById(comment.ParentPostID, ctx)
+	_, err = cs.getCommentablePost(comment.ParentPostID, ctx)
 	if err != nil {
-		return nil, errors.New("no such post")
-	}
-
-	if !post.AllowComments {
-		return nil, errors.New("comments are not allowed")
+		return nil, err
 	}
 
 	return utils.FromStorageComment(comment), nil
 }
 
 func (cs *CommentService) UpdateCommentBody(commentId string, body string, ctx context.Context) (*model.Comment, error) {
-	comment, err := cs.c.GetCommentById(commentId, ctx)
-	if err != nil {
+	if err := cs.validator.CommentBody(body); err != nil {
 		return nil, err
 	}
 
-	post, err := cs.p.GetPostById(comment.ParentPostID, ctx)
+	comment, err := cs.getLiveComment(commentId, ctx)
 	if err != nil {
-		return nil, errors.New("no such post")
+		return nil, err
 	}
 
-	if !post.AllowComments {
-		return nil, errors.New("comments are not allowed")
+	if err = cs.authorizeCommentChange(ctx, comment); err != nil {
+		return nil, err
 	}
 
 	comment.Body = body
@@ -155,24 +487,119 @@ func (cs *CommentService) UpdateCommentBody(commentId string, body string, ctx c
 		return nil, err
 	}
 
-	return utils.FromStorageComment(comment), nil
+	updated := utils.FromStorageComment(comment)
+	cs.events.PublishComment(ctx, model.CommentEventKindUpdated, updated)
+	return updated, nil
 }
 
 func (cs *CommentService) DeleteComment(commentId string, ctx context.Context) (*string, error) {
+	comment, err := cs.getLiveComment(commentId, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cs.authorizeCommentChange(ctx, comment); err != nil {
+		return nil, err
+	}
+
+	caller, _ := auth.UserFromContext(ctx)
+	if err = cs.c.DeleteComment(commentId, caller.ID, ctx); err != nil {
+		return nil, err
+	}
+
+	dead := utils.NewDeadComment(commentId, comment.ParentPostID)
+	dead.ParentCommentID = comment.ParentCommentID
+	dead.CreatedAt = comment.CreatedAt
+	cs.events.PublishComment(ctx, model.CommentEventKindDeleted, dead)
+	return &commentId, nil
+}
+
+// RestoreComment brings back a comment deleted within the retention period.
+// Replies deleted on their own stay deleted.
+func (cs *CommentService) RestoreComment(commentId string, ctx context.Context) (*model.Comment, error) {
 	comment, err := cs.c.GetCommentById(commentId, ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	post, err := cs.p.GetPostById(comment.ParentPostID, ctx)
+	if err = authorizeRestore(ctx, comment.AuthorID, comment.DeletedAt, comment.DeletedBy); err != nil {
+		return nil, err
+	}
+
+	comment, err = cs.l.RestoreComment(commentId, time.Now().Add(-cs.retention), ctx)
 	if err != nil {
-		return nil, errors.New("no such post")
+		return nil, err
+	}
+
+	restored := utils.FromStorageComment(comment)
+	cs.events.PublishComment(ctx, model.CommentEventKindRestored, restored)
+	return restored, nil
+}
+
+// getLiveComment returns the comment unless it is a tombstone, which can no
+// longer be changed.
+func (cs *CommentService) getLiveComment(commentId string, ctx context.Context) (*model2.Comment, error) {
+	comment, err := cs.c.GetCommentById(commentId, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if comment.DeletedAt != nil {
+		return nil, errs.Deleted("comment with this id is deleted: %s", commentId)
+	}
+
+	return comment, nil
+}
+
+// authorizeCommentChange lets the author change their comment while the post
+// accepts comments, and moderators at any time: a post with comments turned
+// off is where moderation is needed most.
+func (cs *CommentService) authorizeCommentChange(ctx context.Context, comment *model2.Comment) error {
+	user, err := authorizeAuthorOrModerator(ctx, comment.AuthorID)
+	if err != nil {
+		return err
+	}
+
+	if auth.HasRole(user, model2.RoleModerator) {
+		_, err = cs.p.GetPostById(comment.ParentPostID, ctx)
+		return err
+	}
+
+	_, err = cs.getCommentablePost(comment.ParentPostID, ctx)
+	return err
+}
+
+// getCommentablePost returns the post only if it still accepts comments.
+func (cs *CommentService) getCommentablePost(postId string, ctx context.Context) (*model2.Post, error) {
+	post, err := cs.p.GetPostById(postId, ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	if !post.AllowComments {
-		return nil, errors.New("comments are not allowed")
+		return nil, errs.Forbidden("comments are not allowed for post: %s", postId)
+	}
+
+	return post, nil
+}
+
+func newCommentConnection(comments []*model2.Comment, query storage.PageQuery, total uint64) *model.CommentConnection {
+	comments, pageInfo := page(comments, query, commentCursor)
+	edges := make([]*model.CommentEdge, len(comments))
+	for i := range edges {
+		edges[i] = &model.CommentEdge{
+			Cursor: encodeCursor(commentCursor(comments[i])),
+			Node:   utils.FromStorageComment(comments[i]),
+		}
 	}
 
-	err = cs.c.DeleteComment(commentId, ctx)
-	return &commentId, err
+	return &model.CommentConnection{
+		Edges:      edges,
+		PageInfo:   pageInfo,
+		TotalCount: int32(total),
+	}
+}
+
+func commentCursor(comment *model2.Comment) storage.Cursor {
+	return storage.Cursor{CreatedAt: comment.CreatedAt, ID: comment.ID}
 }
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"go.uber.org/fx"
+)
+
+// EventBus carries the events published by SubscriptionService to every
+// instance of the server, the publishing one included.
+type EventBus interface {
+	// Publish gives the message its ID, which grows with every message on the
+	// bus, and keeps it in the replay log of each of its topics.
+	Publish(message *BusMessage, ctx context.Context) error
+	// Receive sets the handler of the messages coming from the bus, it must
+	// be called before the application starts.
+	Receive(handler func(*BusMessage))
+	// Replay returns the logged messages of the topic published after the
+	// one with the since ID, oldest first. The log is bounded, so older
+	// messages may be gone.
+	Replay(topic string, since uint64, ctx context.Context) ([]*BusMessage, error)
+}
+
+// BusMessage is an event together with the topics it is published to.
+type BusMessage struct {
+	ID     uint64   `json:"-"`
+	Topics []string `json:"topics"`
+	Event  *Event   `json:"event"`
+}
+
+func NewEventBusModule(params config.ApplicationParameters) fx.Option {
+	switch params.EventBus {
+	case config.PostgresEventBus:
+		if !params.PersistentStorageType {
+			return fx.Error(errors.New("the postgres event bus needs persistent storage"))
+		}
+
+		return fx.Module(
+			`eventbus`,
+			fx.Provide(NewDbEventBus),
+		)
+	default:
+		return fx.Module(
+			`eventbus`,
+			fx.Provide(NewInMemoryEventBus),
+		)
+	}
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"go.uber.org/fx"
+)
+
+const (
+	busChannel = "ozon_events"
+	// busRetention bounds how long events out of every replay log are kept,
+	// every instance reads them as soon as it is notified.
+	busRetention = time.Minute
+	// busPublishLock is the advisory lock publishers hold until their event
+	// is committed.
+	busPublishLock = 0x6f7a6f6e
+)
+
+// EventBusDb reaches every instance connected to the same database. A
+// NOTIFY payload is limited to 8000 bytes, which a post body alone may
+// exceed, so messages are stored in events and only their ids are sent over
+// LISTEN/NOTIFY. The replay log of a topic is kept in event_topics.
+type EventBusDb struct {
+	db      *pg.DB
+	logSize int
+	logTTL  time.Duration
+	handler func(*BusMessage)
+}
+
+func NewDbEventBus(lc fx.Lifecycle, params config.ApplicationParameters, db *pg.DB) EventBus {
+	bus := &EventBusDb{
+		db:      db,
+		logSize: params.ReplayLogSize,
+		logTTL:  params.ReplayLogTTL,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	var ln *pg.Listener
+	lc.Append(fx.Hook{
+		OnStart: func(startCtx context.Context) error {
+			ln = db.Listen(ctx, busChannel)
+			notifications := ln.Channel()
+			if err := bus.awaitListening(notifications, startCtx); err != nil {
+				_ = ln.Close()
+				return err
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				bus.run(notifications, ctx)
+			}()
+
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			cancel()
+			err := ln.Close()
+			wg.Wait()
+			return err
+		},
+	})
+
+	return bus
+}
+
+func (b *EventBusDb) Publish(message *BusMessage, ctx context.Context) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	// The notification is sent on commit, when the event is logged and the
+	// replay logs are trimmed.
+	return b.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		// Ids are only handed out under the lock, so events commit in the
+		// order of their ids and a subscriber resuming after one of them
+		// cannot skip an earlier event committed later.
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", busPublishLock); err != nil {
+			return err
+		}
+
+		_, err := tx.QueryOneContext(ctx, pg.Scan(&message.ID), `
+			WITH event AS (
+				INSERT INTO events (payload) VALUES (?) RETURNING id
+			), logged AS (
+				INSERT INTO event_topics (topic, event_id)
+				SELECT topics.name, event.id FROM event, unnest(?::text[]) AS topics(name)
+				WHERE ? > 0
+			)
+			SELECT id FROM event, pg_notify(?, id::text)`,
+			string(payload), pg.Array(message.Topics), b.logSize, busChannel)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM event_topics logged USING unnest(?::text[]) AS topics(name)
+			WHERE logged.topic = topics.name AND logged.event_id <= (
+				SELECT event_id FROM event_topics WHERE event_topics.topic = logged.topic
+				ORDER BY event_id DESC OFFSET ? LIMIT 1
+			)`,
+			pg.Array(message.Topics), b.logSize)
+		return err
+	})
+}
+
+func (b *EventBusDb) Receive(handler func(*BusMessage)) {
+	b.handler = handler
+}
+
+func (b *EventBusDb) Replay(topic string, since uint64, ctx context.Context) ([]*BusMessage, error) {
+	var rows []struct {
+		ID      uint64
+		Payload string
+	}
+
+	_, err := b.db.QueryContext(ctx, &rows, `
+		SELECT events.id, events.payload FROM event_topics
+		JOIN events ON events.id = event_topics.event_id
+		WHERE event_topics.topic = ? AND event_topics.event_id > ?
+			AND events.created_at >= NOW() - make_interval(secs => ?)
+		ORDER BY event_topics.event_id`,
+		topic, since, b.logTTL.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*BusMessage, 0, len(rows))
+	for _, row := range rows {
+		message, err := decodeBusMessage(row.ID, row.Payload)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// awaitListening notifies itself with an empty payload, once it arrives the
+// listener is sure to be registered and no message can be missed.
+func (b *EventBusDb) awaitListening(notifications <-chan pg.Notification, ctx context.Context) error {
+	if _, err := b.db.ExecContext(ctx, "SELECT pg_notify(?, '')", busChannel); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case notification, ok := <-notifications:
+			if !ok {
+				return errors.New("event bus listener is closed")
+			}
+
+			if notification.Payload == "" {
+				return nil
+			}
+		}
+	}
+}
+
+func (b *EventBusDb) run(notifications <-chan pg.Notification, ctx context.Context) {
+	ticker := time.NewTicker(busRetention)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.cleanup(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("event bus cleanup failed: %v", err)
+			}
+		case notification, ok := <-notifications:
+			if !ok {
+				return
+			}
+
+			// Empty payloads are the readiness checks of starting instances.
+			if notification.Payload == "" {
+				continue
+			}
+
+			message, err := b.load(notification.Payload, ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("event bus message %s is lost: %v", notification.Payload, err)
+				}
+
+				continue
+			}
+
+			if b.handler != nil {
+				b.handler(message)
+			}
+		}
+	}
+}
+
+// cleanup drops the events past the TTL of the replay logs, and the events
+// out of every log once all instances had the time to read them.
+func (b *EventBusDb) cleanup(ctx context.Context) error {
+	_, err := b.db.ExecContext(ctx, `
+		DELETE FROM event_topics USING events
+		WHERE events.id = event_topics.event_id AND events.created_at < NOW() - make_interval(secs => ?)`,
+		b.logTTL.Seconds())
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.ExecContext(ctx, `
+		DELETE FROM events WHERE created_at < NOW() - make_interval(secs => ?)
+			AND NOT EXISTS (SELECT 1 FROM event_topics WHERE event_topics.event_id = events.id)`,
+		busRetention.Seconds())
+	return err
+}
+
+func (b *EventBusDb) load(id string, ctx context.Context) (*BusMessage, error) {
+	var row struct {
+		ID      uint64
+		Payload string
+	}
+
+	if _, err := b.db.QueryOneContext(ctx, &row, "SELECT id, payload FROM events WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+
+	return decodeBusMessage(row.ID, row.Payload)
+}
+
+func decodeBusMessage(id uint64, payload string) (*BusMessage, error) {
+	message := &BusMessage{}
+	if err := json.Unmarshal([]byte(payload), message); err != nil {
+		return nil, err
+	}
+
+	message.ID = id
+	return message, nil
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+)
+
+// EventBusInMemory only reaches the subscribers of this instance.
+type EventBusInMemory struct {
+	mu        sync.Mutex
+	lastID    uint64
+	logs      map[string][]loggedMessage
+	logSize   int
+	logTTL    time.Duration
+	lastSweep time.Time
+	handler   func(*BusMessage)
+}
+
+type loggedMessage struct {
+	message     *BusMessage
+	publishedAt time.Time
+}
+
+func NewInMemoryEventBus(params config.ApplicationParameters) EventBus {
+	return &EventBusInMemory{
+		logs:      make(map[string][]loggedMessage),
+		logSize:   params.ReplayLogSize,
+		logTTL:    params.ReplayLogTTL,
+		lastSweep: time.Now(),
+	}
+}
+
+// Publish hands the message to the handler under the lock, so subscribers
+// see the messages in the order of their IDs.
+func (b *EventBusInMemory) Publish(message *BusMessage, ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.lastID++
+	message.ID = b.lastID
+	for _, topic := range message.Topics {
+		b.log(topic, loggedMessage{message: message, publishedAt: now}, now)
+	}
+
+	if now.Sub(b.lastSweep) >= b.logTTL {
+		b.sweep(now)
+	}
+
+	if b.handler != nil {
+		b.handler(message)
+	}
+
+	return nil
+}
+
+func (b *EventBusInMemory) Receive(handler func(*BusMessage)) {
+	b.handler = handler
+}
+
+func (b *EventBusInMemory) Replay(topic string, since uint64, ctx context.Context) ([]*BusMessage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	expiredBefore := time.Now().Add(-b.logTTL)
+	var messages []*BusMessage
+	for _, logged := range b.logs[topic] {
+		if logged.message.ID > since && logged.publishedAt.After(expiredBefore) {
+			messages = append(messages, logged.message)
+		}
+	}
+
+	return messages, nil
+}
+
+func (b *EventBusInMemory) log(topic string, logged loggedMessage, now time.Time) {
+	if b.logSize < 1 {
+		return
+	}
+
+	entries := append(b.logs[topic], logged)
+	if len(entries) > b.logSize {
+		entries = entries[len(entries)-b.logSize:]
+	}
+
+	b.logs[topic] = b.unexpired(entries, now)
+}
+
+// sweep drops the logs of the topics nothing was published to for longer
+// than the TTL.
+func (b *EventBusInMemory) sweep(now time.Time) {
+	b.lastSweep = now
+	for topic, entries := range b.logs {
+		if entries = b.unexpired(entries, now); len(entries) == 0 {
+			delete(b.logs, topic)
+		} else {
+			b.logs[topic] = entries
+		}
+	}
+}
+
+func (b *EventBusInMemory) unexpired(entries []loggedMessage, now time.Time) []loggedMessage {
+	for len(entries) > 0 && now.Sub(entries[0].publishedAt) >= b.logTTL {
+		entries = entries[1:]
+	}
+
+	return entries
+}
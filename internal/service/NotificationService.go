@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/k0ch3gar/ozon-task/internal/auth"
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+	"github.com/k0ch3gar/ozon-task/internal/storage"
+	model2 "github.com/k0ch3gar/ozon-task/internal/storage/model"
+	"github.com/k0ch3gar/ozon-task/internal/utils"
+)
+
+// maxMentions bounds the users a single comment may notify by mentioning
+// them, the rest of the mentions are ignored.
+const maxMentions = 10
+
+// mentionPattern matches @username. The name ends in a letter, a digit or an
+// underscore, so punctuation after a mention is not taken for a part of it.
+// Usernames with other characters, such as spaces, cannot be mentioned.
+var mentionPattern = regexp.MustCompile(`@([\p{L}\p{N}_](?:[\p{L}\p{N}_.\-]*[\p{L}\p{N}_])?)`)
+
+type NotificationService struct {
+	n      storage.NotificationStorage
+	u      storage.UserStorage
+	events *SubscriptionService
+	pages  pagination
+}
+
+func NewNotificationService(
+	params config.ApplicationParameters,
+	n storage.NotificationStorage,
+	u storage.UserStorage,
+	events *SubscriptionService,
+) *NotificationService {
+	return &NotificationService{
+		n:      n,
+		u:      u,
+		events: events,
+		pages:  newPagination(params),
+	}
+}
+
+// recipient is a user a new comment may concern, in the order the kinds are
+// tried.
+type recipient struct {
+	userId *string
+	kind   string
+}
+
+// Notify tells the users concerned about a new comment: the author of the
+// comment replied to, the mentioned users and the author of the post. Every
+// user gets at most one notification, of the first of these kinds they have
+// enabled, and nobody is notified about their own comment.
+func (ns *NotificationService) Notify(ctx context.Context, comment *model2.Comment, post *model2.Post, parent *model2.Comment) error {
+	var candidates []recipient
+	if parent != nil && parent.DeletedAt == nil {
+		candidates = append(candidates, recipient{userId: parent.AuthorID, kind: model2.NotificationReply})
+	}
+
+	mentioned, err := ns.mentionedUsers(comment.Body, ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, userId := range mentioned {
+		candidates = append(candidates, recipient{userId: &userId, kind: model2.NotificationMention})
+	}
+
+	candidates = append(candidates, recipient{userId: post.AuthorID, kind: model2.NotificationComment})
+
+	notified := make(map[string]struct{})
+	var notifications []*model2.Notification
+	for _, candidate := range candidates {
+		if candidate.userId == nil || *candidate.userId == *comment.AuthorID {
+			continue
+		}
+
+		userId := *candidate.userId
+		if _, ok := notified[userId]; ok {
+			continue
+		}
+
+		preferences, err := ns.n.GetPreferences(userId, ctx)
+		if err != nil {
+			return err
+		}
+
+		if !enabled(preferences, candidate.kind) {
+			continue
+		}
+
+		notified[userId] = struct{}{}
+		notifications = append(notifications, &model2.Notification{
+			UserID:    userId,
+			Kind:      candidate.kind,
+			CommentID: comment.ID,
+			PostID:    comment.ParentPostID,
+		})
+	}
+
+	if err = ns.n.InsertNotifications(notifications, ctx); err != nil {
+		return err
+	}
+
+	for _, notification := range notifications {
+		ns.events.PublishNotification(ctx, notification.UserID, utils.FromStorageNotification(notification))
+	}
+
+	return nil
+}
+
+// mentionedUsers returns the ids of the existing users mentioned in body.
+func (ns *NotificationService) mentionedUsers(body string, ctx context.Context) ([]string, error) {
+	var userIds []string
+	seen := make(map[string]struct{})
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		if len(seen) == maxMentions {
+			break
+		}
+
+		username := match[1]
+		if _, ok := seen[username]; ok {
+			continue
+		}
+		seen[username] = struct{}{}
+
+		user, err := ns.u.GetUserByName(username, ctx)
+		if errors.Is(err, errs.ErrNotFound) || errors.Is(err, errs.ErrDeleted) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if user.DeletedAt == nil {
+			userIds = append(userIds, user.ID)
+		}
+	}
+
+	return userIds, nil
+}
+
+func enabled(preferences *model2.NotificationPreferences, kind string) bool {
+	switch kind {
+	case model2.NotificationReply:
+		return preferences.Replies
+	case model2.NotificationMention:
+		return preferences.Mentions
+	case model2.NotificationComment:
+		return preferences.Comments
+	}
+
+	return false
+}
+
+func (ns *NotificationService) GetNotifications(ctx context.Context, args PageArgs, unreadOnly *bool) (*model.NotificationConnection, error) {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errs.ErrUnauthenticated
+	}
+
+	query, err := ns.pages.query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	unread := unreadOnly != nil && *unreadOnly
+	notifications, err := ns.n.GetNotificationsByUser(user.ID, unread, query, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := ns.n.CountNotificationsByUser(user.ID, unread, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications, info := page(notifications, query, notificationCursor)
+	connection := &model.NotificationConnection{
+		Edges:      make([]*model.NotificationEdge, len(notifications)),
+		PageInfo:   info,
+		TotalCount: int32(total),
+	}
+
+	for i, notification := range notifications {
+		connection.Edges[i] = &model.NotificationEdge{
+			Cursor: encodeCursor(notificationCursor(notification)),
+			Node:   utils.FromStorageNotification(notification),
+		}
+	}
+
+	return connection, nil
+}
+
+// MarkNotificationsRead returns the notifications of the caller among ids,
+// ids of other users' notifications are ignored.
+func (ns *NotificationService) MarkNotificationsRead(ctx context.Context, ids []string) ([]*model.Notification, error) {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errs.ErrUnauthenticated
+	}
+
+	notifications, err := ns.n.MarkNotificationsRead(user.ID, ids, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Notification, len(notifications))
+	for i, notification := range notifications {
+		result[i] = utils.FromStorageNotification(notification)
+	}
+
+	return result, nil
+}
+
+func (ns *NotificationService) GetPreferences(ctx context.Context) (*model.NotificationPreferences, error) {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errs.ErrUnauthenticated
+	}
+
+	preferences, err := ns.n.GetPreferences(user.ID, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.FromStoragePreferences(preferences), nil
+}
+
+// UpdatePreferences changes the toggles set in input and keeps the others.
+func (ns *NotificationService) UpdatePreferences(ctx context.Context, input model.NotificationPreferencesInput) (*model.NotificationPreferences, error) {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errs.ErrUnauthenticated
+	}
+
+	preferences, err := ns.n.GetPreferences(user.ID, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Replies != nil {
+		preferences.Replies = *input.Replies
+	}
+
+	if input.Comments != nil {
+		preferences.Comments = *input.Comments
+	}
+
+	if input.Mentions != nil {
+		preferences.Mentions = *input.Mentions
+	}
+
+	if err = ns.n.UpdatePreferences(preferences, ctx); err != nil {
+		return nil, err
+	}
+
+	return utils.FromStoragePreferences(preferences), nil
+}
+
+func notificationCursor(notification *model2.Notification) storage.Cursor {
+	return storage.Cursor{CreatedAt: notification.CreatedAt, ID: notification.ID}
+}
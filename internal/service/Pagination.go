@@ -0,0 +1,142 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+	"github.com/k0ch3gar/ozon-task/internal/storage"
+)
+
+// PageArgs are the Relay connection arguments. Either First or Last may be
+// set, when both are omitted the default page size is used.
+type PageArgs struct {
+	First  *int32
+	After  *string
+	Last   *int32
+	Before *string
+}
+
+type pagination struct {
+	pageSize    uint64
+	maxPageSize uint64
+}
+
+func newPagination(params config.ApplicationParameters) pagination {
+	return pagination{
+		pageSize:    params.PageSize,
+		maxPageSize: params.MaxPageSize,
+	}
+}
+
+// query converts the arguments into a storage query. One row more than
+// requested is selected to find out whether there is a next page.
+func (p pagination) query(args PageArgs) (storage.PageQuery, error) {
+	if args.First != nil && args.Last != nil {
+		return storage.PageQuery{}, errs.InvalidField("last", "must not be combined with first")
+	}
+
+	limit := p.pageSize
+	if args.First != nil {
+		size, err := p.size("first", *args.First)
+		if err != nil {
+			return storage.PageQuery{}, err
+		}
+
+		limit = size
+	}
+
+	if args.Last != nil {
+		size, err := p.size("last", *args.Last)
+		if err != nil {
+			return storage.PageQuery{}, err
+		}
+
+		limit = size
+	}
+
+	after, err := decodeCursor("after", args.After)
+	if err != nil {
+		return storage.PageQuery{}, err
+	}
+
+	before, err := decodeCursor("before", args.Before)
+	if err != nil {
+		return storage.PageQuery{}, err
+	}
+
+	return storage.PageQuery{
+		After:    after,
+		Before:   before,
+		Limit:    limit + 1,
+		Backward: args.Last != nil,
+	}, nil
+}
+
+func (p pagination) size(field string, size int32) (uint64, error) {
+	if size < 0 {
+		return 0, errs.InvalidField(field, "must not be negative")
+	}
+
+	if p.maxPageSize > 0 && uint64(size) > p.maxPageSize {
+		return 0, errs.InvalidField(field, fmt.Sprintf("must be at most %d", p.maxPageSize))
+	}
+
+	return uint64(size), nil
+}
+
+// page drops the extra row selected by query and describes the page. Only
+// the direction being paginated is checked, as the Relay spec allows.
+func page[T any](rows []*T, query storage.PageQuery, cursor func(*T) storage.Cursor) ([]*T, *model.PageInfo) {
+	info := &model.PageInfo{}
+	if uint64(len(rows)) == query.Limit {
+		if query.Backward {
+			rows = rows[1:]
+			info.HasPreviousPage = true
+		} else {
+			rows = rows[:len(rows)-1]
+			info.HasNextPage = true
+		}
+	}
+
+	if len(rows) > 0 {
+		start := encodeCursor(cursor(rows[0]))
+		end := encodeCursor(cursor(rows[len(rows)-1]))
+		info.StartCursor = &start
+		info.EndCursor = &end
+	}
+
+	return rows, info
+}
+
+type cursorPayload struct {
+	CreatedAt string `json:"c"`
+	ID        string `json:"i"`
+}
+
+// encodeCursor makes cursors opaque, clients must not rely on their content.
+func encodeCursor(cursor storage.Cursor) string {
+	payload, _ := json.Marshal(cursorPayload{CreatedAt: cursor.CreatedAt, ID: cursor.ID})
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func decodeCursor(field string, value *string) (*storage.Cursor, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(*value)
+	if err != nil {
+		return nil, errs.InvalidField(field, "invalid cursor")
+	}
+
+	var payload cursorPayload
+	if err = json.Unmarshal(raw, &payload); err != nil || payload.CreatedAt == "" || payload.ID == "" {
+		return nil, errs.InvalidField(field, "invalid cursor")
+	}
+
+	return &storage.Cursor{CreatedAt: payload.CreatedAt, ID: payload.ID}, nil
+}
@@ -3,44 +3,106 @@ package service
 import (
 	"context"
 	"errors"
-	"fmt"
+	"time"
 
+	"github.com/k0ch3gar/ozon-task/internal/auth"
 	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/dataloader"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
 	"github.com/k0ch3gar/ozon-task/internal/graph/model"
 	"github.com/k0ch3gar/ozon-task/internal/storage"
+	model2 "github.com/k0ch3gar/ozon-task/internal/storage/model"
 	"github.com/k0ch3gar/ozon-task/internal/utils"
+	"github.com/k0ch3gar/ozon-task/internal/validation"
 )
 
 type PostService struct {
-	p        storage.PostStorage
-	u        storage.UserStorage
-	pageSize uint64
+	p         storage.PostStorage
+	u         storage.UserStorage
+	l         storage.LifecycleStorage
+	events    *SubscriptionService
+	validator *validation.Validator
+	pages     pagination
+	// retention is how long deleted posts can be restored.
+	retention time.Duration
 }
 
-func NewPostService(params config.ApplicationParameters, p storage.PostStorage, u storage.UserStorage) *PostService {
+func NewPostService(
+	params config.ApplicationParameters,
+	p storage.PostStorage,
+	u storage.UserStorage,
+	l storage.LifecycleStorage,
+	events *SubscriptionService,
+	validator *validation.Validator,
+) *PostService {
 	return &PostService{
-		p:        p,
-		u:        u,
-		pageSize: params.PageSize,
+		p:         p,
+		u:         u,
+		l:         l,
+		events:    events,
+		validator: validator,
+		pages:     newPagination(params),
+		retention: params.RetentionPeriod,
 	}
 }
 
-func (ps *PostService) GetPostsByPage(page uint64, ctx context.Context) ([]*model.Post, error) {
-	posts, err := ps.p.GetFirstPostsFrom(page*ps.pageSize, ps.pageSize, ctx)
+func (ps *PostService) GetPosts(args PageArgs, ctx context.Context) (*model.PostConnection, error) {
+	query, err := ps.pages.query(args)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(posts) == 0 {
-		return nil, nil
+	posts, err := ps.p.GetPosts(query, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := ps.p.CountPosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPostConnection(posts, query, total), nil
+}
+
+func (ps *PostService) GetPostsByAuthor(authorId string, args PageArgs, ctx context.Context) (*model.PostConnection, error) {
+	query, err := ps.pages.query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := ps.p.GetPostsByAuthor(authorId, query, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := ps.p.CountPostsByAuthor(authorId, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPostConnection(posts, query, total), nil
+}
+
+// GetCommentedPost resolves the post a comment belongs to, a deleted post is
+// replaced with model.DeadPost.
+func (ps *PostService) GetCommentedPost(postId string, ctx context.Context) (*model.Post, error) {
+	post, err := ps.loadPost(ctx, postId)
+	if errors.Is(err, errs.ErrDeleted) || errors.Is(err, errs.ErrNotFound) {
+		return utils.NewDeadPost(postId), nil
+	} else if err != nil {
+		return nil, err
 	}
 
-	apiPosts := make([]*model.Post, len(posts))
-	for i := range apiPosts {
-		apiPosts[i] = utils.FromDbPost(posts[i])
+	return utils.FromDbPost(post), nil
+}
+
+func (ps *PostService) loadPost(ctx context.Context, postId string) (*model2.Post, error) {
+	if loaders, ok := dataloader.LoadersFromContext(ctx); ok {
+		return loaders.Posts.Load(ctx, postId)
 	}
 
-	return apiPosts, nil
+	return ps.p.GetPostById(postId, ctx)
 }
 
 func (ps *PostService) GetPostByid(postId string, ctx context.Context) (*model.Post, error) {
@@ -53,48 +115,77 @@ func (ps *PostService) GetPostByid(postId string, ctx context.Context) (*model.P
 }
 
 func (ps *PostService) CreatePost(postInput model.PostInput, ctx context.Context) (*model.Post, error) {
-	if ok, err := ps.u.ContainsById(postInput.AuthorID, ctx); err != nil {
+	author, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errs.ErrUnauthenticated
+	}
+
+	if err := ps.validator.PostInput(postInput); err != nil {
+		return nil, err
+	}
+
+	if ok, err := ps.u.ContainsById(author.ID, ctx); err != nil {
 		return nil, err
 	} else if !ok {
-		return nil, errors.New(fmt.Sprintf("author does not exists: %s", postInput.AuthorID))
+		return nil, errs.NotFound("author does not exist: %s", author.ID)
 	}
 
-	post := utils.FromPostInput(&postInput)
+	post := utils.FromPostInput(&postInput, author.ID)
 	err := ps.p.InsertPost(post, ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return utils.FromDbPost(post), err
+	created := utils.FromDbPost(post)
+	ps.events.PublishPost(ctx, model.PostEventKindCreated, created)
+	return created, nil
 }
 
 func (ps *PostService) UpdatePostTitle(ctx context.Context, postID string, title string) (*model.Post, error) {
+	if err := ps.validator.Title(title); err != nil {
+		return nil, err
+	}
+
 	post, err := ps.GetPostByid(postID, ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	if _, err = authorizeAuthorOrModerator(ctx, post.AuthorID); err != nil {
+		return nil, err
+	}
+
 	post.Title = title
 	err = ps.p.UpdatePost(utils.FromApiPost(post), ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	ps.events.PublishPost(ctx, model.PostEventKindUpdated, post)
 	return post, nil
 }
 
 func (ps *PostService) UpdatePostBody(ctx context.Context, postID string, body string) (*model.Post, error) {
+	if err := ps.validator.PostBody(body); err != nil {
+		return nil, err
+	}
+
 	post, err := ps.GetPostByid(postID, ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	if _, err = authorizeAuthorOrModerator(ctx, post.AuthorID); err != nil {
+		return nil, err
+	}
+
 	post.Body = body
 	err = ps.p.UpdatePost(utils.FromApiPost(post), ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	ps.events.PublishPost(ctx, model.PostEventKindUpdated, post)
 	return post, nil
 }
 
@@ -104,20 +195,86 @@ func (ps *PostService) UpdatePostCommentsAllowance(ctx context.Context, postID s
 		return nil, err
 	}
 
+	if _, err = authorizeAuthorOrModerator(ctx, post.AuthorID); err != nil {
+		return nil, err
+	}
+
 	post.AllowComments = allow
 	err = ps.p.UpdatePost(utils.FromApiPost(post), ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	ps.events.PublishPost(ctx, model.PostEventKindUpdated, post)
 	return post, nil
 }
 
 func (ps *PostService) DeletePost(ctx context.Context, postID string) (*string, error) {
-	err := ps.p.DeletePost(postID, ctx)
+	post, err := ps.p.GetPostById(postID, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := authorizeAuthorOrModerator(ctx, post.AuthorID)
+	if err != nil {
+		return nil, err
+	}
+
+	cascade, err := ps.l.DeletePost(postID, caller.ID, ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	ps.events.PublishCascade(ctx, cascade)
+	dead := utils.NewDeadPost(postID)
+	dead.CreatedAt = post.CreatedAt
+	ps.events.PublishPost(ctx, model.PostEventKindDeleted, dead)
 	return &postID, nil
 }
+
+// RestorePost brings back a post deleted within the retention period together
+// with the comments deleted along with it.
+func (ps *PostService) RestorePost(ctx context.Context, postID string) (*model.Post, error) {
+	posts, err := ps.p.GetPostsByIds([]string{postID}, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(posts) == 0 {
+		return nil, errs.NotFound("no such post with id: %s", postID)
+	}
+
+	if err = authorizeRestore(ctx, posts[0].AuthorID, posts[0].DeletedAt, posts[0].DeletedBy); err != nil {
+		return nil, err
+	}
+
+	post, err := ps.l.RestorePost(postID, time.Now().Add(-ps.retention), ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	restored := utils.FromDbPost(post)
+	ps.events.PublishPost(ctx, model.PostEventKindRestored, restored)
+	return restored, nil
+}
+
+func newPostConnection(posts []*model2.Post, query storage.PageQuery, total uint64) *model.PostConnection {
+	posts, pageInfo := page(posts, query, postCursor)
+	edges := make([]*model.PostEdge, len(posts))
+	for i := range edges {
+		edges[i] = &model.PostEdge{
+			Cursor: encodeCursor(postCursor(posts[i])),
+			Node:   utils.FromDbPost(posts[i]),
+		}
+	}
+
+	return &model.PostConnection{
+		Edges:      edges,
+		PageInfo:   pageInfo,
+		TotalCount: int32(total),
+	}
+}
+
+func postCursor(post *model2.Post) storage.Cursor {
+	return storage.Cursor{CreatedAt: post.CreatedAt, ID: post.ID}
+}
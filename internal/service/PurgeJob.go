@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/storage"
+	"go.uber.org/fx"
+)
+
+// purgeMetrics is published at /debug/vars under "purge".
+var purgeMetrics = expvar.NewMap("purge")
+
+// PurgeJob periodically removes content deleted longer than the retention
+// period ago, after that it can no longer be restored.
+type PurgeJob struct {
+	l         storage.LifecycleStorage
+	retention time.Duration
+	interval  time.Duration
+}
+
+func NewPurgeJob(lc fx.Lifecycle, params config.ApplicationParameters, l storage.LifecycleStorage) *PurgeJob {
+	job := &PurgeJob{
+		l:         l,
+		retention: params.RetentionPeriod,
+		interval:  params.PurgeInterval,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				job.Run(ctx)
+			}()
+
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			cancel()
+			wg.Wait()
+			return nil
+		},
+	})
+
+	return job
+}
+
+// Run purges once every interval until ctx is done.
+func (j *PurgeJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.Purge(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("purge failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge removes everything deleted before the retention period and records
+// how much was removed.
+func (j *PurgeJob) Purge(ctx context.Context) (storage.PurgeResult, error) {
+	purgeMetrics.Add("runs", 1)
+
+	result, err := j.l.Purge(time.Now().Add(-j.retention), ctx)
+	if err != nil {
+		purgeMetrics.Add("failures", 1)
+		return storage.PurgeResult{}, err
+	}
+
+	purgeMetrics.Add("users", int64(result.Users))
+	purgeMetrics.Add("posts", int64(result.Posts))
+	purgeMetrics.Add("comments", int64(result.Comments))
+	return result, nil
+}
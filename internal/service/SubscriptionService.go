@@ -1,56 +1,325 @@
 package service
 
 import (
+	"context"
+	"expvar"
+	"log"
+	"slices"
+	"strconv"
 	"sync"
 
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
 	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+	"github.com/k0ch3gar/ozon-task/internal/storage"
+	"github.com/k0ch3gar/ozon-task/internal/utils"
 )
 
+// subscriptionMetrics is published at /debug/vars under "subscriptions".
+var subscriptionMetrics = expvar.NewMap("subscriptions")
+
+// SubscriptionService fans events out to the subscribers of their topics.
+// Events go through the bus, so they reach the subscribers of every instance.
+// Delivery never blocks: every subscriber has a bounded buffer and the
+// overflow policy decides what happens once it is full.
 type SubscriptionService struct {
-	Subs map[string][]chan *model.Comment
-	mu   sync.Mutex
+	bus        EventBus
+	mu         sync.RWMutex
+	subs       map[string]map[*Subscriber]struct{}
+	bufferSize int
+	overflow   config.OverflowPolicy
+	hooks      []func(ctx context.Context, event *Event)
 }
 
-func NewSubscriptionService() *SubscriptionService {
-	return &SubscriptionService{
-		Subs: make(map[string][]chan *model.Comment),
-		mu:   sync.Mutex{},
+func NewSubscriptionService(params config.ApplicationParameters, bus EventBus) *SubscriptionService {
+	bufferSize := params.SubscriberBufferSize
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+
+	ss := &SubscriptionService{
+		bus:        bus,
+		subs:       make(map[string]map[*Subscriber]struct{}),
+		bufferSize: bufferSize,
+		overflow:   params.SubscriberOverflow,
 	}
+
+	bus.Receive(func(message *BusMessage) {
+		ss.dispatch(withID(message), message.Topics)
+	})
+
+	return ss
+}
+
+// Event is a change of a post or of one of its comments, or a notification
+// of a user. Exactly one of Comment, Post and Notification is set. The ID is
+// given by the bus.
+type Event struct {
+	ID           uint64              `json:"-"`
+	Comment      *model.CommentEvent `json:"comment,omitempty"`
+	Post         *model.PostEvent    `json:"post,omitempty"`
+	Notification *model.Notification `json:"notification,omitempty"`
+}
+
+// PostTopic carries the changes of a post and of its comments.
+func PostTopic(postId string) string {
+	return "post:" + postId
 }
 
-func (ss *SubscriptionService) Unsubscribe(postId string, ch chan *model.Comment) {
+// RepliesTopic carries the replies to a comment.
+func RepliesTopic(commentId string) string {
+	return "replies:" + commentId
+}
+
+// UserTopic carries the comments on the posts of a user and the replies to
+// their comments.
+func UserTopic(userId string) string {
+	return "user:" + userId
+}
+
+// NotificationsTopic carries the notifications of a user.
+func NotificationsTopic(userId string) string {
+	return "notifications:" + userId
+}
+
+// Subscriber receives the events published to a single topic that it
+// accepts.
+type Subscriber struct {
+	topic  string
+	accept func(*Event) bool
+	// mu guards sending to and closing events, so a publisher never sends on
+	// a closed channel.
+	mu     sync.Mutex
+	events chan *Event
+	closed bool
+	err    error
+}
+
+// Events is closed once the subscriber is unsubscribed or disconnected.
+func (s *Subscriber) Events() <-chan *Event {
+	return s.events
+}
+
+// Err tells why the broker closed the subscriber, it is nil after a regular
+// unsubscribe.
+func (s *Subscriber) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Subscribe registers a subscriber for the events of the topic accepted by
+// accept, a nil accept takes them all. Other events never reach its buffer,
+// so they neither evict the wanted ones nor count as overflow.
+func (ss *SubscriptionService) Subscribe(topic string, accept func(*Event) bool) *Subscriber {
+	sub := &Subscriber{
+		topic:  topic,
+		accept: accept,
+		events: make(chan *Event, ss.bufferSize),
+	}
+
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
 
-	var newSubs []chan *model.Comment
-	for i := range ss.Subs[postId] {
-		if ss.Subs[postId][i] == ch {
-			continue
-		}
+	if ss.subs[topic] == nil {
+		ss.subs[topic] = make(map[*Subscriber]struct{})
+	}
+
+	ss.subs[topic][sub] = struct{}{}
+	subscriptionMetrics.Add("subscribers", 1)
+	return sub
+}
+
+func (ss *SubscriptionService) Unsubscribe(sub *Subscriber) {
+	ss.remove(sub)
+	sub.close(nil)
+}
+
+// PublishComment sends the event to the topic of the post and to the extra
+// topics given.
+func (ss *SubscriptionService) PublishComment(ctx context.Context, kind model.CommentEventKind, comment *model.Comment, topics ...string) {
+	event := &Event{Comment: &model.CommentEvent{Kind: kind, Comment: comment}}
+	ss.publish(ctx, event, append([]string{PostTopic(comment.ParentPostID)}, topics...))
+}
+
+func (ss *SubscriptionService) PublishPost(ctx context.Context, kind model.PostEventKind, post *model.Post) {
+	ss.publish(ctx, &Event{Post: &model.PostEvent{Kind: kind, Post: post}}, []string{PostTopic(post.ID)})
+}
 
-		newSubs = append(newSubs, ss.Subs[postId][i])
+func (ss *SubscriptionService) PublishNotification(ctx context.Context, userId string, notification *model.Notification) {
+	ss.publish(ctx, &Event{Notification: notification}, []string{NotificationsTopic(userId)})
+}
+
+// PublishCascade announces the deletion of the content removed together with
+// its owner, the comments go first as they do in the storage.
+func (ss *SubscriptionService) PublishCascade(ctx context.Context, cascade *storage.Cascade) {
+	for _, comment := range cascade.Comments {
+		ss.PublishComment(ctx, model.CommentEventKindDeleted, utils.FromStorageComment(comment))
 	}
 
-	ss.Subs[postId] = newSubs
+	for _, post := range cascade.Posts {
+		ss.PublishPost(ctx, model.PostEventKindDeleted, utils.FromDbPost(post))
+	}
 }
 
-func (ss *SubscriptionService) Subscribe(postId string, ch chan *model.Comment) {
+// OnPublish registers a hook called with every event published by this
+// instance, before it goes to the bus. Events of other instances are not
+// passed to it.
+func (ss *SubscriptionService) OnPublish(hook func(ctx context.Context, event *Event)) {
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
 
-	ss.Subs[postId] = append(ss.Subs[postId], ch)
+	ss.hooks = append(ss.hooks, hook)
 }
 
-func (ss *SubscriptionService) PubComment(postId string, comment *model.Comment) {
+// publish reports bus failures only in the log, the change it announces is
+// already made. The event is published even if the request is canceled by then.
+func (ss *SubscriptionService) publish(ctx context.Context, event *Event, topics []string) {
+	topics = slices.Compact(slices.Sorted(slices.Values(topics)))
+	subscriptionMetrics.Add("published", 1)
+
+	ss.mu.RLock()
+	hooks := ss.hooks
+	ss.mu.RUnlock()
+
+	for _, hook := range hooks {
+		hook(ctx, event)
+	}
+
+	if err := ss.bus.Publish(&BusMessage{Topics: topics, Event: event}, context.WithoutCancel(ctx)); err != nil {
+		subscriptionMetrics.Add("bus_failures", 1)
+		log.Printf("unable to publish event: %v", err)
+	}
+}
+
+// dispatch delivers an event coming from the bus to the local subscribers.
+func (ss *SubscriptionService) dispatch(event *Event, topics []string) {
+	ss.mu.RLock()
+	var subs []*Subscriber
+	for _, topic := range topics {
+		for sub := range ss.subs[topic] {
+			if sub.accept == nil || sub.accept(event) {
+				subs = append(subs, sub)
+			}
+		}
+	}
+	ss.mu.RUnlock()
+
+	for _, sub := range subs {
+		if !ss.deliver(sub, event) {
+			ss.remove(sub)
+			subscriptionMetrics.Add("disconnected", 1)
+		}
+	}
+}
+
+// Replay returns the events of the topic published after the one with the
+// since ID, oldest first.
+func (ss *SubscriptionService) Replay(ctx context.Context, topic string, since uint64) ([]*Event, error) {
+	messages, err := ss.bus.Replay(topic, since, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*Event, 0, len(messages))
+	for _, message := range messages {
+		events = append(events, withID(message))
+	}
+
+	return events, nil
+}
+
+// withID hands the ID of the message to its event and to the API model.
+// Events are shared by subscribers once delivered, so they are only written
+// the first time.
+func withID(message *BusMessage) *Event {
+	event := message.Event
+	if event.ID == message.ID {
+		return event
+	}
+
+	event.ID = message.ID
+
+	id := strconv.FormatUint(message.ID, 10)
+	if event.Comment != nil {
+		event.Comment.ID = id
+	}
+
+	if event.Post != nil {
+		event.Post.ID = id
+	}
+
+	return event
+}
+
+// deliver never blocks, it reports false once the subscriber has to be
+// disconnected.
+func (ss *SubscriptionService) deliver(sub *Subscriber, event *Event) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.closed {
+		return true
+	}
+
+	for {
+		select {
+		case sub.events <- event:
+			return true
+		default:
+		}
+
+		if ss.overflow == config.Disconnect {
+			subscriptionMetrics.Add("dropped", 1)
+			sub.closeLocked(errs.ErrSlowConsumer)
+			return false
+		}
+
+		// The reader may have drained the buffer in the meantime, then
+		// there is nothing to drop and the send is retried.
+		select {
+		case <-sub.events:
+			subscriptionMetrics.Add("dropped", 1)
+		default:
+		}
+	}
+}
+
+func (ss *SubscriptionService) remove(sub *Subscriber) {
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
 
-	chs, ok := ss.Subs[postId]
+	subs, ok := ss.subs[sub.topic]
 	if !ok {
 		return
 	}
 
-	for _, ch := range chs {
-		ch <- comment
+	if _, ok = subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(ss.subs, sub.topic)
 	}
+
+	subscriptionMetrics.Add("subscribers", -1)
+}
+
+func (s *Subscriber) close(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closeLocked(err)
+}
+
+func (s *Subscriber) closeLocked(err error) {
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	s.err = err
+	close(s.events)
 }
@@ -2,19 +2,51 @@ package service
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"time"
 
+	"github.com/k0ch3gar/ozon-task/internal/auth"
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/dataloader"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
 	"github.com/k0ch3gar/ozon-task/internal/graph/model"
 	"github.com/k0ch3gar/ozon-task/internal/storage"
+	model2 "github.com/k0ch3gar/ozon-task/internal/storage/model"
 	"github.com/k0ch3gar/ozon-task/internal/utils"
+	"github.com/k0ch3gar/ozon-task/internal/validation"
 )
 
 type UserService struct {
-	us storage.UserStorage
+	us            storage.UserStorage
+	l             storage.LifecycleStorage
+	ss            storage.SessionStorage
+	hasher        auth.PasswordHasher
+	validator     *validation.Validator
+	events        *SubscriptionService
+	contentPolicy config.UserContentPolicy
+	// retention is how long deleted users can be restored.
+	retention time.Duration
 }
 
-func NewUserService(us storage.UserStorage) *UserService {
+func NewUserService(
+	params config.ApplicationParameters,
+	us storage.UserStorage,
+	l storage.LifecycleStorage,
+	ss storage.SessionStorage,
+	hasher auth.PasswordHasher,
+	validator *validation.Validator,
+	events *SubscriptionService,
+) *UserService {
 	return &UserService{
-		us: us,
+		us:            us,
+		l:             l,
+		ss:            ss,
+		hasher:        hasher,
+		validator:     validator,
+		events:        events,
+		contentPolicy: params.UserContentPolicy,
+		retention:     params.RetentionPeriod,
 	}
 }
 
@@ -27,9 +59,46 @@ func (us *UserService) GetUserById(ctx context.Context, userId string) (*model.U
 	return utils.FromStorageUser(user), err
 }
 
+// GetAuthor resolves the author of a post or comment. Authors that are gone
+// are replaced with model.DeadUser so the content itself stays readable.
+func (us *UserService) GetAuthor(ctx context.Context, authorId *string) (*model.User, error) {
+	if authorId == nil {
+		return nil, nil
+	}
+
+	user, err := us.loadUser(ctx, *authorId)
+	if errors.Is(err, errs.ErrDeleted) || errors.Is(err, errs.ErrNotFound) {
+		return utils.NewDeadUser(*authorId), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return utils.FromStorageUser(user), nil
+}
+
+// loadUser batches lookups made during the same request when dataloaders are
+// installed, subscriptions and tests go straight to the storage.
+func (us *UserService) loadUser(ctx context.Context, userId string) (*model2.User, error) {
+	if loaders, ok := dataloader.LoadersFromContext(ctx); ok {
+		return loaders.Users.Load(ctx, userId)
+	}
+
+	return us.us.GetUserById(userId, ctx)
+}
+
 func (us *UserService) CreateUser(ctx context.Context, userInput model.UserInput) (*model.User, error) {
+	if err := us.validator.UserInput(userInput); err != nil {
+		return nil, err
+	}
+
 	user := utils.FromUserInput(&userInput)
-	err := us.us.InsertUser(user, ctx)
+	hash, err := us.hasher.Hash(userInput.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Password = hash
+	err = us.us.InsertUser(user, ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -37,6 +106,19 @@ func (us *UserService) CreateUser(ctx context.Context, userInput model.UserInput
 	return utils.FromStorageUser(user), err
 }
 
+func (us *UserService) Me(ctx context.Context) (*model.User, error) {
+	if _, ok := auth.UserFromContext(ctx); !ok {
+		return nil, nil
+	}
+
+	user, err := us.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.FromStorageUser(user), nil
+}
+
 func (us *UserService) GetUserByName(ctx context.Context, username string) (*model.User, error) {
 	user, err := us.us.GetUserByName(username, ctx)
 	if err != nil {
@@ -46,11 +128,214 @@ func (us *UserService) GetUserByName(ctx context.Context, username string) (*mod
 	return utils.FromStorageUser(user), err
 }
 
+func (us *UserService) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	user, err := us.us.GetUserByEmail(email, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.FromStorageUser(user), err
+}
+
 func (us *UserService) DeleteUser(ctx context.Context, userId string) (*model.User, error) {
-	user, err := us.us.DeleteUser(userId, ctx)
+	caller, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errs.ErrUnauthenticated
+	}
+
+	if caller.ID != userId && !auth.HasRole(caller, model2.RoleAdmin) {
+		return nil, errs.Forbidden("only the user or an admin can delete an account")
+	}
+
+	user, cascade, err := us.l.DeleteUser(userId, caller.ID, us.contentPolicy, ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	us.events.PublishCascade(ctx, cascade)
+
 	return utils.FromStorageUser(user), err
 }
+
+// RestoreUser brings back a user deleted within the retention period. Content
+// removed with the account comes back too, anonymized content does not get its
+// author back.
+func (us *UserService) RestoreUser(ctx context.Context, userId string) (*model.User, error) {
+	user, err := us.l.RestoreUser(userId, time.Now().Add(-us.retention), ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.FromStorageUser(user), nil
+}
+
+// GrantRole replaces the role of the user, admins cannot demote themselves
+// this way either.
+func (us *UserService) GrantRole(ctx context.Context, userId string, role string) (*model.User, error) {
+	if err := guardSelfDemotion(ctx, userId, role); err != nil {
+		return nil, err
+	}
+
+	user, err := us.us.GetUserById(userId, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Role = role
+	if err = us.us.UpdateUser(user, ctx); err != nil {
+		return nil, err
+	}
+
+	return utils.FromStorageUser(user), nil
+}
+
+// RevokeRole demotes the user back to a regular one if they hold role.
+func (us *UserService) RevokeRole(ctx context.Context, userId string, role string) (*model.User, error) {
+	if role == model2.RoleUser {
+		return nil, errs.InvalidField("role", "user role cannot be revoked")
+	}
+
+	if role == model2.RoleAdmin {
+		if err := guardSelfDemotion(ctx, userId, model2.RoleUser); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := us.us.GetUserById(userId, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Role != role {
+		return utils.FromStorageUser(user), nil
+	}
+
+	user.Role = model2.RoleUser
+	if err = us.us.UpdateUser(user, ctx); err != nil {
+		return nil, err
+	}
+
+	return utils.FromStorageUser(user), nil
+}
+
+// guardSelfDemotion keeps the calling admin from giving themselves a lower
+// role, so the last admin cannot lock everyone out.
+func guardSelfDemotion(ctx context.Context, userId string, role string) error {
+	caller, ok := auth.UserFromContext(ctx)
+	if ok && caller.ID == userId && auth.HasRole(caller, model2.RoleAdmin) && role != model2.RoleAdmin {
+		return errs.Forbidden("admins cannot revoke their own admin role")
+	}
+
+	return nil
+}
+
+func (us *UserService) UpdateUsername(ctx context.Context, username string) (*model.User, error) {
+	if err := us.validator.Username(username); err != nil {
+		return nil, err
+	}
+
+	user, err := us.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Username == username {
+		return utils.FromStorageUser(user), nil
+	}
+
+	// A change of case keeps the same key, so it cannot collide with anyone else.
+	if !strings.EqualFold(user.Username, username) {
+		if ok, err := us.us.ContainsByUsername(username, ctx); err != nil {
+			return nil, err
+		} else if ok {
+			return nil, errs.Conflict("username is already taken: %s", username)
+		}
+	}
+
+	updated := *user
+	updated.Username = username
+	if err = us.us.UpdateUser(&updated, ctx); err != nil {
+		return nil, err
+	}
+
+	return utils.FromStorageUser(&updated), nil
+}
+
+func (us *UserService) UpdateEmail(ctx context.Context, email string) (*model.User, error) {
+	if err := us.validator.Email(email); err != nil {
+		return nil, err
+	}
+
+	user, err := us.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Email == email {
+		return utils.FromStorageUser(user), nil
+	}
+
+	// A change of case keeps the same key, so it cannot collide with anyone else.
+	if !strings.EqualFold(user.Email, email) {
+		if ok, err := us.us.ContainsByEmail(email, ctx); err != nil {
+			return nil, err
+		} else if ok {
+			return nil, errs.Conflict("email is already taken: %s", email)
+		}
+	}
+
+	updated := *user
+	updated.Email = email
+	if err = us.us.UpdateUser(&updated, ctx); err != nil {
+		return nil, err
+	}
+
+	return utils.FromStorageUser(&updated), nil
+}
+
+func (us *UserService) ChangePassword(ctx context.Context, oldPassword string, newPassword string) (bool, error) {
+	if err := us.validator.Password("newPassword", newPassword); err != nil {
+		return false, err
+	}
+
+	user, err := us.currentUser(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := us.hasher.Verify(oldPassword, user.Password)
+	if err != nil || !ok {
+		return false, errs.InvalidField("oldPassword", "wrong password")
+	}
+
+	hash, err := us.hasher.Hash(newPassword)
+	if err != nil {
+		return false, err
+	}
+
+	updated := *user
+	updated.Password = hash
+	if err = us.us.UpdateUser(&updated, ctx); err != nil {
+		return false, err
+	}
+
+	// Whoever holds the old password may have logged in elsewhere, only the
+	// session changing it stays signed in.
+	principal, _ := auth.PrincipalFromContext(ctx)
+	if err = us.ss.RevokeUserSessions(user.ID, principal.SessionID, ctx); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// currentUser loads the caller from storage, so changes are applied to the
+// stored state rather than to the snapshot taken when the request started.
+func (us *UserService) currentUser(ctx context.Context) (*model2.User, error) {
+	caller, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errs.ErrUnauthenticated
+	}
+
+	return us.us.GetUserById(caller.ID, ctx)
+}
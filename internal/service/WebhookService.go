@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+	"github.com/k0ch3gar/ozon-task/internal/storage"
+	model2 "github.com/k0ch3gar/ozon-task/internal/storage/model"
+	"github.com/k0ch3gar/ozon-task/internal/utils"
+	"github.com/k0ch3gar/ozon-task/internal/validation"
+)
+
+// WebhookService registers webhooks and queues a delivery for every post and
+// comment event they are subscribed to. The deliveries are sent by the
+// WebhookWorker.
+type WebhookService struct {
+	w         storage.WebhookStorage
+	validator *validation.Validator
+	pages     pagination
+}
+
+func NewWebhookService(
+	params config.ApplicationParameters,
+	w storage.WebhookStorage,
+	events *SubscriptionService,
+	validator *validation.Validator,
+) *WebhookService {
+	ws := &WebhookService{
+		w:         w,
+		validator: validator,
+		pages:     newPagination(params),
+	}
+
+	events.OnPublish(ws.enqueue)
+	return ws
+}
+
+// WebhookPayload is the JSON body posted to webhooks. Exactly one of Post
+// and Comment is set.
+type WebhookPayload struct {
+	Event      string         `json:"event"`
+	OccurredAt string         `json:"occurredAt"`
+	Post       *model.Post    `json:"post,omitempty"`
+	Comment    *model.Comment `json:"comment,omitempty"`
+}
+
+// enqueue only logs failures, like the publishing of the event itself. It
+// runs for every published event, so only the webhooks subscribed to it are
+// loaded. The deliveries are queued even if the request is canceled by then.
+func (ws *WebhookService) enqueue(ctx context.Context, event *Event) {
+	payload := WebhookPayload{OccurredAt: time.Now().Format(time.RFC3339Nano)}
+	switch {
+	case event.Comment != nil:
+		payload.Event = "comment_" + strings.ToLower(string(event.Comment.Kind))
+		payload.Comment = event.Comment.Comment
+	case event.Post != nil:
+		payload.Event = "post_" + strings.ToLower(string(event.Post.Kind))
+		payload.Post = event.Post.Post
+	default:
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	webhooks, err := ws.w.GetWebhooksByEvent(payload.Event, ctx)
+	if err != nil {
+		log.Printf("unable to queue webhook deliveries: %v", err)
+		return
+	}
+
+	if len(webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("unable to queue webhook deliveries: %v", err)
+		return
+	}
+
+	deliveries := make([]*model2.WebhookDelivery, len(webhooks))
+	for i, webhook := range webhooks {
+		deliveries[i] = &model2.WebhookDelivery{
+			WebhookID: webhook.ID,
+			Event:     payload.Event,
+			Payload:   string(body),
+		}
+	}
+
+	if err = ws.w.InsertDeliveries(deliveries, ctx); err != nil {
+		log.Printf("unable to queue webhook deliveries: %v", err)
+	}
+}
+
+func (ws *WebhookService) CreateWebhook(ctx context.Context, input model.WebhookInput) (*model.Webhook, error) {
+	if err := ws.validator.WebhookInput(input); err != nil {
+		return nil, err
+	}
+
+	webhook := utils.FromWebhookInput(input)
+	webhook.Events = slices.Compact(slices.Sorted(slices.Values(webhook.Events)))
+	if err := ws.w.InsertWebhook(webhook, ctx); err != nil {
+		return nil, err
+	}
+
+	return utils.FromStorageWebhook(webhook), nil
+}
+
+func (ws *WebhookService) GetWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	webhooks, err := ws.w.GetWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Webhook, len(webhooks))
+	for i, webhook := range webhooks {
+		result[i] = utils.FromStorageWebhook(webhook)
+	}
+
+	return result, nil
+}
+
+// DeleteWebhook drops the webhook with its delivery log, pending deliveries
+// are not sent anymore.
+func (ws *WebhookService) DeleteWebhook(ctx context.Context, webhookId string) (*string, error) {
+	if err := ws.w.DeleteWebhook(webhookId, ctx); err != nil {
+		return nil, err
+	}
+
+	return &webhookId, nil
+}
+
+// GetDeliveries returns the delivery log, the DEAD status gives the dead
+// letter list.
+func (ws *WebhookService) GetDeliveries(ctx context.Context, webhookId *string, status *model.WebhookDeliveryStatus, args PageArgs) (*model.WebhookDeliveryConnection, error) {
+	query, err := ws.pages.query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := storage.DeliveryFilter{WebhookID: webhookId}
+	if status != nil {
+		s := strings.ToLower(string(*status))
+		filter.Status = &s
+	}
+
+	deliveries, err := ws.w.GetDeliveries(filter, query, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := ws.w.CountDeliveries(filter, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, info := page(deliveries, query, deliveryCursor)
+	connection := &model.WebhookDeliveryConnection{
+		Edges:      make([]*model.WebhookDeliveryEdge, len(deliveries)),
+		PageInfo:   info,
+		TotalCount: int32(total),
+	}
+
+	for i, delivery := range deliveries {
+		connection.Edges[i] = &model.WebhookDeliveryEdge{
+			Cursor: encodeCursor(deliveryCursor(delivery)),
+			Node:   utils.FromStorageDelivery(delivery),
+		}
+	}
+
+	return connection, nil
+}
+
+// RetryDelivery queues a dead delivery again with all its attempts, it is how
+// dead letters are sent once the receiver is fixed.
+func (ws *WebhookService) RetryDelivery(ctx context.Context, deliveryId string) (*model.WebhookDelivery, error) {
+	delivery, err := ws.w.RetryDelivery(deliveryId, time.Now(), ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.FromStorageDelivery(delivery), nil
+}
+
+func deliveryCursor(delivery *model2.WebhookDelivery) storage.Cursor {
+	return storage.Cursor{CreatedAt: delivery.CreatedAt, ID: delivery.ID}
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/storage"
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+	"go.uber.org/fx"
+)
+
+// webhookMetrics is published at /debug/vars under "webhooks".
+var webhookMetrics = expvar.NewMap("webhooks")
+
+const (
+	// deliveryBatch is the number of deliveries claimed at once.
+	deliveryBatch = 32
+	// minDeliveryLease is the shortest lease, it also applies when requests
+	// have no timeout.
+	minDeliveryLease = 10 * time.Minute
+)
+
+// Headers sent with every delivery. The signature is the hex HMAC-SHA256 of
+// the body keyed with the secret of the webhook, prefixed with "sha256=".
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookSignatureHeader = "X-Webhook-Signature-256"
+)
+
+// WebhookWorker posts the queued deliveries to their webhooks. A failed
+// delivery is retried with exponential backoff and becomes dead after the
+// last attempt. Deliveries are claimed from the storage, so several instances
+// may run the worker against one database.
+type WebhookWorker struct {
+	w           storage.WebhookStorage
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	interval    time.Duration
+	// lease postpones claimed deliveries, it outlasts sending a whole batch
+	// so a delivery is only sent again once its instance has given up on it.
+	lease time.Duration
+}
+
+func NewWebhookWorker(lc fx.Lifecycle, params config.ApplicationParameters, w storage.WebhookStorage) *WebhookWorker {
+	worker := &WebhookWorker{
+		w:           w,
+		client:      &http.Client{Timeout: params.WebhookTimeout},
+		maxAttempts: params.WebhookMaxAttempts,
+		backoff:     params.WebhookBackoff,
+		maxBackoff:  params.WebhookMaxBackoff,
+		interval:    params.WebhookPollInterval,
+		lease:       max(minDeliveryLease, 2*params.WebhookTimeout*deliveryBatch),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				worker.Run(ctx)
+			}()
+
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			cancel()
+			wg.Wait()
+			return nil
+		},
+	})
+
+	return worker
+}
+
+// Run sends the due deliveries once every interval until ctx is done.
+func (ww *WebhookWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(ww.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := ww.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("webhook delivery failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue sends every delivery due by now and returns how many were
+// attempted.
+func (ww *WebhookWorker) DeliverDue(ctx context.Context) (int, error) {
+	attempted := 0
+	for {
+		deliveries, err := ww.w.ClaimDeliveries(time.Now(), ww.lease, deliveryBatch, ctx)
+		if err != nil {
+			return attempted, err
+		}
+
+		for _, delivery := range deliveries {
+			// A delivery whose outcome is not stored is sent again once its
+			// lease runs out, the rest of the batch goes on.
+			if err = ww.deliver(ctx, delivery); ctx.Err() != nil {
+				return attempted, ctx.Err()
+			} else if err != nil {
+				log.Printf("webhook delivery %s failed: %v", delivery.ID, err)
+			}
+
+			attempted++
+		}
+
+		if len(deliveries) < deliveryBatch {
+			return attempted, nil
+		}
+	}
+}
+
+// deliver records the outcome of a single attempt. Only storage errors are
+// returned, a failed request is just another attempt.
+func (ww *WebhookWorker) deliver(ctx context.Context, delivery *model.WebhookDelivery) error {
+	webhook, err := ww.w.GetWebhookById(delivery.WebhookID, ctx)
+	if errors.Is(err, errs.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	statusCode, err := ww.send(ctx, webhook, delivery)
+	if ctx.Err() != nil {
+		// Shutting down, the lease runs out and the delivery is picked up
+		// again.
+		return ctx.Err()
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	if statusCode != 0 {
+		delivery.LastStatusCode = &statusCode
+	}
+
+	if err == nil {
+		deliveredAt := now.Format(time.RFC3339Nano)
+		delivery.Status = model.DeliveryDelivered
+		delivery.DeliveredAt = &deliveredAt
+		delivery.LastError = nil
+		webhookMetrics.Add("delivered", 1)
+		return ww.w.UpdateDelivery(delivery, ctx)
+	}
+
+	lastError := err.Error()
+	delivery.LastError = &lastError
+	webhookMetrics.Add("failed", 1)
+	if delivery.Attempts >= ww.maxAttempts {
+		delivery.Status = model.DeliveryDead
+		webhookMetrics.Add("dead", 1)
+	} else {
+		delivery.NextAttemptAt = now.Add(ww.retryDelay(delivery.Attempts)).Format(time.RFC3339Nano)
+	}
+
+	return ww.w.UpdateDelivery(delivery, ctx)
+}
+
+// send reports the status code of the response, if any, and an error unless
+// it is a 2xx.
+func (ww *WebhookWorker) send(ctx context.Context, webhook *model.Webhook, delivery *model.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, delivery.Event)
+	request.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	request.Header.Set(WebhookSignatureHeader, "sha256="+sign(webhook.Secret, body))
+
+	response, err := ww.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	// Draining the body lets the connection be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("unexpected response status: %s", response.Status)
+	}
+
+	return response.StatusCode, nil
+}
+
+// retryDelay doubles the backoff with every failed attempt up to the max.
+func (ww *WebhookWorker) retryDelay(attempts int) time.Duration {
+	delay := ww.backoff
+	for i := 1; i < attempts && delay < ww.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, ww.maxBackoff)
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
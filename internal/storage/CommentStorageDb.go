@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/go-pg/pg/v10"
@@ -32,14 +33,10 @@ func (c *CommentStorageDb) GetCommentById(commentId string, ctx context.Context)
 		return nil, err
 	}
 
-	if comment == nil {
-		return nil, errors.New("no such comment")
-	}
-
 	return comment, nil
 }
 
-func (c *CommentStorageDb) GetFirstCommentsByPost(postId string, offset, count uint64, ctx context.Context) ([]*model.Comment, error) {
+func (c *CommentStorageDb) GetCommentsByPost(postId string, page PageQuery, ctx context.Context) ([]*model.Comment, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -49,15 +46,65 @@ func (c *CommentStorageDb) GetFirstCommentsByPost(postId string, offset, count u
 		return nil, err
 	}
 
-	err = query.Where("parent_comment_id = id").Where("parent_post_id = ?", postId).Where("deleted_at is null").Order("created_at").Limit(int(count)).Offset(int(offset)).Select()
+	if err = selectPage(c.postCommentsQuery(query, postId), &comments, page); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+func (c *CommentStorageDb) CountCommentsByPost(postId string, ctx context.Context) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	query, err := buildQuery(c.db, (*model.Comment)(nil), ctx)
 	if err != nil {
+		return 0, err
+	}
+
+	count, err := c.postCommentsQuery(query, postId).Count()
+	if err != nil {
+		return 0, mapDbError(err, (*model.Comment)(nil))
+	}
+
+	return uint64(count), nil
+}
+
+func (c *CommentStorageDb) GetCommentsByComment(commentId string, page PageQuery, ctx context.Context) ([]*model.Comment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var comments []*model.Comment
+	query, err := buildQuery(c.db, &comments, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = selectPage(c.childCommentsQuery(query, commentId), &comments, page); err != nil {
 		return nil, err
 	}
 
 	return comments, nil
 }
 
-func (c *CommentStorageDb) GetFirstCommentsByComment(commentId string, offset, count uint64, ctx context.Context) ([]*model.Comment, error) {
+func (c *CommentStorageDb) CountCommentsByComment(commentId string, ctx context.Context) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	query, err := buildQuery(c.db, (*model.Comment)(nil), ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := c.childCommentsQuery(query, commentId).Count()
+	if err != nil {
+		return 0, mapDbError(err, (*model.Comment)(nil))
+	}
+
+	return uint64(count), nil
+}
+
+func (c *CommentStorageDb) GetCommentsByAuthor(authorId string, page PageQuery, ctx context.Context) ([]*model.Comment, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -67,8 +114,112 @@ func (c *CommentStorageDb) GetFirstCommentsByComment(commentId string, offset, c
 		return nil, err
 	}
 
-	err = query.Where("parent_comment_id != id").Where("parent_comment_id = ?", commentId).Where("deleted_at is null").Order("created_at").Limit(int(count)).Offset(int(offset)).Select()
+	if err = selectPage(query.Where("author_id = ?", authorId).Where("deleted_at is null"), &comments, page); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+func (c *CommentStorageDb) CountCommentsByAuthor(authorId string, ctx context.Context) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	query, err := buildQuery(c.db, (*model.Comment)(nil), ctx)
 	if err != nil {
+		return 0, err
+	}
+
+	count, err := query.Where("author_id = ?", authorId).Where("deleted_at is null").Count()
+	if err != nil {
+		return 0, mapDbError(err, (*model.Comment)(nil))
+	}
+
+	return uint64(count), nil
+}
+
+// visibleComment keeps deleted comments as tombstones while something below
+// them is alive, fully deleted branches are pruned. The descendants are the
+// paths between "<path>." and "<path>/", a range scan of the partial
+// comments_alive_path_idx.
+func visibleComment(alias string) string {
+	return fmt.Sprintf(`(%[1]s.deleted_at IS NULL OR EXISTS (
+		SELECT 1 FROM comments d
+		WHERE d.root_id = %[1]s.root_id
+			AND d.path > %[1]s.path || '.' AND d.path < %[1]s.path || '/'
+			AND d.deleted_at IS NULL
+	))`, alias)
+}
+
+// commentThreadQuery walks the tree with a recursive CTE. The LATERAL join
+// limits replies per parent, and only comments within MaxChildren recurse, so
+// the extra row selected to detect more replies does not drag its subtree in.
+var commentThreadQuery = `
+WITH RECURSIVE thread AS (
+	(
+		SELECT c.*, row_number() OVER (ORDER BY c.created_at, c.id) AS rank
+		FROM comments c
+		WHERE c.parent_post_id = ?0 AND c.parent_comment_id IS NULL AND ` + visibleComment("c") + `
+			AND (?1::timestamptz IS NULL OR (c.created_at, c.id) > (?1::timestamptz, ?2::bigint))
+		ORDER BY c.created_at, c.id
+		LIMIT ?3
+	)
+	UNION ALL
+	SELECT r.*
+	FROM thread t
+	CROSS JOIN LATERAL (
+		SELECT c.*, row_number() OVER (ORDER BY c.created_at, c.id) AS rank
+		FROM comments c
+		WHERE c.parent_comment_id = t.id AND ` + visibleComment("c") + `
+		ORDER BY c.created_at, c.id
+		LIMIT ?3
+	) r
+	WHERE t.depth < ?4 AND t.rank <= ?5
+)
+SELECT thread.*, EXISTS (
+	SELECT 1 FROM comments c
+	WHERE c.parent_comment_id = thread.id AND ` + visibleComment("c") + `
+) AS has_children
+FROM thread
+ORDER BY depth, created_at, id`
+
+func (c *CommentStorageDb) GetCommentThread(postId string, query ThreadQuery, ctx context.Context) (*Thread, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	var afterCreatedAt, afterId *string
+	if query.After != nil {
+		afterCreatedAt, afterId = &query.After.CreatedAt, &query.After.ID
+	}
+
+	var rows []*threadRow
+	_, err := c.db.WithContext(ctx).Query(&rows, commentThreadQuery,
+		postId, afterCreatedAt, afterId, query.MaxChildren+1, query.MaxDepth, query.MaxChildren)
+	if err != nil {
+		return nil, mapDbError(err, (*model.Comment)(nil))
+	}
+
+	return buildThread(rows, query), nil
+}
+
+func (c *CommentStorageDb) postCommentsQuery(query *pg.Query, postId string) *pg.Query {
+	return query.Where("parent_comment_id is null").Where("parent_post_id = ?", postId).Where(visibleComment("comment"))
+}
+
+func (c *CommentStorageDb) childCommentsQuery(query *pg.Query, commentId string) *pg.Query {
+	return query.Where("parent_comment_id = ?", commentId).Where(visibleComment("comment"))
+}
+
+func (c *CommentStorageDb) GetCommentsByIds(commentIds []string, ctx context.Context) ([]*model.Comment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var comments []*model.Comment
+	if err := getDataByIds(c.db, &comments, commentIds, ctx); err != nil {
 		return nil, err
 	}
 
@@ -89,7 +240,7 @@ func (c *CommentStorageDb) UpdateComment(newComment *model.Comment, ctx context.
 	return updateData(c.db, newComment, ctx)
 }
 
-func (c *CommentStorageDb) DeleteComment(commentId string, ctx context.Context) error {
+func (c *CommentStorageDb) DeleteComment(commentId string, deletedBy string, ctx context.Context) error {
 	comment, err := c.GetCommentById(commentId, ctx)
 	if err != nil {
 		return err
@@ -98,5 +249,11 @@ func (c *CommentStorageDb) DeleteComment(commentId string, ctx context.Context)
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	return deleteData(c.db, comment, ctx)
+	query, err := buildQuery(c.db, comment, ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = query.Set("deleted_at = NOW()").Set("deleted_by = ?", deletedBy).WherePK().Update()
+	return mapDbError(err, comment)
 }
@@ -2,14 +2,13 @@ package storage
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"sort"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
 	"github.com/k0ch3gar/ozon-task/internal/storage/model"
 )
 
@@ -17,9 +16,14 @@ type CommentStorageInMemory struct {
 	shards     []*StorageInMemoryShard[model.Comment]
 	shardCount uint64
 	lastId     uint64
+
+	// children indexes comments by parent, top level comments are indexed
+	// under their post. Every list is kept in (created_at, id) order.
+	childrenMu sync.RWMutex
+	children   map[string][]Cursor
 }
 
-func NewInMemoryCommentStorage(params config.ApplicationParameters) CommentStorage {
+func NewInMemoryCommentStorage(params config.ApplicationParameters) *CommentStorageInMemory {
 	shards := make([]*StorageInMemoryShard[model.Comment], params.StorageShardsCount)
 	for i := range shards {
 		shards[i] = &StorageInMemoryShard[model.Comment]{}
@@ -31,6 +35,7 @@ func NewInMemoryCommentStorage(params config.ApplicationParameters) CommentStora
 		shardCount: params.StorageShardsCount,
 		shards:     shards,
 		lastId:     0,
+		children:   make(map[string][]Cursor),
 	}
 }
 
@@ -44,141 +49,77 @@ func (c *CommentStorageInMemory) GetCommentById(commentId string, ctx context.Co
 	cs.mu.Lock()
 	defer cs.mu.Unlock()
 
+	// Deleted comments are returned as well, they stay in the tree as
+	// tombstones while they have replies.
 	comment, ok := cs.data[commentId]
 	if !ok {
-		return nil, errors.New("no such comment")
-	}
-
-	if err = c.ValidateCommentExistence(cs.data[commentId]); err != nil {
-		return nil, err
+		return nil, errs.NotFound("no such comment")
 	}
 
 	return comment, nil
 }
 
+func (c *CommentStorageInMemory) GetCommentsByIds(commentIds []string, ctx context.Context) ([]*model.Comment, error) {
+	return getByIds(c.shards, c.shardCount, commentIds)
+}
+
 func (c *CommentStorageInMemory) ValidateCommentExistence(comment *model.Comment) error {
 	if comment.DeletedAt != nil {
-		return errors.New(fmt.Sprintf("comment with this id is deleted: %s", comment.ID))
+		return errs.Deleted("comment with this id is deleted: %s", comment.ID)
 	}
 
 	return nil
 }
 
-func (c *CommentStorageInMemory) GetFirstCommentsByPost(postId string, offset, count uint64, ctx context.Context) ([]*model.Comment, error) {
-	var wg sync.WaitGroup
-	mu := &sync.Mutex{}
-	var allComments []*model.Comment
-
-	for _, shard := range c.shards {
-		wg.Add(1)
-		go func(cs *StorageInMemoryShard[model.Comment]) {
-			defer wg.Done()
-
-			cs.mu.Lock()
-			defer cs.mu.Unlock()
-
-			var comments []*model.Comment
-			for _, val := range cs.data {
-				if val.ParentPostID != postId || val.ParentCommentID != nil {
-					continue
-				}
-
-				comments = append(comments, val)
-			}
-
-			mu.Lock()
-			allComments = append(allComments, comments...)
-			mu.Unlock()
-		}(shard)
-	}
-
-	wg.Wait()
-
-	sort.Slice(allComments, func(i, j int) bool {
-
-		t1, err := time.Parse(time.RFC3339, allComments[i].CreatedAt)
-		if err != nil {
-			panic(err)
-		}
-
-		t2, _ := time.Parse(time.RFC3339, allComments[j].CreatedAt)
-		if err != nil {
-			panic(err)
-		}
-
-		return t1.Before(t2)
-	})
-
-	if offset >= uint64(len(allComments)) {
-		return []*model.Comment{}, nil
-	}
-
-	end := offset + count
-	if end > uint64(len(allComments)) {
-		end = uint64(len(allComments))
-	}
-
-	return allComments[offset:end], nil
+func (c *CommentStorageInMemory) GetCommentsByPost(postId string, page PageQuery, ctx context.Context) ([]*model.Comment, error) {
+	return paginate(c.visibleChildren(postChildrenKey(postId)), commentCursor, page), nil
 }
 
-func (c *CommentStorageInMemory) GetFirstCommentsByComment(commentId string, offset, count uint64, ctx context.Context) ([]*model.Comment, error) {
-	var wg sync.WaitGroup
-	mu := &sync.Mutex{}
-	var allComments []*model.Comment
+func (c *CommentStorageInMemory) CountCommentsByPost(postId string, ctx context.Context) (uint64, error) {
+	return uint64(len(c.visibleChildren(postChildrenKey(postId)))), nil
+}
 
-	for _, shard := range c.shards {
-		wg.Add(1)
-		go func(cs *StorageInMemoryShard[model.Comment]) {
-			defer wg.Done()
+func (c *CommentStorageInMemory) GetCommentsByComment(commentId string, page PageQuery, ctx context.Context) ([]*model.Comment, error) {
+	return paginate(c.visibleChildren(commentChildrenKey(commentId)), commentCursor, page), nil
+}
 
-			cs.mu.Lock()
-			defer cs.mu.Unlock()
+func (c *CommentStorageInMemory) CountCommentsByComment(commentId string, ctx context.Context) (uint64, error) {
+	return uint64(len(c.visibleChildren(commentChildrenKey(commentId)))), nil
+}
 
-			var comments []*model.Comment
-			for _, val := range cs.data {
-				if val.ParentCommentID == nil || *val.ParentCommentID != commentId {
-					continue
-				}
+func (c *CommentStorageInMemory) GetCommentsByAuthor(authorId string, page PageQuery, ctx context.Context) ([]*model.Comment, error) {
+	return paginate(collect(c.shards, isAuthorComment(authorId)), commentCursor, page), nil
+}
 
-				comments = append(comments, val)
-			}
+func (c *CommentStorageInMemory) CountCommentsByAuthor(authorId string, ctx context.Context) (uint64, error) {
+	return uint64(len(collect(c.shards, isAuthorComment(authorId)))), nil
+}
 
-			mu.Lock()
-			allComments = append(allComments, comments...)
-			mu.Unlock()
-		}(shard)
+func isAuthorComment(authorId string) func(*model.Comment) bool {
+	return func(comment *model.Comment) bool {
+		return comment.DeletedAt == nil && comment.AuthorID != nil && *comment.AuthorID == authorId
 	}
+}
 
-	wg.Wait()
-
-	sort.Slice(allComments, func(i, j int) bool {
+func commentCursor(comment *model.Comment) Cursor {
+	return Cursor{CreatedAt: comment.CreatedAt, ID: comment.ID}
+}
 
-		t1, err := time.Parse(time.RFC3339, allComments[i].CreatedAt)
-		if err != nil {
-			panic(err)
+func (c *CommentStorageInMemory) InsertComment(comment *model.Comment, ctx context.Context) error {
+	var parent *model.Comment
+	if comment.ParentCommentID != nil {
+		found, ok := c.lookup(*comment.ParentCommentID)
+		if !ok || found.DeletedAt != nil {
+			return errs.NotFound("no such parent comment: %s", *comment.ParentCommentID)
 		}
 
-		t2, _ := time.Parse(time.RFC3339, allComments[j].CreatedAt)
-		if err != nil {
-			panic(err)
+		if found.ParentPostID != comment.ParentPostID {
+			return errs.InvalidField("parentCommentId", "belongs to another post")
 		}
 
-		return t1.Before(t2)
-	})
-
-	if offset >= uint64(len(allComments)) {
-		return []*model.Comment{}, nil
+		parent = &found
 	}
 
-	end := offset + count
-	if end > uint64(len(allComments)) {
-		end = uint64(len(allComments))
-	}
-
-	return allComments[offset:end], nil
-}
-
-func (c *CommentStorageInMemory) InsertComment(comment *model.Comment, ctx context.Context) error {
 	id := strconv.FormatUint(c.lastId, 10)
 	idx, err := getStorageShardIdx(c.shards, c.shardCount, id)
 	if err != nil {
@@ -191,14 +132,24 @@ func (c *CommentStorageInMemory) InsertComment(comment *model.Comment, ctx conte
 
 	_, ok := cs.data[id]
 	if ok {
-		return errors.New("such comment already exists")
+		return errs.Conflict("such comment already exists")
 	}
 
 	comment.ID = id
-	comment.CreatedAt = time.Now().Format(time.RFC3339)
+	comment.CreatedAt = time.Now().Format(time.RFC3339Nano)
+	if parent == nil {
+		comment.RootID = id
+		comment.Depth = 0
+		comment.Path = commentPath("", id)
+	} else {
+		comment.RootID = parent.RootID
+		comment.Depth = parent.Depth + 1
+		comment.Path = commentPath(parent.Path, id)
+	}
 
 	cs.data[id] = comment
 	c.lastId++
+	c.indexChild(comment)
 	return nil
 }
 
@@ -214,7 +165,7 @@ func (c *CommentStorageInMemory) UpdateComment(newComment *model.Comment, ctx co
 
 	_, ok := cs.data[newComment.ID]
 	if !ok {
-		return errors.New("no such comment exists")
+		return errs.NotFound("no such comment")
 	}
 
 	if err = c.ValidateCommentExistence(newComment); err != nil {
@@ -225,7 +176,7 @@ func (c *CommentStorageInMemory) UpdateComment(newComment *model.Comment, ctx co
 	return nil
 }
 
-func (c *CommentStorageInMemory) DeleteComment(commentId string, ctx context.Context) error {
+func (c *CommentStorageInMemory) DeleteComment(commentId string, deletedBy string, ctx context.Context) error {
 	idx, err := getStorageShardIdx(c.shards, c.shardCount, commentId)
 	if err != nil {
 		return err
@@ -237,7 +188,7 @@ func (c *CommentStorageInMemory) DeleteComment(commentId string, ctx context.Con
 
 	comment, ok := cs.data[commentId]
 	if !ok {
-		return errors.New("no such comment exists")
+		return errs.NotFound("no such comment")
 	}
 
 	if err = c.ValidateCommentExistence(comment); err != nil {
@@ -246,5 +197,173 @@ func (c *CommentStorageInMemory) DeleteComment(commentId string, ctx context.Con
 
 	deletionTime := time.Now().Format(time.RFC3339)
 	cs.data[commentId].DeletedAt = &deletionTime
+	cs.data[commentId].DeletedBy = &deletedBy
 	return nil
 }
+
+func (c *CommentStorageInMemory) GetCommentThread(postId string, query ThreadQuery, ctx context.Context) (*Thread, error) {
+	limit := query.MaxChildren + 1
+	level := c.threadLevel(postChildrenKey(postId), query.After, limit)
+
+	var rows []*threadRow
+	for depth := uint64(0); len(level) > 0; depth++ {
+		rows = append(rows, level...)
+		if depth >= query.MaxDepth {
+			break
+		}
+
+		var next []*threadRow
+		for _, parent := range level {
+			if parent.Rank <= query.MaxChildren {
+				next = append(next, c.threadLevel(commentChildrenKey(parent.ID), nil, limit)...)
+			}
+		}
+
+		level = next
+	}
+
+	return buildThread(rows, query), nil
+}
+
+// threadLevel returns up to limit visible children indexed under key.
+func (c *CommentStorageInMemory) threadLevel(key string, after *Cursor, limit uint64) []*threadRow {
+	var rows []*threadRow
+	for _, child := range c.indexedChildren(key, after) {
+		if uint64(len(rows)) == limit {
+			break
+		}
+
+		comment, ok := c.lookup(child.ID)
+		if !ok || !c.isVisible(&comment) {
+			continue
+		}
+
+		rows = append(rows, &threadRow{
+			Comment:     comment,
+			Rank:        uint64(len(rows)) + 1,
+			HasChildren: len(c.visibleChildren(commentChildrenKey(comment.ID))) > 0,
+		})
+	}
+
+	return rows
+}
+
+// visibleChildren returns copies of the children indexed under key that are
+// either alive or tombstones of a branch with something alive below.
+func (c *CommentStorageInMemory) visibleChildren(key string) []*model.Comment {
+	var comments []*model.Comment
+	for _, child := range c.indexedChildren(key, nil) {
+		comment, ok := c.lookup(child.ID)
+		if ok && c.isVisible(&comment) {
+			comments = append(comments, &comment)
+		}
+	}
+
+	return comments
+}
+
+// isVisible prunes branches where everything is deleted.
+func (c *CommentStorageInMemory) isVisible(comment *model.Comment) bool {
+	if comment.DeletedAt == nil {
+		return true
+	}
+
+	for _, child := range c.indexedChildren(commentChildrenKey(comment.ID), nil) {
+		if reply, ok := c.lookup(child.ID); ok && c.isVisible(&reply) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookup returns a copy of the comment, so it can be read without the shard
+// lock held.
+func (c *CommentStorageInMemory) lookup(commentId string) (model.Comment, bool) {
+	idx, err := getStorageShardIdx(c.shards, c.shardCount, commentId)
+	if err != nil {
+		return model.Comment{}, false
+	}
+
+	cs := c.shards[idx]
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	comment, ok := cs.data[commentId]
+	if !ok {
+		return model.Comment{}, false
+	}
+
+	return *comment, true
+}
+
+// indexedChildren copies the part of the list after the cursor, the index
+// lock is never held together with a shard lock taken after it.
+func (c *CommentStorageInMemory) indexedChildren(key string, after *Cursor) []Cursor {
+	c.childrenMu.RLock()
+	defer c.childrenMu.RUnlock()
+
+	children := c.children[key]
+	from := 0
+	if after != nil {
+		from = sort.Search(len(children), func(i int) bool {
+			return compareCursors(children[i], *after) > 0
+		})
+	}
+
+	return append([]Cursor{}, children[from:]...)
+}
+
+func (c *CommentStorageInMemory) indexChild(comment *model.Comment) {
+	key := postChildrenKey(comment.ParentPostID)
+	if comment.ParentCommentID != nil {
+		key = commentChildrenKey(*comment.ParentCommentID)
+	}
+
+	c.childrenMu.Lock()
+	defer c.childrenMu.Unlock()
+
+	cursor := commentCursor(comment)
+	children := c.children[key]
+	i := sort.Search(len(children), func(i int) bool {
+		return compareCursors(children[i], cursor) > 0
+	})
+
+	children = append(children, Cursor{})
+	copy(children[i+1:], children[i:])
+	children[i] = cursor
+	c.children[key] = children
+}
+
+// unindexChild removes the comment from the list of its parent, the index lock
+// must be held.
+func (c *CommentStorageInMemory) unindexChild(comment *model.Comment) {
+	key := postChildrenKey(comment.ParentPostID)
+	if comment.ParentCommentID != nil {
+		key = commentChildrenKey(*comment.ParentCommentID)
+	}
+
+	cursor := commentCursor(comment)
+	children := c.children[key]
+	i := sort.Search(len(children), func(i int) bool {
+		return compareCursors(children[i], cursor) >= 0
+	})
+
+	if i < len(children) && children[i] == cursor {
+		children = append(children[:i], children[i+1:]...)
+	}
+
+	if len(children) == 0 {
+		delete(c.children, key)
+	} else {
+		c.children[key] = children
+	}
+}
+
+func postChildrenKey(postId string) string {
+	return "post:" + postId
+}
+
+func commentChildrenKey(commentId string) string {
+	return "comment:" + commentId
+}
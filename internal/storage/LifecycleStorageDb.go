@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+)
+
+type LifecycleStorageDb struct {
+	db *pg.DB
+	mu sync.Mutex
+}
+
+func NewDbLifecycleStorage(db *pg.DB) LifecycleStorage {
+	return &LifecycleStorageDb{
+		db: db,
+		mu: sync.Mutex{},
+	}
+}
+
+func (l *LifecycleStorageDb) DeletePost(postId string, deletedBy string, ctx context.Context) (*Cascade, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	cascade := &Cascade{}
+	err := l.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		post := &model.Post{ID: postId}
+		if err := lockRow(tx, post); err != nil {
+			return err
+		}
+
+		if post.DeletedAt != nil {
+			return errs.Deleted("post with this id is already deleted: %s", postId)
+		}
+
+		if err := softDelete(tx, &cascade.Comments, deletedBy, "parent_post_id = ?", postId); err != nil {
+			return err
+		}
+
+		var posts []*model.Post
+		return softDelete(tx, &posts, deletedBy, "id = ?", postId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cascade, nil
+}
+
+func (l *LifecycleStorageDb) DeleteUser(userId string, deletedBy string, policy config.UserContentPolicy, ctx context.Context) (*model.User, *Cascade, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.db == nil {
+		return nil, nil, errors.New("db is nil")
+	}
+
+	user := &model.User{ID: userId}
+	cascade := &Cascade{}
+	err := l.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := lockRow(tx, user); err != nil {
+			return err
+		}
+
+		if user.DeletedAt != nil {
+			return errs.Deleted("user with this id is already deleted: %s", userId)
+		}
+
+		switch policy {
+		case config.RemoveUserContent:
+			err := softDelete(tx, &cascade.Comments, deletedBy,
+				"author_id = ? OR parent_post_id IN (SELECT id FROM posts WHERE author_id = ?)", userId, userId)
+			if err != nil {
+				return err
+			}
+
+			if err = softDelete(tx, &cascade.Posts, deletedBy, "author_id = ?", userId); err != nil {
+				return err
+			}
+		default:
+			if err := anonymize(tx, (*model.Comment)(nil), userId); err != nil {
+				return err
+			}
+
+			if err := anonymize(tx, (*model.Post)(nil), userId); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Model(user).Set("deleted_at = NOW()").WherePK().Returning("*").Update()
+		return mapDbError(err, user)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, cascade, nil
+}
+
+func (l *LifecycleStorageDb) RestorePost(postId string, deletedAfter time.Time, ctx context.Context) (*model.Post, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	post := &model.Post{ID: postId}
+	err := l.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := lockRow(tx, post); err != nil {
+			return err
+		}
+
+		if post.DeletedAt == nil {
+			return errs.Conflict("post with this id is not deleted: %s", postId)
+		}
+
+		err := restoreCascaded(tx, (*model.Comment)(nil), "posts", postId, deletedAfter, "parent_post_id = ?", postId)
+		if err != nil {
+			return err
+		}
+
+		return restoreRow(tx, post, deletedAfter)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return post, nil
+}
+
+func (l *LifecycleStorageDb) RestoreComment(commentId string, deletedAfter time.Time, ctx context.Context) (*model.Comment, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	comment := &model.Comment{ID: commentId}
+	err := l.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := lockRow(tx, comment); err != nil {
+			return err
+		}
+
+		if comment.DeletedAt == nil {
+			return errs.Conflict("comment with this id is not deleted: %s", commentId)
+		}
+
+		post := &model.Post{ID: comment.ParentPostID}
+		if err := mapDbError(tx.Model(post).WherePK().For("SHARE").Select(), post); err != nil {
+			return err
+		}
+
+		if post.DeletedAt != nil {
+			return errs.Deleted("post with this id is deleted: %s", post.ID)
+		}
+
+		return restoreRow(tx, comment, deletedAfter)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return comment, nil
+}
+
+func (l *LifecycleStorageDb) RestoreUser(userId string, deletedAfter time.Time, ctx context.Context) (*model.User, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	user := &model.User{ID: userId}
+	err := l.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := lockRow(tx, user); err != nil {
+			return err
+		}
+
+		if user.DeletedAt == nil {
+			return errs.Conflict("user with this id is not deleted: %s", userId)
+		}
+
+		err := restoreCascaded(tx, (*model.Comment)(nil), "users", userId, deletedAfter,
+			"author_id = ? OR parent_post_id IN (SELECT id FROM posts WHERE author_id = ?)", userId, userId)
+		if err != nil {
+			return err
+		}
+
+		if err = restoreCascaded(tx, (*model.Post)(nil), "users", userId, deletedAfter, "author_id = ?", userId); err != nil {
+			return err
+		}
+
+		return restoreRow(tx, user, deletedAfter)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (l *LifecycleStorageDb) Purge(deletedBefore time.Time, ctx context.Context) (PurgeResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.db == nil {
+		return PurgeResult{}, errors.New("db is nil")
+	}
+
+	var result PurgeResult
+	err := l.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		// A whole expired branch goes in one statement, the foreign key from
+		// replies to their parent is only checked at its end. Deleted
+		// descendants count too, so the check is a range scan of the
+		// non-partial comments_root_path_idx rather than of the live one.
+		res, err := tx.Model((*model.Comment)(nil)).
+			Where("comment.deleted_at < ?", deletedBefore).
+			Where(`NOT EXISTS (
+				SELECT 1 FROM comments d
+				WHERE d.root_id = comment.root_id
+					AND d.path > comment.path || '.' AND d.path < comment.path || '/'
+					AND (d.deleted_at IS NULL OR d.deleted_at >= ?)
+			)`, deletedBefore).
+			Delete()
+		if err != nil {
+			return mapDbError(err, (*model.Comment)(nil))
+		}
+		result.Comments = uint64(res.RowsAffected())
+
+		res, err = tx.Model((*model.Post)(nil)).
+			Where("post.deleted_at < ?", deletedBefore).
+			Where("NOT EXISTS (SELECT 1 FROM comments c WHERE c.parent_post_id = post.id)").
+			Delete()
+		if err != nil {
+			return mapDbError(err, (*model.Post)(nil))
+		}
+		result.Posts = uint64(res.RowsAffected())
+
+		// Whatever the purged users still author is kept anonymously.
+		expiredUsers := "author_id IN (SELECT id FROM users WHERE deleted_at < ?)"
+		for _, data := range []interface{}{(*model.Post)(nil), (*model.Comment)(nil)} {
+			if _, err = tx.Model(data).Set("author_id = NULL").Where(expiredUsers, deletedBefore).Update(); err != nil {
+				return mapDbError(err, data)
+			}
+		}
+
+		res, err = tx.Model((*model.User)(nil)).Where("deleted_at < ?", deletedBefore).Delete()
+		if err != nil {
+			return mapDbError(err, (*model.User)(nil))
+		}
+		result.Users = uint64(res.RowsAffected())
+
+		return nil
+	})
+	if err != nil {
+		return PurgeResult{}, err
+	}
+
+	return result, nil
+}
+
+// lockRow selects the row for update, so no other transaction changes it
+// until the cascade is done.
+func lockRow(tx *pg.Tx, data interface{}) error {
+	return mapDbError(tx.Model(data).WherePK().For("UPDATE").Select(), data)
+}
+
+// softDelete deletes the live rows matching condition on behalf of deletedBy
+// and returns them.
+func softDelete[T any](tx *pg.Tx, rows *[]*T, deletedBy string, condition string, params ...interface{}) error {
+	_, err := tx.Model(rows).
+		Set("deleted_at = NOW()").
+		Set("deleted_by = ?", deletedBy).
+		Where("deleted_at IS NULL").
+		WhereGroup(func(q *pg.Query) (*pg.Query, error) {
+			return q.Where(condition, params...), nil
+		}).
+		Returning("*").
+		Update()
+	return mapDbError(err, rows)
+}
+
+func anonymize(tx *pg.Tx, data interface{}, userId string) error {
+	_, err := tx.Model(data).Set("author_id = NULL").Where("author_id = ?", userId).Update()
+	return mapDbError(err, data)
+}
+
+// restoreRow clears deleted_at of a row locked by lockRow. Rows deleted before
+// deletedAfter are left to the purge.
+func restoreRow(tx *pg.Tx, data interface{}, deletedAfter time.Time) error {
+	res, err := tx.Model(data).Set(undeleted(data)).WherePK().Where("deleted_at >= ?", deletedAfter).Returning("*").Update()
+	if err != nil {
+		return mapDbError(err, data)
+	}
+
+	if res.RowsAffected() == 0 {
+		return errs.Deleted("%s can no longer be restored", entityName(data))
+	}
+
+	return nil
+}
+
+// restoreCascaded brings back the rows matching condition that were deleted
+// in the same transaction as the owner row. It has to run before the owner is
+// restored.
+func restoreCascaded(
+	tx *pg.Tx,
+	data interface{},
+	ownerTable string,
+	ownerId string,
+	deletedAfter time.Time,
+	condition string,
+	params ...interface{},
+) error {
+	owner := fmt.Sprintf("deleted_at = (SELECT deleted_at FROM %s WHERE id = ? AND deleted_at >= ?)", ownerTable)
+	_, err := tx.Model(data).
+		Set(undeleted(data)).
+		Where(owner, ownerId, deletedAfter).
+		WhereGroup(func(q *pg.Query) (*pg.Query, error) {
+			return q.Where(condition, params...), nil
+		}).
+		Update()
+	return mapDbError(err, data)
+}
+
+// undeleted clears the deletion of a row, posts and comments also forget who
+// deleted them.
+func undeleted(data interface{}) string {
+	if _, ok := data.(*model.User); ok {
+		return "deleted_at = NULL"
+	}
+
+	return "deleted_at = NULL, deleted_by = NULL"
+}
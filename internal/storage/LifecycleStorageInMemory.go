@@ -0,0 +1,459 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+)
+
+// LifecycleStorageInMemory runs cascades over the in-memory storages. Every
+// shard a cascade may touch is locked up front, users before posts before
+// comments before notifications, so the change is never seen half applied.
+type LifecycleStorageInMemory struct {
+	u *UserStorageInMemory
+	p *PostStorageInMemory
+	c *CommentStorageInMemory
+	n *NotificationStorageInMemory
+}
+
+func NewInMemoryLifecycleStorage(
+	u *UserStorageInMemory,
+	p *PostStorageInMemory,
+	c *CommentStorageInMemory,
+	n *NotificationStorageInMemory,
+) LifecycleStorage {
+	return &LifecycleStorageInMemory{
+		u: u,
+		p: p,
+		c: c,
+		n: n,
+	}
+}
+
+func (l *LifecycleStorageInMemory) DeletePost(postId string, deletedBy string, ctx context.Context) (*Cascade, error) {
+	idx, err := getStorageShardIdx(l.p.shards, l.p.shardCount, postId)
+	if err != nil {
+		return nil, err
+	}
+
+	ps := l.p.shards[idx]
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	post, ok := ps.data[postId]
+	if !ok {
+		return nil, errs.NotFound("no such post with id: %s", postId)
+	}
+
+	if post.DeletedAt != nil {
+		return nil, errs.Deleted("post with this id is already deleted: %s", postId)
+	}
+
+	defer lockShards(l.c.shards)()
+
+	deletionTime := time.Now().Format(time.RFC3339Nano)
+	cascade := &Cascade{}
+	cascade.Comments = update(l.c.shards, func(comment *model.Comment) bool {
+		if comment.ParentPostID != postId || comment.DeletedAt != nil {
+			return false
+		}
+
+		comment.DeletedAt = &deletionTime
+		comment.DeletedBy = &deletedBy
+		return true
+	})
+
+	deleted := *post
+	deleted.DeletedAt = &deletionTime
+	deleted.DeletedBy = &deletedBy
+	ps.data[postId] = &deleted
+	return cascade, nil
+}
+
+func (l *LifecycleStorageInMemory) DeleteUser(userId string, deletedBy string, policy config.UserContentPolicy, ctx context.Context) (*model.User, *Cascade, error) {
+	idx, err := getStorageShardIdx(l.u.idShards, l.u.shardCount, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uss := l.u.idShards[idx]
+	uss.mu.Lock()
+	defer uss.mu.Unlock()
+
+	user, ok := uss.data[userId]
+	if !ok {
+		return nil, nil, errs.NotFound("no such user with id: %s", userId)
+	}
+
+	if user.DeletedAt != nil {
+		return nil, nil, errs.Deleted("user with this id is already deleted: %s", userId)
+	}
+
+	defer lockShards(l.p.shards)()
+	defer lockShards(l.c.shards)()
+
+	deletionTime := time.Now().Format(time.RFC3339Nano)
+	isAuthor := func(authorId *string) bool {
+		return authorId != nil && *authorId == userId
+	}
+
+	cascade := &Cascade{}
+	switch policy {
+	case config.RemoveUserContent:
+		removed := make(map[string]bool)
+		cascade.Posts = update(l.p.shards, func(post *model.Post) bool {
+			if !isAuthor(post.AuthorID) {
+				return false
+			}
+
+			removed[post.ID] = true
+			if post.DeletedAt != nil {
+				return false
+			}
+
+			post.DeletedAt = &deletionTime
+			post.DeletedBy = &deletedBy
+			return true
+		})
+
+		cascade.Comments = update(l.c.shards, func(comment *model.Comment) bool {
+			if comment.DeletedAt != nil || !(isAuthor(comment.AuthorID) || removed[comment.ParentPostID]) {
+				return false
+			}
+
+			comment.DeletedAt = &deletionTime
+			comment.DeletedBy = &deletedBy
+			return true
+		})
+	default:
+		update(l.p.shards, func(post *model.Post) bool {
+			if !isAuthor(post.AuthorID) {
+				return false
+			}
+
+			post.AuthorID = nil
+			return true
+		})
+
+		update(l.c.shards, func(comment *model.Comment) bool {
+			if !isAuthor(comment.AuthorID) {
+				return false
+			}
+
+			comment.AuthorID = nil
+			return true
+		})
+	}
+
+	// The username and email indexes share the pointer with the id shard.
+	user.DeletedAt = &deletionTime
+	return user, cascade, nil
+}
+
+func (l *LifecycleStorageInMemory) RestorePost(postId string, deletedAfter time.Time, ctx context.Context) (*model.Post, error) {
+	idx, err := getStorageShardIdx(l.p.shards, l.p.shardCount, postId)
+	if err != nil {
+		return nil, err
+	}
+
+	ps := l.p.shards[idx]
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	post, ok := ps.data[postId]
+	if !ok {
+		return nil, errs.NotFound("no such post with id: %s", postId)
+	}
+
+	if err = restorable("post", postId, post.DeletedAt, deletedAfter); err != nil {
+		return nil, err
+	}
+
+	defer lockShards(l.c.shards)()
+
+	update(l.c.shards, func(comment *model.Comment) bool {
+		if comment.ParentPostID != postId || !deletedTogether(comment.DeletedAt, post.DeletedAt) {
+			return false
+		}
+
+		comment.DeletedAt = nil
+		comment.DeletedBy = nil
+		return true
+	})
+
+	restored := *post
+	restored.DeletedAt = nil
+	restored.DeletedBy = nil
+	ps.data[postId] = &restored
+	return &restored, nil
+}
+
+func (l *LifecycleStorageInMemory) RestoreComment(commentId string, deletedAfter time.Time, ctx context.Context) (*model.Comment, error) {
+	found, ok := l.c.lookup(commentId)
+	if !ok {
+		return nil, errs.NotFound("no such comment")
+	}
+
+	postIdx, err := getStorageShardIdx(l.p.shards, l.p.shardCount, found.ParentPostID)
+	if err != nil {
+		return nil, err
+	}
+
+	ps := l.p.shards[postIdx]
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	post, ok := ps.data[found.ParentPostID]
+	if !ok {
+		return nil, errs.NotFound("no such post with id: %s", found.ParentPostID)
+	}
+
+	if post.DeletedAt != nil {
+		return nil, errs.Deleted("post with this id is deleted: %s", post.ID)
+	}
+
+	idx, err := getStorageShardIdx(l.c.shards, l.c.shardCount, commentId)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := l.c.shards[idx]
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	comment, ok := cs.data[commentId]
+	if !ok {
+		return nil, errs.NotFound("no such comment")
+	}
+
+	if err = restorable("comment", commentId, comment.DeletedAt, deletedAfter); err != nil {
+		return nil, err
+	}
+
+	restored := *comment
+	restored.DeletedAt = nil
+	restored.DeletedBy = nil
+	cs.data[commentId] = &restored
+	return &restored, nil
+}
+
+func (l *LifecycleStorageInMemory) RestoreUser(userId string, deletedAfter time.Time, ctx context.Context) (*model.User, error) {
+	idx, err := getStorageShardIdx(l.u.idShards, l.u.shardCount, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	uss := l.u.idShards[idx]
+	uss.mu.Lock()
+	defer uss.mu.Unlock()
+
+	user, ok := uss.data[userId]
+	if !ok {
+		return nil, errs.NotFound("no such user with id: %s", userId)
+	}
+
+	if err = restorable("user", userId, user.DeletedAt, deletedAfter); err != nil {
+		return nil, err
+	}
+
+	defer lockShards(l.p.shards)()
+	defer lockShards(l.c.shards)()
+
+	restoredPosts := make(map[string]bool)
+	update(l.p.shards, func(post *model.Post) bool {
+		if post.AuthorID == nil || *post.AuthorID != userId || !deletedTogether(post.DeletedAt, user.DeletedAt) {
+			return false
+		}
+
+		restoredPosts[post.ID] = true
+		post.DeletedAt = nil
+		post.DeletedBy = nil
+		return true
+	})
+
+	update(l.c.shards, func(comment *model.Comment) bool {
+		isAuthor := comment.AuthorID != nil && *comment.AuthorID == userId
+		if !(isAuthor || restoredPosts[comment.ParentPostID]) || !deletedTogether(comment.DeletedAt, user.DeletedAt) {
+			return false
+		}
+
+		comment.DeletedAt = nil
+		comment.DeletedBy = nil
+		return true
+	})
+
+	// The username and email indexes share the pointer with the id shard.
+	user.DeletedAt = nil
+	return user, nil
+}
+
+func (l *LifecycleStorageInMemory) Purge(deletedBefore time.Time, ctx context.Context) (PurgeResult, error) {
+	defer lockShards(l.u.idShards)()
+	defer lockShards(l.p.shards)()
+	defer lockShards(l.c.shards)()
+
+	l.c.childrenMu.Lock()
+	defer l.c.childrenMu.Unlock()
+
+	expired := func(deletedAt *string) bool {
+		if deletedAt == nil {
+			return false
+		}
+
+		deletionTime, err := time.Parse(time.RFC3339Nano, *deletedAt)
+		return err == nil && deletionTime.Before(deletedBefore)
+	}
+
+	var result PurgeResult
+
+	// A comment is purged only together with all of its replies.
+	purged := make(map[string]bool)
+	var purgeable func(comment *model.Comment) bool
+	purgeable = func(comment *model.Comment) bool {
+		if done, ok := purged[comment.ID]; ok {
+			return done
+		}
+
+		ok := expired(comment.DeletedAt)
+		for _, child := range l.c.children[commentChildrenKey(comment.ID)] {
+			if reply := lockedValue(l.c.shards, l.c.shardCount, child.ID); reply != nil && !purgeable(reply) {
+				ok = false
+			}
+		}
+
+		purged[comment.ID] = ok
+		return ok
+	}
+
+	commented := make(map[string]bool)
+	for _, shard := range l.c.shards {
+		for id, comment := range shard.data {
+			if !purgeable(comment) {
+				commented[comment.ParentPostID] = true
+				continue
+			}
+
+			delete(shard.data, id)
+			delete(l.c.children, commentChildrenKey(id))
+			l.c.unindexChild(comment)
+			result.Comments++
+		}
+	}
+
+	for _, shard := range l.p.shards {
+		for id, post := range shard.data {
+			if expired(post.DeletedAt) && !commented[id] {
+				delete(shard.data, id)
+				delete(l.c.children, postChildrenKey(id))
+				result.Posts++
+			}
+		}
+	}
+
+	expiredUsers := make(map[string]bool)
+	for _, shard := range l.u.idShards {
+		for id, user := range shard.data {
+			if !expired(user.DeletedAt) {
+				continue
+			}
+
+			expiredUsers[id] = true
+			delete(shard.data, id)
+			_ = l.u.removeIndexed(l.u.usernameShard, user.Username, id)
+			_ = l.u.removeIndexed(l.u.emailShard, user.Email, id)
+			result.Users++
+		}
+	}
+
+	// Whatever the purged users still author is kept anonymously.
+	update(l.p.shards, func(post *model.Post) bool {
+		if post.AuthorID == nil || !expiredUsers[*post.AuthorID] {
+			return false
+		}
+
+		post.AuthorID = nil
+		return true
+	})
+
+	update(l.c.shards, func(comment *model.Comment) bool {
+		if comment.AuthorID == nil || !expiredUsers[*comment.AuthorID] {
+			return false
+		}
+
+		comment.AuthorID = nil
+		return true
+	})
+
+	l.n.purge(purged, expiredUsers)
+	return result, nil
+}
+
+// restorable tells whether an entity deleted at deletedAt may still be
+// restored.
+func restorable(entity string, id string, deletedAt *string, deletedAfter time.Time) error {
+	if deletedAt == nil {
+		return errs.Conflict("%s with this id is not deleted: %s", entity, id)
+	}
+
+	deletionTime, err := time.Parse(time.RFC3339Nano, *deletedAt)
+	if err != nil {
+		return err
+	}
+
+	if deletionTime.Before(deletedAfter) {
+		return errs.Deleted("%s can no longer be restored", entity)
+	}
+
+	return nil
+}
+
+// deletedTogether tells whether the value was deleted by the same cascade as
+// its owner.
+func deletedTogether(deletedAt *string, ownerDeletedAt *string) bool {
+	return deletedAt != nil && ownerDeletedAt != nil && *deletedAt == *ownerDeletedAt
+}
+
+// lockShards locks every shard in order and returns the function unlocking
+// them.
+func lockShards[T any](shards []*StorageInMemoryShard[T]) func() {
+	for _, shard := range shards {
+		shard.mu.Lock()
+	}
+
+	return func() {
+		for _, shard := range shards {
+			shard.mu.Unlock()
+		}
+	}
+}
+
+// update hands a copy of every value to change and stores the copies that
+// were changed, readers holding the old pointers are left untouched. The
+// shards must be locked.
+func update[T any](shards []*StorageInMemoryShard[T], change func(*T) bool) []*T {
+	var updated []*T
+	for _, shard := range shards {
+		for id, val := range shard.data {
+			changed := *val
+			if change(&changed) {
+				shard.data[id] = &changed
+				updated = append(updated, &changed)
+			}
+		}
+	}
+
+	return updated
+}
+
+// lockedValue returns the value stored under id, the shards must be locked.
+func lockedValue[T any](shards []*StorageInMemoryShard[T], shardCount uint64, id string) *T {
+	idx, err := getStorageShardIdx(shards, shardCount, id)
+	if err != nil {
+		return nil
+	}
+
+	return shards[idx].data[id]
+}
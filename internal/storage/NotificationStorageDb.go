@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+)
+
+type NotificationStorageDb struct {
+	mu sync.Mutex
+	db *pg.DB
+}
+
+func NewDbNotificationStorage(db *pg.DB) NotificationStorage {
+	return &NotificationStorageDb{
+		db: db,
+		mu: sync.Mutex{},
+	}
+}
+
+func (n *NotificationStorageDb) InsertNotifications(notifications []*model.Notification, ctx context.Context) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return insertData(n.db, &notifications, ctx)
+}
+
+func (n *NotificationStorageDb) GetNotificationsByUser(userId string, unreadOnly bool, page PageQuery, ctx context.Context) ([]*model.Notification, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var notifications []*model.Notification
+	query, err := buildQuery(n.db, &notifications, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = selectPage(whereUserNotifications(query, userId, unreadOnly), &notifications, page); err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+func (n *NotificationStorageDb) CountNotificationsByUser(userId string, unreadOnly bool, ctx context.Context) (uint64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	query, err := buildQuery(n.db, (*model.Notification)(nil), ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := whereUserNotifications(query, userId, unreadOnly).Count()
+	if err != nil {
+		return 0, mapDbError(err, (*model.Notification)(nil))
+	}
+
+	return uint64(count), nil
+}
+
+func (n *NotificationStorageDb) MarkNotificationsRead(userId string, notificationIds []string, ctx context.Context) ([]*model.Notification, error) {
+	notifications := []*model.Notification{}
+	// Ids that are not numbers match no notification, in the query they would
+	// fail the whole statement.
+	notificationIds = slices.DeleteFunc(slices.Clone(notificationIds), func(id string) bool {
+		_, err := strconv.ParseUint(id, 10, 64)
+		return err != nil
+	})
+	if len(notificationIds) == 0 {
+		return notifications, nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	query, err := buildQuery(n.db, &notifications, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = query.
+		Set("read_at = COALESCE(read_at, NOW())").
+		Where("user_id = ?", userId).
+		Where("id IN (?)", pg.In(notificationIds)).
+		Returning("*").
+		Update()
+	if err != nil {
+		return nil, mapDbError(err, &notifications)
+	}
+
+	return notifications, nil
+}
+
+func (n *NotificationStorageDb) GetPreferences(userId string, ctx context.Context) (*model.NotificationPreferences, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	preferences := &model.NotificationPreferences{UserID: userId}
+	err := getDataById(n.db, preferences, ctx)
+	if errors.Is(err, errs.ErrNotFound) {
+		return model.DefaultNotificationPreferences(userId), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return preferences, nil
+}
+
+func (n *NotificationStorageDb) UpdatePreferences(preferences *model.NotificationPreferences, ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	query, err := buildQuery(n.db, preferences, ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = query.
+		OnConflict("(user_id) DO UPDATE").
+		Set("replies = EXCLUDED.replies, comments = EXCLUDED.comments, mentions = EXCLUDED.mentions").
+		Insert()
+	return mapDbError(err, preferences)
+}
+
+func whereUserNotifications(query *pg.Query, userId string, unreadOnly bool) *pg.Query {
+	query = query.Where("user_id = ?", userId)
+	if unreadOnly {
+		query = query.Where("read_at is null")
+	}
+
+	return query
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+)
+
+// NotificationStorageInMemory keeps everything of a user in one shard entry,
+// so a single lock covers their inbox and preferences.
+type NotificationStorageInMemory struct {
+	shards     []*StorageInMemoryShard[userNotifications]
+	shardCount uint64
+	lastId     uint64
+}
+
+type userNotifications struct {
+	notifications []*model.Notification
+	preferences   *model.NotificationPreferences
+}
+
+func NewInMemoryNotificationStorage(params config.ApplicationParameters) *NotificationStorageInMemory {
+	shards := make([]*StorageInMemoryShard[userNotifications], params.StorageShardsCount)
+	for i := range shards {
+		shards[i] = &StorageInMemoryShard[userNotifications]{}
+		shards[i].mu = sync.Mutex{}
+		shards[i].data = make(map[string]*userNotifications)
+	}
+
+	return &NotificationStorageInMemory{
+		shardCount: params.StorageShardsCount,
+		shards:     shards,
+		lastId:     0,
+	}
+}
+
+func (n *NotificationStorageInMemory) InsertNotifications(notifications []*model.Notification, ctx context.Context) error {
+	createdAt := time.Now().Format(time.RFC3339Nano)
+	for _, notification := range notifications {
+		err := n.withUser(notification.UserID, func(user *userNotifications) {
+			notification.ID = strconv.FormatUint(atomic.AddUint64(&n.lastId, 1)-1, 10)
+			notification.CreatedAt = createdAt
+			user.notifications = append(user.notifications, notification)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *NotificationStorageInMemory) GetNotificationsByUser(userId string, unreadOnly bool, page PageQuery, ctx context.Context) ([]*model.Notification, error) {
+	var notifications []*model.Notification
+	err := n.withUser(userId, func(user *userNotifications) {
+		notifications = filterNotifications(user.notifications, unreadOnly)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return paginate(notifications, notificationCursor, page), nil
+}
+
+func (n *NotificationStorageInMemory) CountNotificationsByUser(userId string, unreadOnly bool, ctx context.Context) (uint64, error) {
+	var count uint64
+	err := n.withUser(userId, func(user *userNotifications) {
+		count = uint64(len(filterNotifications(user.notifications, unreadOnly)))
+	})
+
+	return count, err
+}
+
+// MarkNotificationsRead stores read copies, the notifications handed out
+// before stay as they were.
+func (n *NotificationStorageInMemory) MarkNotificationsRead(userId string, notificationIds []string, ctx context.Context) ([]*model.Notification, error) {
+	readAt := time.Now().Format(time.RFC3339Nano)
+	marked := []*model.Notification{}
+	err := n.withUser(userId, func(user *userNotifications) {
+		for i, notification := range user.notifications {
+			if !slices.Contains(notificationIds, notification.ID) {
+				continue
+			}
+
+			if notification.ReadAt == nil {
+				read := *notification
+				read.ReadAt = &readAt
+				user.notifications[i] = &read
+			}
+
+			marked = append(marked, user.notifications[i])
+		}
+	})
+
+	return marked, err
+}
+
+func (n *NotificationStorageInMemory) GetPreferences(userId string, ctx context.Context) (*model.NotificationPreferences, error) {
+	preferences := model.DefaultNotificationPreferences(userId)
+	err := n.withUser(userId, func(user *userNotifications) {
+		if user.preferences != nil {
+			*preferences = *user.preferences
+		}
+	})
+
+	return preferences, err
+}
+
+func (n *NotificationStorageInMemory) UpdatePreferences(preferences *model.NotificationPreferences, ctx context.Context) error {
+	stored := *preferences
+	return n.withUser(preferences.UserID, func(user *userNotifications) {
+		user.preferences = &stored
+	})
+}
+
+// withUser runs fn with the shard of the user locked.
+func (n *NotificationStorageInMemory) withUser(userId string, fn func(*userNotifications)) error {
+	idx, err := getStorageShardIdx(n.shards, n.shardCount, userId)
+	if err != nil {
+		return err
+	}
+
+	ns := n.shards[idx]
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	user, ok := ns.data[userId]
+	if !ok {
+		user = &userNotifications{}
+		ns.data[userId] = user
+	}
+
+	fn(user)
+	return nil
+}
+
+func filterNotifications(notifications []*model.Notification, unreadOnly bool) []*model.Notification {
+	filtered := make([]*model.Notification, 0, len(notifications))
+	for _, notification := range notifications {
+		if !unreadOnly || notification.ReadAt == nil {
+			filtered = append(filtered, notification)
+		}
+	}
+
+	return filtered
+}
+
+func notificationCursor(notification *model.Notification) Cursor {
+	return Cursor{CreatedAt: notification.CreatedAt, ID: notification.ID}
+}
+
+// purge drops the notifications about the purged comments and everything of
+// the purged users, as the foreign keys of the database do.
+func (n *NotificationStorageInMemory) purge(comments map[string]bool, users map[string]bool) {
+	defer lockShards(n.shards)()
+
+	for _, shard := range n.shards {
+		for userId, user := range shard.data {
+			if users[userId] {
+				delete(shard.data, userId)
+				continue
+			}
+
+			user.notifications = slices.DeleteFunc(user.notifications, func(notification *model.Notification) bool {
+				return comments[notification.CommentID]
+			})
+		}
+	}
+}
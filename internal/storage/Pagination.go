@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// Cursor is a position in a list ordered by (created_at, id). Unlike an
+// offset it stays valid when rows are inserted before it.
+type Cursor struct {
+	CreatedAt string
+	ID        string
+}
+
+// PageQuery selects up to Limit rows strictly between After and Before. When
+// Backward is set the rows closest to Before are taken instead of the ones
+// closest to After. Rows are always returned in ascending order.
+type PageQuery struct {
+	After    *Cursor
+	Before   *Cursor
+	Limit    uint64
+	Backward bool
+}
+
+// applyPage adds keyset conditions to the query, so Postgres walks the
+// (created_at, id) index instead of skipping rows as OFFSET does.
+func applyPage(query *pg.Query, page PageQuery) *pg.Query {
+	if page.After != nil {
+		query = query.Where("(created_at, id) > (?, ?)", page.After.CreatedAt, page.After.ID)
+	}
+
+	if page.Before != nil {
+		query = query.Where("(created_at, id) < (?, ?)", page.Before.CreatedAt, page.Before.ID)
+	}
+
+	if page.Backward {
+		query = query.Order("created_at DESC", "id DESC")
+	} else {
+		query = query.Order("created_at ASC", "id ASC")
+	}
+
+	return query.Limit(int(page.Limit))
+}
+
+// selectPage runs a query built by applyPage and restores ascending order.
+func selectPage[T any](query *pg.Query, rows *[]*T, page PageQuery) error {
+	if err := applyPage(query, page).Select(); err != nil {
+		return mapDbError(err, rows)
+	}
+
+	if page.Backward {
+		reverse(*rows)
+	}
+
+	return nil
+}
+
+// paginate is the in-memory equivalent of applyPage. Timestamps are parsed
+// once per row, rows outside the bounds are dropped before sorting and rows
+// kept in order by an index are not sorted again.
+func paginate[T any](rows []*T, cursor func(*T) Cursor, page PageQuery) []*T {
+	var after, before *sortKey
+	if page.After != nil {
+		key := newSortKey(*page.After)
+		after = &key
+	}
+
+	if page.Before != nil {
+		key := newSortKey(*page.Before)
+		before = &key
+	}
+
+	keyed := make([]keyedRow[T], 0, len(rows))
+	for _, row := range rows {
+		key := newSortKey(cursor(row))
+		if after != nil && key.compare(*after) <= 0 {
+			continue
+		}
+
+		if before != nil && key.compare(*before) >= 0 {
+			continue
+		}
+
+		keyed = append(keyed, keyedRow[T]{key: key, row: row})
+	}
+
+	byKey := func(a, b keyedRow[T]) int {
+		return a.key.compare(b.key)
+	}
+	if !slices.IsSortedFunc(keyed, byKey) {
+		slices.SortFunc(keyed, byKey)
+	}
+
+	if uint64(len(keyed)) > page.Limit {
+		if page.Backward {
+			keyed = keyed[uint64(len(keyed))-page.Limit:]
+		} else {
+			keyed = keyed[:page.Limit]
+		}
+	}
+
+	result := make([]*T, len(keyed))
+	for i, k := range keyed {
+		result[i] = k.row
+	}
+
+	return result
+}
+
+type keyedRow[T any] struct {
+	key sortKey
+	row *T
+}
+
+// sortKey is a cursor parsed for comparison.
+type sortKey struct {
+	createdAt string
+	at        time.Time
+	timed     bool
+	id        string
+	number    uint64
+	numeric   bool
+}
+
+func newSortKey(cursor Cursor) sortKey {
+	key := sortKey{createdAt: cursor.CreatedAt, id: cursor.ID}
+
+	at, err := time.Parse(time.RFC3339Nano, cursor.CreatedAt)
+	key.at, key.timed = at, err == nil
+
+	number, err := strconv.ParseUint(cursor.ID, 10, 64)
+	key.number, key.numeric = number, err == nil
+	return key
+}
+
+func (a sortKey) compare(b sortKey) int {
+	if a.timed && b.timed {
+		if c := a.at.Compare(b.at); c != 0 {
+			return c
+		}
+	} else if c := strings.Compare(a.createdAt, b.createdAt); c != 0 {
+		return c
+	}
+
+	if a.numeric && b.numeric {
+		return cmp.Compare(a.number, b.number)
+	}
+
+	return strings.Compare(a.id, b.id)
+}
+
+// collect gathers the values matching keep from every shard.
+func collect[T any](shards []*StorageInMemoryShard[T], keep func(*T) bool) []*T {
+	var wg sync.WaitGroup
+	mu := &sync.Mutex{}
+	var all []*T
+
+	for _, shard := range shards {
+		wg.Add(1)
+		go func(s *StorageInMemoryShard[T]) {
+			defer wg.Done()
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			var values []*T
+			for _, val := range s.data {
+				if keep(val) {
+					values = append(values, val)
+				}
+			}
+
+			mu.Lock()
+			all = append(all, values...)
+			mu.Unlock()
+		}(shard)
+	}
+
+	wg.Wait()
+	return all
+}
+
+func compareCursors(a Cursor, b Cursor) int {
+	return newSortKey(a).compare(newSortKey(b))
+}
+
+// compareIds orders numeric ids by value, the same way BIGSERIAL keys are
+// ordered in Postgres.
+func compareIds(a string, b string) int {
+	return newSortKey(Cursor{ID: a}).compare(newSortKey(Cursor{ID: b}))
+}
+
+func reverse[T any](rows []*T) {
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+}
@@ -2,7 +2,6 @@ package storage
 
 import (
 	"context"
-	"errors"
 	"sync"
 
 	"github.com/go-pg/pg/v10"
@@ -21,7 +20,7 @@ func NewDbPostStorage(db *pg.DB) PostStorage {
 	}
 }
 
-func (p *PostStorageDb) GetFirstPostsFrom(offset uint64, count uint64, ctx context.Context) ([]*model.Post, error) {
+func (p *PostStorageDb) GetPosts(page PageQuery, ctx context.Context) ([]*model.Post, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -31,14 +30,64 @@ func (p *PostStorageDb) GetFirstPostsFrom(offset uint64, count uint64, ctx conte
 		return nil, err
 	}
 
-	err = query.Where("deleted_at is null").Order("created_at").Limit(int(count)).Offset(int(offset)).Select()
+	if err = selectPage(query.Where("deleted_at is null"), &posts, page); err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+func (p *PostStorageDb) CountPosts(ctx context.Context) (uint64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	query, err := buildQuery(p.db, (*model.Post)(nil), ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := query.Where("deleted_at is null").Count()
 	if err != nil {
+		return 0, mapDbError(err, (*model.Post)(nil))
+	}
+
+	return uint64(count), nil
+}
+
+func (p *PostStorageDb) GetPostsByAuthor(authorId string, page PageQuery, ctx context.Context) ([]*model.Post, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var posts []*model.Post
+	query, err := buildQuery(p.db, &posts, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = selectPage(query.Where("author_id = ?", authorId).Where("deleted_at is null"), &posts, page); err != nil {
 		return nil, err
 	}
 
 	return posts, nil
 }
 
+func (p *PostStorageDb) CountPostsByAuthor(authorId string, ctx context.Context) (uint64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	query, err := buildQuery(p.db, (*model.Post)(nil), ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := query.Where("author_id = ?", authorId).Where("deleted_at is null").Count()
+	if err != nil {
+		return 0, mapDbError(err, (*model.Post)(nil))
+	}
+
+	return uint64(count), nil
+}
+
 func (p *PostStorageDb) GetPostById(postId string, ctx context.Context) (*model.Post, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -50,11 +99,19 @@ func (p *PostStorageDb) GetPostById(postId string, ctx context.Context) (*model.
 		return nil, err
 	}
 
-	if post == nil {
-		return nil, errors.New("no such post")
+	return post, nil
+}
+
+func (p *PostStorageDb) GetPostsByIds(postIds []string, ctx context.Context) ([]*model.Post, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var posts []*model.Post
+	if err := getDataByIds(p.db, &posts, postIds, ctx); err != nil {
+		return nil, err
 	}
 
-	return post, nil
+	return posts, nil
 }
 
 func (p *PostStorageDb) InsertPost(post *model.Post, ctx context.Context) error {
@@ -2,14 +2,12 @@ package storage
 
 import (
 	"context"
-	"errors"
-	"fmt"
-	"sort"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
 	"github.com/k0ch3gar/ozon-task/internal/storage/model"
 )
 
@@ -19,7 +17,7 @@ type PostStorageInMemory struct {
 	lastId     uint64
 }
 
-func NewInMemoryPostStorage(params config.ApplicationParameters) PostStorage {
+func NewInMemoryPostStorage(params config.ApplicationParameters) *PostStorageInMemory {
 	shards := make([]*StorageInMemoryShard[model.Post], params.StorageShardsCount)
 	for i := range shards {
 		shards[i] = &StorageInMemoryShard[model.Post]{}
@@ -34,57 +32,34 @@ func NewInMemoryPostStorage(params config.ApplicationParameters) PostStorage {
 	}
 }
 
-func (p *PostStorageInMemory) GetFirstPostsFrom(offset uint64, count uint64, ctx context.Context) ([]*model.Post, error) {
-	var wg sync.WaitGroup
-	mu := &sync.Mutex{}
-	var allPosts []*model.Post
-
-	for _, shard := range p.shards {
-		wg.Add(1)
-		go func(ps *StorageInMemoryShard[model.Post]) {
-			defer wg.Done()
-
-			ps.mu.Lock()
-			defer ps.mu.Unlock()
-
-			var posts []*model.Post
-			for _, val := range ps.data {
-				posts = append(posts, val)
-			}
-
-			mu.Lock()
-			allPosts = append(allPosts, posts...)
-			mu.Unlock()
-		}(shard)
-	}
-
-	wg.Wait()
-
-	sort.Slice(allPosts, func(i, j int) bool {
+func (p *PostStorageInMemory) GetPosts(page PageQuery, ctx context.Context) ([]*model.Post, error) {
+	return paginate(collect(p.shards, isAlivePost), postCursor, page), nil
+}
 
-		t1, err := time.Parse(time.RFC3339, allPosts[i].CreatedAt)
-		if err != nil {
-			panic(err)
-		}
+func (p *PostStorageInMemory) CountPosts(ctx context.Context) (uint64, error) {
+	return uint64(len(collect(p.shards, isAlivePost))), nil
+}
 
-		t2, _ := time.Parse(time.RFC3339, allPosts[j].CreatedAt)
-		if err != nil {
-			panic(err)
-		}
+func (p *PostStorageInMemory) GetPostsByAuthor(authorId string, page PageQuery, ctx context.Context) ([]*model.Post, error) {
+	return paginate(collect(p.shards, isAuthorPost(authorId)), postCursor, page), nil
+}
 
-		return t1.Before(t2)
-	})
+func (p *PostStorageInMemory) CountPostsByAuthor(authorId string, ctx context.Context) (uint64, error) {
+	return uint64(len(collect(p.shards, isAuthorPost(authorId)))), nil
+}
 
-	if offset >= uint64(len(allPosts)) {
-		return []*model.Post{}, nil
-	}
+func isAlivePost(post *model.Post) bool {
+	return post.DeletedAt == nil
+}
 
-	end := offset + count
-	if end > uint64(len(allPosts)) {
-		end = uint64(len(allPosts))
+func isAuthorPost(authorId string) func(*model.Post) bool {
+	return func(post *model.Post) bool {
+		return post.DeletedAt == nil && post.AuthorID != nil && *post.AuthorID == authorId
 	}
+}
 
-	return allPosts[offset:end], nil
+func postCursor(post *model.Post) Cursor {
+	return Cursor{CreatedAt: post.CreatedAt, ID: post.ID}
 }
 
 func (p *PostStorageInMemory) GetPostById(postId string, ctx context.Context) (*model.Post, error) {
@@ -99,7 +74,7 @@ func (p *PostStorageInMemory) GetPostById(postId string, ctx context.Context) (*
 
 	post, ok := ps.data[postId]
 	if !ok {
-		return nil, errors.New("no such post")
+		return nil, errs.NotFound("no such post")
 	}
 
 	if err = p.ValidatePostExistence(ps.data[postId]); err != nil {
@@ -109,6 +84,10 @@ func (p *PostStorageInMemory) GetPostById(postId string, ctx context.Context) (*
 	return post, nil
 }
 
+func (p *PostStorageInMemory) GetPostsByIds(postIds []string, ctx context.Context) ([]*model.Post, error) {
+	return getByIds(p.shards, p.shardCount, postIds)
+}
+
 func (p *PostStorageInMemory) InsertPost(post *model.Post, ctx context.Context) error {
 	id := strconv.FormatUint(p.lastId, 10)
 	idx, err := getStorageShardIdx(p.shards, p.shardCount, id)
@@ -122,11 +101,11 @@ func (p *PostStorageInMemory) InsertPost(post *model.Post, ctx context.Context)
 
 	_, ok := ps.data[id]
 	if ok {
-		return errors.New("such post already exists")
+		return errs.Conflict("such post already exists")
 	}
 
 	post.ID = id
-	post.CreatedAt = time.Now().Format(time.RFC3339)
+	post.CreatedAt = time.Now().Format(time.RFC3339Nano)
 
 	ps.data[id] = post
 	p.lastId++
@@ -145,7 +124,7 @@ func (p *PostStorageInMemory) UpdatePost(newPost *model.Post, ctx context.Contex
 
 	_, ok := ps.data[newPost.ID]
 	if !ok {
-		return errors.New(fmt.Sprintf("no such post with id: %s", newPost.ID))
+		return errs.NotFound("no such post with id: %s", newPost.ID)
 	}
 
 	if err = p.ValidatePostExistence(ps.data[newPost.ID]); err != nil {
@@ -158,7 +137,7 @@ func (p *PostStorageInMemory) UpdatePost(newPost *model.Post, ctx context.Contex
 
 func (p *PostStorageInMemory) ValidatePostExistence(post *model.Post) error {
 	if post.DeletedAt != nil {
-		return errors.New(fmt.Sprintf("post with this id is deleted: %s", post.ID))
+		return errs.Deleted("post with this id is deleted: %s", post.ID)
 	}
 
 	return nil
@@ -176,11 +155,11 @@ func (p *PostStorageInMemory) DeletePost(postId string, ctx context.Context) err
 
 	_, ok := ps.data[postId]
 	if !ok {
-		return errors.New(fmt.Sprintf("no such post with id: %s", postId))
+		return errs.NotFound("no such post with id: %s", postId)
 	}
 
 	if ps.data[postId].DeletedAt != nil {
-		return errors.New(fmt.Sprintf("post with this id is already deleted: %s", postId))
+		return errs.Deleted("post with this id is already deleted: %s", postId)
 	}
 
 	deletionTime := time.Now().Format(time.RFC3339)
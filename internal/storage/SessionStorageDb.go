@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+)
+
+type SessionStorageDb struct {
+	mu sync.Mutex
+	db *pg.DB
+}
+
+func NewDbSessionStorage(db *pg.DB) SessionStorage {
+	return &SessionStorageDb{
+		db: db,
+		mu: sync.Mutex{},
+	}
+}
+
+func (s *SessionStorageDb) GetSessionById(sessionId string, ctx context.Context) (*model.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := &model.Session{
+		ID: sessionId,
+	}
+	query, err := buildQuery(s.db, session, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = query.WherePK().Where("revoked_at is null").Select(); err != nil {
+		return nil, mapDbError(err, session)
+	}
+
+	return session, nil
+}
+
+func (s *SessionStorageDb) InsertSession(session *model.Session, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return insertData(s.db, session, ctx)
+}
+
+func (s *SessionStorageDb) UpdateSession(newSession *model.Session, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return updateData(s.db, newSession, ctx)
+}
+
+func (s *SessionStorageDb) RevokeSession(sessionId string, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query, err := buildQuery(s.db, &model.Session{ID: sessionId}, ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = query.Set("revoked_at = NOW()").WherePK().Where("revoked_at is null").Update()
+	return mapDbError(err, &model.Session{})
+}
+
+func (s *SessionStorageDb) RevokeUserSessions(userId string, exceptSessionId string, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query, err := buildQuery(s.db, (*model.Session)(nil), ctx)
+	if err != nil {
+		return err
+	}
+
+	query = query.Set("revoked_at = NOW()").Where("user_id = ?", userId).Where("revoked_at is null")
+	if exceptSessionId != "" {
+		query = query.Where("id != ?", exceptSessionId)
+	}
+
+	_, err = query.Update()
+	return mapDbError(err, &model.Session{})
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+)
+
+type SessionStorageInMemory struct {
+	shards     []*StorageInMemoryShard[model.Session]
+	shardCount uint64
+	lastId     uint64
+}
+
+func NewInMemorySessionStorage(params config.ApplicationParameters) SessionStorage {
+	shards := make([]*StorageInMemoryShard[model.Session], params.StorageShardsCount)
+	for i := range shards {
+		shards[i] = &StorageInMemoryShard[model.Session]{}
+		shards[i].mu = sync.Mutex{}
+		shards[i].data = make(map[string]*model.Session)
+	}
+
+	return &SessionStorageInMemory{
+		shardCount: params.StorageShardsCount,
+		shards:     shards,
+		lastId:     0,
+	}
+}
+
+func (s *SessionStorageInMemory) GetSessionById(sessionId string, ctx context.Context) (*model.Session, error) {
+	idx, err := getStorageShardIdx(s.shards, s.shardCount, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := s.shards[idx]
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	session, ok := ss.data[sessionId]
+	if !ok {
+		return nil, errs.NotFound("no such session")
+	}
+
+	if err = s.ValidateSessionExistence(session); err != nil {
+		return nil, err
+	}
+
+	copied := *session
+	return &copied, nil
+}
+
+func (s *SessionStorageInMemory) ValidateSessionExistence(session *model.Session) error {
+	if session.RevokedAt != nil {
+		return errs.Deleted("session with this id is revoked: %s", session.ID)
+	}
+
+	return nil
+}
+
+func (s *SessionStorageInMemory) InsertSession(session *model.Session, ctx context.Context) error {
+	id := strconv.FormatUint(atomic.AddUint64(&s.lastId, 1)-1, 10)
+	idx, err := getStorageShardIdx(s.shards, s.shardCount, id)
+	if err != nil {
+		return err
+	}
+
+	ss := s.shards[idx]
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, ok := ss.data[id]; ok {
+		return errs.Conflict("such session already exists")
+	}
+
+	session.ID = id
+	session.CreatedAt = time.Now().Format(time.RFC3339)
+
+	copied := *session
+	ss.data[id] = &copied
+	return nil
+}
+
+func (s *SessionStorageInMemory) UpdateSession(newSession *model.Session, ctx context.Context) error {
+	idx, err := getStorageShardIdx(s.shards, s.shardCount, newSession.ID)
+	if err != nil {
+		return err
+	}
+
+	ss := s.shards[idx]
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	session, ok := ss.data[newSession.ID]
+	if !ok {
+		return errs.NotFound("no such session with id: %s", newSession.ID)
+	}
+
+	if err = s.ValidateSessionExistence(session); err != nil {
+		return err
+	}
+
+	copied := *newSession
+	ss.data[newSession.ID] = &copied
+	return nil
+}
+
+func (s *SessionStorageInMemory) RevokeSession(sessionId string, ctx context.Context) error {
+	idx, err := getStorageShardIdx(s.shards, s.shardCount, sessionId)
+	if err != nil {
+		return err
+	}
+
+	ss := s.shards[idx]
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	session, ok := ss.data[sessionId]
+	if !ok {
+		return errs.NotFound("no such session with id: %s", sessionId)
+	}
+
+	if err = s.ValidateSessionExistence(session); err != nil {
+		return err
+	}
+
+	revocationTime := time.Now().Format(time.RFC3339)
+	session.RevokedAt = &revocationTime
+	return nil
+}
+
+func (s *SessionStorageInMemory) RevokeUserSessions(userId string, exceptSessionId string, ctx context.Context) error {
+	unlock := lockShards(s.shards)
+	defer unlock()
+
+	revocationTime := time.Now().Format(time.RFC3339)
+	update(s.shards, func(session *model.Session) bool {
+		if session.UserID != userId || session.ID == exceptSessionId || session.RevokedAt != nil {
+			return false
+		}
+
+		session.RevokedAt = &revocationTime
+		return true
+	})
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/go-pg/pg/v10"
 	"github.com/k0ch3gar/ozon-task/internal/config"
@@ -15,17 +16,27 @@ import (
 
 type UserStorage interface {
 	GetUserById(userId string, ctx context.Context) (*model.User, error)
+	// GetUsersByIds returns the users found among userIds in no particular
+	// order. Deleted users are returned as well, missing ones are skipped.
+	GetUsersByIds(userIds []string, ctx context.Context) ([]*model.User, error)
 	InsertUser(user *model.User, ctx context.Context) error
 	UpdateUser(newUser *model.User, ctx context.Context) error
 	DeleteUser(userId string, ctx context.Context) (*model.User, error)
 	ContainsByUsername(username string, ctx context.Context) (bool, error)
 	ContainsById(userId string, ctx context.Context) (bool, error)
+	ContainsByEmail(email string, ctx context.Context) (bool, error)
 	GetUserByName(username string, ctx context.Context) (*model.User, error)
+	GetUserByEmail(email string, ctx context.Context) (*model.User, error)
 }
 
 type PostStorage interface {
-	GetFirstPostsFrom(offset uint64, count uint64, ctx context.Context) ([]*model.Post, error)
+	GetPosts(page PageQuery, ctx context.Context) ([]*model.Post, error)
+	CountPosts(ctx context.Context) (uint64, error)
+	GetPostsByAuthor(authorId string, page PageQuery, ctx context.Context) ([]*model.Post, error)
+	CountPostsByAuthor(authorId string, ctx context.Context) (uint64, error)
 	GetPostById(postId string, ctx context.Context) (*model.Post, error)
+	// GetPostsByIds behaves like UserStorage.GetUsersByIds.
+	GetPostsByIds(postIds []string, ctx context.Context) ([]*model.Post, error)
 	InsertPost(post *model.Post, ctx context.Context) error
 	UpdatePost(newPost *model.Post, ctx context.Context) error
 	DeletePost(postId string, ctx context.Context) error
@@ -33,11 +44,110 @@ type PostStorage interface {
 
 type CommentStorage interface {
 	GetCommentById(commentId string, ctx context.Context) (*model.Comment, error)
-	GetFirstCommentsByPost(postId string, offset, count uint64, ctx context.Context) ([]*model.Comment, error)
-	GetFirstCommentsByComment(commentId string, offset, count uint64, ctx context.Context) ([]*model.Comment, error)
+	// GetCommentsByIds behaves like UserStorage.GetUsersByIds.
+	GetCommentsByIds(commentIds []string, ctx context.Context) ([]*model.Comment, error)
+	GetCommentsByPost(postId string, page PageQuery, ctx context.Context) ([]*model.Comment, error)
+	CountCommentsByPost(postId string, ctx context.Context) (uint64, error)
+	GetCommentsByComment(commentId string, page PageQuery, ctx context.Context) ([]*model.Comment, error)
+	CountCommentsByComment(commentId string, ctx context.Context) (uint64, error)
+	GetCommentThread(postId string, query ThreadQuery, ctx context.Context) (*Thread, error)
+	GetCommentsByAuthor(authorId string, page PageQuery, ctx context.Context) ([]*model.Comment, error)
+	CountCommentsByAuthor(authorId string, ctx context.Context) (uint64, error)
 	InsertComment(comment *model.Comment, ctx context.Context) error
 	UpdateComment(newComment *model.Comment, ctx context.Context) error
-	DeleteComment(commentId string, ctx context.Context) error
+	// DeleteComment records deletedBy as the user who deleted the comment.
+	DeleteComment(commentId string, deletedBy string, ctx context.Context) error
+}
+
+// LifecycleStorage deletes an entity together with the content depending on
+// it. Either the whole cascade is applied or none of it.
+type LifecycleStorage interface {
+	// DeletePost deletes the post and all of its comments on behalf of
+	// deletedBy, the comments are returned.
+	DeletePost(postId string, deletedBy string, ctx context.Context) (*Cascade, error)
+	// DeleteUser deletes the user on behalf of deletedBy and handles their
+	// posts and comments according to the policy, the content it deletes is
+	// returned.
+	DeleteUser(userId string, deletedBy string, policy config.UserContentPolicy, ctx context.Context) (*model.User, *Cascade, error)
+	// RestorePost brings back the post and the comments deleted together
+	// with it, if the post was deleted after deletedAfter.
+	RestorePost(postId string, deletedAfter time.Time, ctx context.Context) (*model.Post, error)
+	// RestoreComment brings back a comment deleted after deletedAfter, its
+	// post must not be deleted.
+	RestoreComment(commentId string, deletedAfter time.Time, ctx context.Context) (*model.Comment, error)
+	// RestoreUser brings back the user and the content removed together with
+	// them. Anonymized content stays anonymous.
+	RestoreUser(userId string, deletedAfter time.Time, ctx context.Context) (*model.User, error)
+	// Purge permanently removes everything deleted before deletedBefore.
+	// Comments stay as long as some of their replies stay.
+	Purge(deletedBefore time.Time, ctx context.Context) (PurgeResult, error)
+}
+
+// Cascade is the content deleted together with its owner, as it was right
+// after the deletion.
+type Cascade struct {
+	Posts    []*model.Post
+	Comments []*model.Comment
+}
+
+// PurgeResult counts the rows removed by a purge.
+type PurgeResult struct {
+	Users    uint64
+	Posts    uint64
+	Comments uint64
+}
+
+// NotificationStorage keeps the inbox and the notification preferences of
+// every user.
+type NotificationStorage interface {
+	InsertNotifications(notifications []*model.Notification, ctx context.Context) error
+	GetNotificationsByUser(userId string, unreadOnly bool, page PageQuery, ctx context.Context) ([]*model.Notification, error)
+	CountNotificationsByUser(userId string, unreadOnly bool, ctx context.Context) (uint64, error)
+	// MarkNotificationsRead marks the notifications of the user among
+	// notificationIds as read and returns them, other ids are skipped.
+	MarkNotificationsRead(userId string, notificationIds []string, ctx context.Context) ([]*model.Notification, error)
+	// GetPreferences returns the defaults for users who never changed them.
+	GetPreferences(userId string, ctx context.Context) (*model.NotificationPreferences, error)
+	UpdatePreferences(preferences *model.NotificationPreferences, ctx context.Context) error
+}
+
+// WebhookStorage keeps the registered webhooks and their deliveries.
+type WebhookStorage interface {
+	InsertWebhook(webhook *model.Webhook, ctx context.Context) error
+	GetWebhookById(webhookId string, ctx context.Context) (*model.Webhook, error)
+	GetWebhooks(ctx context.Context) ([]*model.Webhook, error)
+	// GetWebhooksByEvent returns the webhooks subscribed to the event.
+	GetWebhooksByEvent(event string, ctx context.Context) ([]*model.Webhook, error)
+	// DeleteWebhook removes the webhook together with its deliveries.
+	DeleteWebhook(webhookId string, ctx context.Context) error
+	InsertDeliveries(deliveries []*model.WebhookDelivery, ctx context.Context) error
+	// ClaimDeliveries takes up to limit pending deliveries due at now and
+	// postpones them by lease, so no other worker takes them meanwhile.
+	ClaimDeliveries(now time.Time, lease time.Duration, limit int, ctx context.Context) ([]*model.WebhookDelivery, error)
+	GetDeliveryById(deliveryId string, ctx context.Context) (*model.WebhookDelivery, error)
+	GetDeliveries(filter DeliveryFilter, page PageQuery, ctx context.Context) ([]*model.WebhookDelivery, error)
+	CountDeliveries(filter DeliveryFilter, ctx context.Context) (uint64, error)
+	UpdateDelivery(newDelivery *model.WebhookDelivery, ctx context.Context) error
+	// RetryDelivery queues a dead delivery again from its first attempt, a
+	// delivery in any other state is a conflict.
+	RetryDelivery(deliveryId string, now time.Time, ctx context.Context) (*model.WebhookDelivery, error)
+}
+
+// DeliveryFilter narrows deliveries down to a webhook or a status, unset
+// fields match everything.
+type DeliveryFilter struct {
+	WebhookID *string
+	Status    *string
+}
+
+type SessionStorage interface {
+	GetSessionById(sessionId string, ctx context.Context) (*model.Session, error)
+	InsertSession(session *model.Session, ctx context.Context) error
+	UpdateSession(newSession *model.Session, ctx context.Context) error
+	RevokeSession(sessionId string, ctx context.Context) error
+	// RevokeUserSessions revokes every session of the user but
+	// exceptSessionId, an empty exceptSessionId revokes them all.
+	RevokeUserSessions(userId string, exceptSessionId string, ctx context.Context) error
 }
 
 type StorageInMemoryShard[T any] struct {
@@ -73,15 +183,25 @@ func NewStorageModule(params config.ApplicationParameters) fx.Option {
 				NewDbUserStorage,
 				NewDbPostStorage,
 				NewDbCommentStorage,
+				NewDbSessionStorage,
+				NewDbLifecycleStorage,
+				NewDbNotificationStorage,
+				NewDbWebhookStorage,
 			),
 		)
 	} else {
 		return fx.Module(
 			`storage`,
 			fx.Provide(
-				NewInMemoryUserStorage,
-				NewInMemoryPostStorage,
-				NewInMemoryCommentStorage,
+				// The lifecycle storage works on the in-memory types
+				// themselves, the services get the interfaces.
+				fx.Annotate(NewInMemoryUserStorage, fx.As(fx.Self()), fx.As(new(UserStorage))),
+				fx.Annotate(NewInMemoryPostStorage, fx.As(fx.Self()), fx.As(new(PostStorage))),
+				fx.Annotate(NewInMemoryCommentStorage, fx.As(fx.Self()), fx.As(new(CommentStorage))),
+				fx.Annotate(NewInMemoryNotificationStorage, fx.As(fx.Self()), fx.As(new(NotificationStorage))),
+				NewInMemorySessionStorage,
+				NewInMemoryLifecycleStorage,
+				NewInMemoryWebhookStorage,
 			),
 		)
 	}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+)
+
+// ThreadQuery selects the top level comments of a post after After and their
+// replies down to MaxDepth, with at most MaxChildren comments per parent.
+// Top level comments have depth 0.
+type ThreadQuery struct {
+	After       *Cursor
+	MaxDepth    uint64
+	MaxChildren uint64
+}
+
+type ThreadNode struct {
+	Comment *model.Comment
+	Depth   uint64
+	// Path holds the ids from the top level comment down to this one.
+	Path []string
+	// HasMoreChildren is set when some replies were cut off by either limit.
+	HasMoreChildren bool
+}
+
+// Thread lists the nodes depth first, every node is followed by its replies.
+type Thread struct {
+	Nodes        []*ThreadNode
+	HasMoreRoots bool
+}
+
+// threadRow is a comment selected while walking the tree. Up to MaxChildren+1
+// rows are selected per parent, the extra one only tells that more exist.
+type threadRow struct {
+	model.Comment
+	Rank        uint64
+	HasChildren bool
+}
+
+// buildThread drops the extra rows and orders the rest depth first. Replies
+// to the same parent must come in (created_at, id) order.
+func buildThread(rows []*threadRow, query ThreadQuery) *Thread {
+	thread := &Thread{Nodes: []*ThreadNode{}}
+	children := make(map[string][]*threadRow)
+	var roots []*threadRow
+
+	for _, row := range rows {
+		if row.ParentCommentID == nil {
+			roots = append(roots, row)
+			continue
+		}
+
+		children[*row.ParentCommentID] = append(children[*row.ParentCommentID], row)
+	}
+
+	var walk func(rows []*threadRow)
+	walk = func(rows []*threadRow) {
+		for _, row := range rows {
+			if row.Rank > query.MaxChildren {
+				continue
+			}
+
+			replies := children[row.ID]
+			thread.Nodes = append(thread.Nodes, &ThreadNode{
+				Comment:         &row.Comment,
+				Depth:           row.Depth,
+				Path:            PathIds(row.Path),
+				HasMoreChildren: row.HasChildren && (row.Depth >= query.MaxDepth || uint64(len(replies)) > query.MaxChildren),
+			})
+
+			walk(replies)
+		}
+	}
+
+	walk(roots)
+	thread.HasMoreRoots = uint64(len(roots)) > query.MaxChildren
+	return thread
+}
+
+// commentPath appends the comment id to the path of its parent, ids are zero
+// padded to the width of BIGINT so paths sort the same way as the ids.
+func commentPath(parentPath string, commentId string) string {
+	segment := commentId
+	if len(segment) < pathSegmentWidth {
+		segment = strings.Repeat("0", pathSegmentWidth-len(segment)) + segment
+	}
+
+	if parentPath == "" {
+		return segment
+	}
+
+	return parentPath + pathSeparator + segment
+}
+
+// PathIds splits a comment path back into the ids it was built from.
+func PathIds(path string) []string {
+	if path == "" {
+		return []string{}
+	}
+
+	segments := strings.Split(path, pathSeparator)
+	for i, segment := range segments {
+		if id := strings.TrimLeft(segment, "0"); id != "" {
+			segments[i] = id
+		} else {
+			segments[i] = "0"
+		}
+	}
+
+	return segments
+}
+
+const (
+	pathSegmentWidth = 19
+	pathSeparator    = "."
+)
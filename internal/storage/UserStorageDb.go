@@ -3,9 +3,11 @@ package storage
 import (
 	"context"
 	"errors"
+	"strings"
 	"sync"
 
 	"github.com/go-pg/pg/v10"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
 	"github.com/k0ch3gar/ozon-task/internal/storage/model"
 )
 
@@ -21,12 +23,22 @@ func (u *UserStorageDb) GetUserByName(username string, ctx context.Context) (*mo
 	user := &model.User{
 		Username: username,
 	}
-	if err := getDataByUniqueColumn(u.db, user, "username", username, ctx); err != nil {
-		if errors.Is(err, pg.ErrNoRows) {
-			return nil, errors.New("no such user")
-		} else {
-			return nil, err
-		}
+	if err := getDataByUniqueColumn(u.db, user, "lower(username)", strings.ToLower(username), ctx); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (u *UserStorageDb) GetUserByEmail(email string, ctx context.Context) (*model.User, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user := &model.User{
+		Email: email,
+	}
+	if err := getDataByUniqueColumn(u.db, user, "lower(email)", strings.ToLower(email), ctx); err != nil {
+		return nil, err
 	}
 
 	return user, nil
@@ -39,8 +51,26 @@ func (u *UserStorageDb) ContainsByUsername(username string, ctx context.Context)
 	user := &model.User{
 		Username: username,
 	}
-	if err := getDataByUniqueColumn(u.db, user, "username", user.Username, ctx); err != nil {
-		if errors.Is(err, pg.ErrNoRows) {
+	if err := getDataByUniqueColumn(u.db, user, "lower(username)", strings.ToLower(user.Username), ctx); err != nil {
+		if errors.Is(err, errs.ErrNotFound) {
+			return false, nil
+		} else {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (u *UserStorageDb) ContainsByEmail(email string, ctx context.Context) (bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user := &model.User{
+		Email: email,
+	}
+	if err := getDataByUniqueColumn(u.db, user, "lower(email)", strings.ToLower(user.Email), ctx); err != nil {
+		if errors.Is(err, errs.ErrNotFound) {
 			return false, nil
 		} else {
 			return false, err
@@ -58,7 +88,7 @@ func (u *UserStorageDb) ContainsById(userId string, ctx context.Context) (bool,
 		ID: userId,
 	}
 	if err := getDataById(u.db, user, ctx); err != nil {
-		if errors.Is(err, pg.ErrNoRows) {
+		if errors.Is(err, errs.ErrNotFound) {
 			return false, nil
 		} else {
 			return false, err
@@ -83,16 +113,24 @@ func (u *UserStorageDb) GetUserById(userId string, ctx context.Context) (*model.
 		ID: userId,
 	}
 	if err := getDataById(u.db, user, ctx); err != nil {
-		if errors.Is(err, pg.ErrNoRows) {
-			return nil, errors.New("no such user")
-		} else {
-			return nil, err
-		}
+		return nil, err
 	}
 
 	return user, nil
 }
 
+func (u *UserStorageDb) GetUsersByIds(userIds []string, ctx context.Context) ([]*model.User, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var users []*model.User
+	if err := getDataByIds(u.db, &users, userIds, ctx); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
 func (u *UserStorageDb) InsertUser(user *model.User, ctx context.Context) error {
 	u.mu.Lock()
 	defer u.mu.Unlock()
@@ -115,11 +153,7 @@ func (u *UserStorageDb) DeleteUser(userId string, ctx context.Context) (*model.U
 		ID: userId,
 	}
 	if err := getDataById(u.db, user, ctx); err != nil {
-		if errors.Is(err, pg.ErrNoRows) {
-			return nil, errors.New("no such user")
-		} else {
-			return nil, err
-		}
+		return nil, err
 	}
 
 	return user, deleteData(u.db, user, ctx)
@@ -2,24 +2,28 @@ package storage
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
 	"github.com/k0ch3gar/ozon-task/internal/storage/model"
 )
 
+// UserStorageInMemory keeps users in id shards plus username and email
+// indexes. Index keys are case-folded, matching the lower() unique indexes of
+// the users table.
 type UserStorageInMemory struct {
 	idShards      []*StorageInMemoryShard[model.User]
 	usernameShard []*StorageInMemoryShard[model.User]
+	emailShard    []*StorageInMemoryShard[model.User]
 	shardCount    uint64
 	lastId        uint64
 }
 
-func NewInMemoryUserStorage(params config.ApplicationParameters) UserStorage {
+func NewInMemoryUserStorage(params config.ApplicationParameters) *UserStorageInMemory {
 	shards := make([]*StorageInMemoryShard[model.User], params.StorageShardsCount)
 	for i, _ := range shards {
 		shards[i] = &StorageInMemoryShard[model.User]{}
@@ -34,9 +38,17 @@ func NewInMemoryUserStorage(params config.ApplicationParameters) UserStorage {
 		usernameShards[i].data = make(map[string]*model.User)
 	}
 
+	emailShards := make([]*StorageInMemoryShard[model.User], params.StorageShardsCount)
+	for i := range emailShards {
+		emailShards[i] = &StorageInMemoryShard[model.User]{}
+		emailShards[i].mu = sync.Mutex{}
+		emailShards[i].data = make(map[string]*model.User)
+	}
+
 	return &UserStorageInMemory{
 		shardCount:    params.StorageShardsCount,
 		usernameShard: usernameShards,
+		emailShard:    emailShards,
 		idShards:      shards,
 		lastId:        0,
 	}
@@ -54,7 +66,7 @@ func (us *UserStorageInMemory) GetUserById(userId string, ctx context.Context) (
 
 	user, ok := uss.data[userId]
 	if !ok {
-		return nil, errors.New("no such user")
+		return nil, errs.NotFound("no such user")
 	}
 
 	if err = us.ValidateUserExistence(uss.data[userId]); err != nil {
@@ -64,22 +76,35 @@ func (us *UserStorageInMemory) GetUserById(userId string, ctx context.Context) (
 	return user, nil
 }
 
+func (us *UserStorageInMemory) GetUsersByIds(userIds []string, ctx context.Context) ([]*model.User, error) {
+	return getByIds(us.idShards, us.shardCount, userIds)
+}
+
 func (us *UserStorageInMemory) GetUserByName(username string, ctx context.Context) (*model.User, error) {
-	idx, err := getStorageShardIdx(us.usernameShard, us.shardCount, username)
+	return us.getIndexed(us.usernameShard, username)
+}
+
+func (us *UserStorageInMemory) GetUserByEmail(email string, ctx context.Context) (*model.User, error) {
+	return us.getIndexed(us.emailShard, email)
+}
+
+func (us *UserStorageInMemory) getIndexed(index []*StorageInMemoryShard[model.User], key string) (*model.User, error) {
+	key = foldKey(key)
+	idx, err := getStorageShardIdx(index, us.shardCount, key)
 	if err != nil {
 		return nil, err
 	}
 
-	usn := us.usernameShard[idx]
+	usn := index[idx]
 	usn.mu.Lock()
 	defer usn.mu.Unlock()
 
-	user, ok := usn.data[username]
+	user, ok := usn.data[key]
 	if !ok {
-		return nil, errors.New("no such user")
+		return nil, errs.NotFound("no such user")
 	}
 
-	if err = us.ValidateUserExistence(usn.data[username]); err != nil {
+	if err = us.ValidateUserExistence(user); err != nil {
 		return nil, err
 	}
 
@@ -108,21 +133,30 @@ func (us *UserStorageInMemory) ContainsById(userId string, ctx context.Context)
 }
 
 func (us *UserStorageInMemory) ContainsByUsername(username string, ctx context.Context) (bool, error) {
-	idx, err := getStorageShardIdx(us.usernameShard, us.shardCount, username)
+	return us.containsIndexed(us.usernameShard, username)
+}
+
+func (us *UserStorageInMemory) ContainsByEmail(email string, ctx context.Context) (bool, error) {
+	return us.containsIndexed(us.emailShard, email)
+}
+
+func (us *UserStorageInMemory) containsIndexed(index []*StorageInMemoryShard[model.User], key string) (bool, error) {
+	key = foldKey(key)
+	idx, err := getStorageShardIdx(index, us.shardCount, key)
 	if err != nil {
 		return false, err
 	}
 
-	uss := us.usernameShard[idx]
+	uss := index[idx]
 	uss.mu.Lock()
 	defer uss.mu.Unlock()
 
-	_, ok := uss.data[username]
+	user, ok := uss.data[key]
 	if !ok {
 		return false, nil
 	}
 
-	if err = us.ValidateUserExistence(uss.data[username]); err != nil {
+	if err = us.ValidateUserExistence(user); err != nil {
 		return false, err
 	}
 
@@ -130,12 +164,6 @@ func (us *UserStorageInMemory) ContainsByUsername(username string, ctx context.C
 }
 
 func (us *UserStorageInMemory) InsertUser(user *model.User, ctx context.Context) error {
-	if ok, err := us.ContainsByUsername(user.Username, ctx); err != nil {
-		return err
-	} else if ok {
-		return errors.New("user already exists")
-	}
-
 	id := strconv.FormatUint(us.lastId, 10)
 	idx, err := getStorageShardIdx(us.idShards, us.shardCount, id)
 	if err != nil {
@@ -146,25 +174,27 @@ func (us *UserStorageInMemory) InsertUser(user *model.User, ctx context.Context)
 	uss.mu.Lock()
 	defer uss.mu.Unlock()
 
-	_, ok := uss.data[user.ID]
+	_, ok := uss.data[id]
 	if ok {
-		return errors.New("such user exists")
+		return errs.Conflict("such user already exists")
 	}
 
 	user.CreatedAt = time.Now().Format(time.RFC3339)
 	user.ID = id
+	if user.Role == "" {
+		user.Role = model.RoleUser
+	}
 
-	uss.data[user.ID] = user
-	idx, err = getStorageShardIdx(us.usernameShard, us.shardCount, user.Username)
-	if err != nil {
+	if err = us.putIndexed(us.usernameShard, user.Username, user, "username"); err != nil {
 		return err
 	}
 
-	usn := us.usernameShard[idx]
-	usn.mu.Lock()
-	defer usn.mu.Unlock()
+	if err = us.putIndexed(us.emailShard, user.Email, user, "email"); err != nil {
+		_ = us.removeIndexed(us.usernameShard, user.Username, user.ID)
+		return err
+	}
 
-	usn.data[user.Username] = user
+	uss.data[user.ID] = user
 	us.lastId++
 	return nil
 }
@@ -179,22 +209,87 @@ func (us *UserStorageInMemory) UpdateUser(newUser *model.User, ctx context.Conte
 	uss.mu.Lock()
 	defer uss.mu.Unlock()
 
-	_, ok := uss.data[newUser.ID]
+	oldUser, ok := uss.data[newUser.ID]
 	if !ok {
-		return errors.New(fmt.Sprintf("no such user with id: %s", newUser.ID))
+		return errs.NotFound("no such user with id: %s", newUser.ID)
+	}
+
+	if err = us.ValidateUserExistence(oldUser); err != nil {
+		return err
+	}
+
+	// New index keys are claimed first, so a taken username or email leaves
+	// every index untouched.
+	if err = us.putIndexed(us.usernameShard, newUser.Username, newUser, "username"); err != nil {
+		return err
 	}
 
-	if err = us.ValidateUserExistence(uss.data[newUser.ID]); err != nil {
+	if err = us.putIndexed(us.emailShard, newUser.Email, newUser, "email"); err != nil {
+		if foldKey(oldUser.Username) != foldKey(newUser.Username) {
+			_ = us.removeIndexed(us.usernameShard, newUser.Username, newUser.ID)
+		} else {
+			_ = us.putIndexed(us.usernameShard, oldUser.Username, oldUser, "username")
+		}
+
 		return err
 	}
 
+	if foldKey(oldUser.Username) != foldKey(newUser.Username) {
+		if err = us.removeIndexed(us.usernameShard, oldUser.Username, oldUser.ID); err != nil {
+			return err
+		}
+	}
+
+	if foldKey(oldUser.Email) != foldKey(newUser.Email) {
+		if err = us.removeIndexed(us.emailShard, oldUser.Email, oldUser.ID); err != nil {
+			return err
+		}
+	}
+
 	uss.data[newUser.ID] = newUser
 	return nil
 }
 
+func (us *UserStorageInMemory) putIndexed(index []*StorageInMemoryShard[model.User], key string, user *model.User, column string) error {
+	key = foldKey(key)
+	idx, err := getStorageShardIdx(index, us.shardCount, key)
+	if err != nil {
+		return err
+	}
+
+	usn := index[idx]
+	usn.mu.Lock()
+	defer usn.mu.Unlock()
+
+	if existing, ok := usn.data[key]; ok && existing.ID != user.ID {
+		return errs.Conflict("%s is already taken: %s", column, key)
+	}
+
+	usn.data[key] = user
+	return nil
+}
+
+func (us *UserStorageInMemory) removeIndexed(index []*StorageInMemoryShard[model.User], key string, userId string) error {
+	key = foldKey(key)
+	idx, err := getStorageShardIdx(index, us.shardCount, key)
+	if err != nil {
+		return err
+	}
+
+	usn := index[idx]
+	usn.mu.Lock()
+	defer usn.mu.Unlock()
+
+	if existing, ok := usn.data[key]; ok && existing.ID == userId {
+		delete(usn.data, key)
+	}
+
+	return nil
+}
+
 func (us *UserStorageInMemory) ValidateUserExistence(user *model.User) error {
 	if user.DeletedAt != nil {
-		return errors.New(fmt.Sprintf("user with this id is deleted: %s", user.ID))
+		return errs.Deleted("user with this id is deleted: %s", user.ID)
 	}
 
 	return nil
@@ -212,30 +307,20 @@ func (us *UserStorageInMemory) DeleteUser(userId string, ctx context.Context) (*
 
 	_, ok := uss.data[userId]
 	if !ok {
-		return nil, errors.New(fmt.Sprintf("no such user with id: %s", userId))
+		return nil, errs.NotFound("no such user with id: %s", userId)
 	}
 
 	if uss.data[userId].DeletedAt != nil {
-		return nil, errors.New(fmt.Sprintf("user with this id is already deleted: %s", userId))
+		return nil, errs.Deleted("user with this id is already deleted: %s", userId)
 	}
 
+	// The username and email indexes share the pointer with the id shard.
 	deletionTime := time.Now().Format(time.RFC3339)
 	uss.data[userId].DeletedAt = &deletionTime
-
-	idx, err = getStorageShardIdx(us.usernameShard, us.shardCount, uss.data[userId].Username)
-	if err != nil {
-		return nil, err
-	}
-
-	usn := us.usernameShard[idx]
-	usn.mu.Lock()
-	defer usn.mu.Unlock()
-
-	_, ok = usn.data[uss.data[userId].Username]
-	if !ok {
-		return nil, errors.New(fmt.Sprintf("no such user with username: %s", uss.data[userId].Username))
-	}
-
-	usn.data[uss.data[userId].Username].DeletedAt = &deletionTime
 	return uss.data[userId], nil
 }
+
+// foldKey makes username and email lookups case-insensitive.
+func foldKey(key string) string {
+	return strings.ToLower(key)
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"hash/maphash"
+	"reflect"
+	"strings"
 
 	"github.com/go-pg/pg/v10"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
 )
 
 var seed = maphash.MakeSeed()
@@ -34,7 +37,7 @@ func getDataByUniqueColumn(db *pg.DB, data interface{}, column string, value str
 		return err
 	}
 
-	return query.Where(column+" = ?", value).Select()
+	return mapDbError(query.Where(column+" = ?", value).Select(), data)
 }
 
 func getDataById(db *pg.DB, data interface{}, ctx context.Context) error {
@@ -43,7 +46,48 @@ func getDataById(db *pg.DB, data interface{}, ctx context.Context) error {
 		return err
 	}
 
-	return query.WherePK().Select()
+	return mapDbError(query.WherePK().Select(), data)
+}
+
+func getDataByIds(db *pg.DB, data interface{}, ids []string, ctx context.Context) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query, err := buildQuery(db, data, ctx)
+	if err != nil {
+		return err
+	}
+
+	return mapDbError(query.Where("id IN (?)", pg.In(ids)).Select(), data)
+}
+
+// getByIds looks ids up shard by shard, so every shard is locked once per
+// call instead of once per id.
+func getByIds[T any](shards []*StorageInMemoryShard[T], shardCount uint64, ids []string) ([]*T, error) {
+	byShard := make(map[uint64][]string)
+	for _, id := range ids {
+		idx, err := getStorageShardIdx(shards, shardCount, id)
+		if err != nil {
+			return nil, err
+		}
+
+		byShard[idx] = append(byShard[idx], id)
+	}
+
+	values := make([]*T, 0, len(ids))
+	for idx, shardIds := range byShard {
+		s := shards[idx]
+		s.mu.Lock()
+		for _, id := range shardIds {
+			if val, ok := s.data[id]; ok {
+				values = append(values, val)
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	return values, nil
 }
 
 func insertData(db *pg.DB, data interface{}, ctx context.Context) error {
@@ -53,7 +97,7 @@ func insertData(db *pg.DB, data interface{}, ctx context.Context) error {
 	}
 
 	_, err = query.Insert()
-	return err
+	return mapDbError(err, data)
 }
 
 func updateData(db *pg.DB, data interface{}, ctx context.Context) error {
@@ -63,7 +107,7 @@ func updateData(db *pg.DB, data interface{}, ctx context.Context) error {
 	}
 
 	_, err = query.WherePK().Update()
-	return err
+	return mapDbError(err, data)
 }
 
 func deleteData(db *pg.DB, data interface{}, ctx context.Context) error {
@@ -73,7 +117,7 @@ func deleteData(db *pg.DB, data interface{}, ctx context.Context) error {
 	}
 
 	_, err = query.Set("deleted_at = NOW()").WherePK().Update()
-	return err
+	return mapDbError(err, data)
 }
 
 func buildQuery(db *pg.DB, data interface{}, ctx context.Context) (*pg.Query, error) {
@@ -83,3 +127,52 @@ func buildQuery(db *pg.DB, data interface{}, ctx context.Context) (*pg.Query, er
 
 	return db.WithContext(ctx).Model(data), nil
 }
+
+// mapDbError converts driver errors into domain errors, so the layers above
+// never see go-pg types and raw constraint names.
+func mapDbError(err error, data interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	entity := entityName(data)
+	if errors.Is(err, pg.ErrNoRows) {
+		return errs.NotFound("no such %s", entity)
+	}
+
+	var pgErr pg.Error
+	if errors.As(err, &pgErr) {
+		switch pgErr.Field('C') {
+		case pgUniqueViolation:
+			return errs.Conflict("%s already exists", entity)
+		case pgForeignKeyViolation:
+			return errs.NotFound("%s references a missing row", entity)
+		case pgStringDataRightTruncation, pgCheckViolation, pgInvalidTextRepresentation, pgInvalidDatetimeFormat:
+			return errs.Validation([]errs.FieldError{{Field: entity, Message: "value is not allowed"}})
+		}
+	}
+
+	return err
+}
+
+const (
+	pgUniqueViolation           = "23505"
+	pgForeignKeyViolation       = "23503"
+	pgCheckViolation            = "23514"
+	pgStringDataRightTruncation = "22001"
+	pgInvalidTextRepresentation = "22P02"
+	pgInvalidDatetimeFormat     = "22007"
+)
+
+func entityName(data interface{}) string {
+	t := reflect.TypeOf(data)
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+
+	if t == nil {
+		return "row"
+	}
+
+	return strings.ToLower(t.Name())
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+)
+
+type WebhookStorageDb struct {
+	mu sync.Mutex
+	db *pg.DB
+}
+
+func NewDbWebhookStorage(db *pg.DB) WebhookStorage {
+	return &WebhookStorageDb{
+		db: db,
+		mu: sync.Mutex{},
+	}
+}
+
+func (w *WebhookStorageDb) InsertWebhook(webhook *model.Webhook, ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return insertData(w.db, webhook, ctx)
+}
+
+func (w *WebhookStorageDb) GetWebhookById(webhookId string, ctx context.Context) (*model.Webhook, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	webhook := &model.Webhook{
+		ID: webhookId,
+	}
+	if err := getDataById(w.db, webhook, ctx); err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (w *WebhookStorageDb) GetWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var webhooks []*model.Webhook
+	query, err := buildQuery(w.db, &webhooks, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = query.Order("id ASC").Select(); err != nil {
+		return nil, mapDbError(err, &webhooks)
+	}
+
+	return webhooks, nil
+}
+
+func (w *WebhookStorageDb) GetWebhooksByEvent(event string, ctx context.Context) ([]*model.Webhook, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var webhooks []*model.Webhook
+	query, err := buildQuery(w.db, &webhooks, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = query.Where("? = ANY(events)", event).Order("id ASC").Select(); err != nil {
+		return nil, mapDbError(err, &webhooks)
+	}
+
+	return webhooks, nil
+}
+
+// DeleteWebhook relies on the foreign key to remove the deliveries.
+func (w *WebhookStorageDb) DeleteWebhook(webhookId string, ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	webhook := &model.Webhook{
+		ID: webhookId,
+	}
+	query, err := buildQuery(w.db, webhook, ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := query.WherePK().Delete()
+	if err != nil {
+		return mapDbError(err, webhook)
+	}
+
+	if result.RowsAffected() == 0 {
+		return mapDbError(pg.ErrNoRows, webhook)
+	}
+
+	return nil
+}
+
+func (w *WebhookStorageDb) InsertDeliveries(deliveries []*model.WebhookDelivery, ctx context.Context) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, delivery := range deliveries {
+		delivery.Status = model.DeliveryPending
+	}
+
+	return insertData(w.db, &deliveries, ctx)
+}
+
+// ClaimDeliveries skips the rows locked by other instances claiming at the
+// same time.
+func (w *WebhookStorageDb) ClaimDeliveries(now time.Time, lease time.Duration, limit int, ctx context.Context) ([]*model.WebhookDelivery, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var deliveries []*model.WebhookDelivery
+	query, err := buildQuery(w.db, &deliveries, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = query.
+		Set("next_attempt_at = ?", now.Add(lease)).
+		Where("id IN (?)", w.db.Model((*model.WebhookDelivery)(nil)).
+			Column("id").
+			Where("status = ?", model.DeliveryPending).
+			Where("next_attempt_at <= ?", now).
+			Order("next_attempt_at ASC").
+			Limit(limit).
+			For("UPDATE SKIP LOCKED")).
+		Returning("*").
+		Update()
+	if err != nil {
+		return nil, mapDbError(err, &deliveries)
+	}
+
+	return deliveries, nil
+}
+
+func (w *WebhookStorageDb) GetDeliveryById(deliveryId string, ctx context.Context) (*model.WebhookDelivery, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delivery := &model.WebhookDelivery{
+		ID: deliveryId,
+	}
+	if err := getDataById(w.db, delivery, ctx); err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+func (w *WebhookStorageDb) GetDeliveries(filter DeliveryFilter, page PageQuery, ctx context.Context) ([]*model.WebhookDelivery, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var deliveries []*model.WebhookDelivery
+	query, err := buildQuery(w.db, &deliveries, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = selectPage(filter.apply(query), &deliveries, page); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (w *WebhookStorageDb) CountDeliveries(filter DeliveryFilter, ctx context.Context) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	query, err := buildQuery(w.db, (*model.WebhookDelivery)(nil), ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := filter.apply(query).Count()
+	if err != nil {
+		return 0, mapDbError(err, (*model.WebhookDelivery)(nil))
+	}
+
+	return uint64(count), nil
+}
+
+func (w *WebhookStorageDb) UpdateDelivery(newDelivery *model.WebhookDelivery, ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return updateData(w.db, newDelivery, ctx)
+}
+
+// RetryDelivery only changes a delivery that is still dead, so a worker
+// finishing it at the same time is not overwritten.
+func (w *WebhookStorageDb) RetryDelivery(deliveryId string, now time.Time, ctx context.Context) (*model.WebhookDelivery, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delivery := &model.WebhookDelivery{ID: deliveryId}
+	query, err := buildQuery(w.db, delivery, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := query.
+		Set("status = ?", model.DeliveryPending).
+		Set("attempts = 0").
+		Set("next_attempt_at = ?", now).
+		Set("delivered_at = NULL").
+		WherePK().
+		Where("status = ?", model.DeliveryDead).
+		Returning("*").
+		Update()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, mapDbError(err, delivery)
+	}
+
+	if err == nil && res.RowsAffected() > 0 {
+		return delivery, nil
+	}
+
+	if err = getDataById(w.db, delivery, ctx); err != nil {
+		return nil, err
+	}
+
+	return nil, errs.Conflict("delivery with this id is not dead: %s", deliveryId)
+}
+
+func (f DeliveryFilter) apply(query *orm.Query) *orm.Query {
+	if f.WebhookID != nil {
+		query = query.Where("webhook_id = ?", *f.WebhookID)
+	}
+
+	if f.Status != nil {
+		query = query.Where("status = ?", *f.Status)
+	}
+
+	return query
+}
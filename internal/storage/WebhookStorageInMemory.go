@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/storage/model"
+)
+
+type WebhookStorageInMemory struct {
+	webhooks       []*StorageInMemoryShard[model.Webhook]
+	deliveries     []*StorageInMemoryShard[model.WebhookDelivery]
+	shardCount     uint64
+	lastWebhookId  uint64
+	lastDeliveryId uint64
+}
+
+func NewInMemoryWebhookStorage(params config.ApplicationParameters) WebhookStorage {
+	return &WebhookStorageInMemory{
+		webhooks:   newShards[model.Webhook](params.StorageShardsCount),
+		deliveries: newShards[model.WebhookDelivery](params.StorageShardsCount),
+		shardCount: params.StorageShardsCount,
+	}
+}
+
+func newShards[T any](count uint64) []*StorageInMemoryShard[T] {
+	shards := make([]*StorageInMemoryShard[T], count)
+	for i := range shards {
+		shards[i] = &StorageInMemoryShard[T]{}
+		shards[i].mu = sync.Mutex{}
+		shards[i].data = make(map[string]*T)
+	}
+
+	return shards
+}
+
+func (w *WebhookStorageInMemory) InsertWebhook(webhook *model.Webhook, ctx context.Context) error {
+	id := strconv.FormatUint(atomic.AddUint64(&w.lastWebhookId, 1)-1, 10)
+	idx, err := getStorageShardIdx(w.webhooks, w.shardCount, id)
+	if err != nil {
+		return err
+	}
+
+	ws := w.webhooks[idx]
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	webhook.ID = id
+	webhook.CreatedAt = time.Now().Format(time.RFC3339Nano)
+	ws.data[id] = webhook
+	return nil
+}
+
+func (w *WebhookStorageInMemory) GetWebhookById(webhookId string, ctx context.Context) (*model.Webhook, error) {
+	webhooks, err := getByIds(w.webhooks, w.shardCount, []string{webhookId})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(webhooks) == 0 {
+		return nil, errs.NotFound("no such webhook")
+	}
+
+	return webhooks[0], nil
+}
+
+func (w *WebhookStorageInMemory) GetWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	webhooks := collect(w.webhooks, func(*model.Webhook) bool { return true })
+	slices.SortFunc(webhooks, func(a, b *model.Webhook) int {
+		return compareIds(a.ID, b.ID)
+	})
+
+	return webhooks, nil
+}
+
+func (w *WebhookStorageInMemory) GetWebhooksByEvent(event string, ctx context.Context) ([]*model.Webhook, error) {
+	webhooks := collect(w.webhooks, func(webhook *model.Webhook) bool {
+		return slices.Contains(webhook.Events, event)
+	})
+	slices.SortFunc(webhooks, func(a, b *model.Webhook) int {
+		return compareIds(a.ID, b.ID)
+	})
+
+	return webhooks, nil
+}
+
+func (w *WebhookStorageInMemory) DeleteWebhook(webhookId string, ctx context.Context) error {
+	idx, err := getStorageShardIdx(w.webhooks, w.shardCount, webhookId)
+	if err != nil {
+		return err
+	}
+
+	ws := w.webhooks[idx]
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, ok := ws.data[webhookId]; !ok {
+		return errs.NotFound("no such webhook with id: %s", webhookId)
+	}
+
+	delete(ws.data, webhookId)
+	for _, ds := range w.deliveries {
+		ds.mu.Lock()
+		for id, delivery := range ds.data {
+			if delivery.WebhookID == webhookId {
+				delete(ds.data, id)
+			}
+		}
+		ds.mu.Unlock()
+	}
+
+	return nil
+}
+
+func (w *WebhookStorageInMemory) InsertDeliveries(deliveries []*model.WebhookDelivery, ctx context.Context) error {
+	now := time.Now().Format(time.RFC3339Nano)
+	for _, delivery := range deliveries {
+		id := strconv.FormatUint(atomic.AddUint64(&w.lastDeliveryId, 1)-1, 10)
+		idx, err := getStorageShardIdx(w.deliveries, w.shardCount, id)
+		if err != nil {
+			return err
+		}
+
+		delivery.ID = id
+		delivery.Status = model.DeliveryPending
+		delivery.CreatedAt = now
+		delivery.NextAttemptAt = now
+
+		ds := w.deliveries[idx]
+		ds.mu.Lock()
+		ds.data[id] = delivery
+		ds.mu.Unlock()
+	}
+
+	return nil
+}
+
+// ClaimDeliveries stores postponed copies, the deliveries handed out before
+// stay as they were.
+func (w *WebhookStorageInMemory) ClaimDeliveries(now time.Time, lease time.Duration, limit int, ctx context.Context) ([]*model.WebhookDelivery, error) {
+	postponed := now.Add(lease).Format(time.RFC3339Nano)
+	var claimed []*model.WebhookDelivery
+	for _, ds := range w.deliveries {
+		ds.mu.Lock()
+		for id, delivery := range ds.data {
+			if len(claimed) == limit {
+				break
+			}
+
+			if delivery.Status != model.DeliveryPending || !isDue(delivery, now) {
+				continue
+			}
+
+			taken := *delivery
+			taken.NextAttemptAt = postponed
+			ds.data[id] = &taken
+
+			// The worker updates the claimed delivery in place.
+			handed := taken
+			claimed = append(claimed, &handed)
+		}
+		ds.mu.Unlock()
+	}
+
+	return claimed, nil
+}
+
+func isDue(delivery *model.WebhookDelivery, now time.Time) bool {
+	next, err := time.Parse(time.RFC3339Nano, delivery.NextAttemptAt)
+	return err != nil || !next.After(now)
+}
+
+func (w *WebhookStorageInMemory) GetDeliveryById(deliveryId string, ctx context.Context) (*model.WebhookDelivery, error) {
+	deliveries, err := getByIds(w.deliveries, w.shardCount, []string{deliveryId})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deliveries) == 0 {
+		return nil, errs.NotFound("no such delivery")
+	}
+
+	return deliveries[0], nil
+}
+
+func (w *WebhookStorageInMemory) GetDeliveries(filter DeliveryFilter, page PageQuery, ctx context.Context) ([]*model.WebhookDelivery, error) {
+	return paginate(collect(w.deliveries, filter.matches), deliveryCursor, page), nil
+}
+
+func (w *WebhookStorageInMemory) CountDeliveries(filter DeliveryFilter, ctx context.Context) (uint64, error) {
+	return uint64(len(collect(w.deliveries, filter.matches))), nil
+}
+
+func (w *WebhookStorageInMemory) UpdateDelivery(newDelivery *model.WebhookDelivery, ctx context.Context) error {
+	idx, err := getStorageShardIdx(w.deliveries, w.shardCount, newDelivery.ID)
+	if err != nil {
+		return err
+	}
+
+	ds := w.deliveries[idx]
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if _, ok := ds.data[newDelivery.ID]; !ok {
+		return errs.NotFound("no such delivery with id: %s", newDelivery.ID)
+	}
+
+	ds.data[newDelivery.ID] = newDelivery
+	return nil
+}
+
+func (w *WebhookStorageInMemory) RetryDelivery(deliveryId string, now time.Time, ctx context.Context) (*model.WebhookDelivery, error) {
+	idx, err := getStorageShardIdx(w.deliveries, w.shardCount, deliveryId)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := w.deliveries[idx]
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	delivery, ok := ds.data[deliveryId]
+	if !ok {
+		return nil, errs.NotFound("no such delivery with id: %s", deliveryId)
+	}
+
+	if delivery.Status != model.DeliveryDead {
+		return nil, errs.Conflict("delivery with this id is not dead: %s", deliveryId)
+	}
+
+	retried := *delivery
+	retried.Status = model.DeliveryPending
+	retried.Attempts = 0
+	retried.NextAttemptAt = now.Format(time.RFC3339Nano)
+	retried.DeliveredAt = nil
+	ds.data[deliveryId] = &retried
+
+	result := retried
+	return &result, nil
+}
+
+func (f DeliveryFilter) matches(delivery *model.WebhookDelivery) bool {
+	return (f.WebhookID == nil || delivery.WebhookID == *f.WebhookID) &&
+		(f.Status == nil || delivery.Status == *f.Status)
+}
+
+func deliveryCursor(delivery *model.WebhookDelivery) Cursor {
+	return Cursor{CreatedAt: delivery.CreatedAt, ID: delivery.ID}
+}
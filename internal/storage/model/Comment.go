@@ -6,6 +6,14 @@ type Comment struct {
 	Body            string  `json:"body"`
 	ParentPostID    string  `json:"parentPostId"`
 	ParentCommentID *string `json:"parentCommentId,omitempty"`
-	CreatedAt       string  `json:"createdAt"`
-	DeletedAt       *string `json:"deletedAt"`
+	// RootID, Depth and Path are derived from the parent on insert. Path
+	// joins the zero padded ids from the top level comment down with dots.
+	RootID    string  `json:"rootId"`
+	Depth     uint64  `json:"depth" pg:",use_zero"`
+	Path      string  `json:"path"`
+	CreatedAt string  `json:"createdAt"`
+	DeletedAt *string `json:"deletedAt"`
+	// DeletedBy is the user who deleted the comment, directly or by a
+	// cascade.
+	DeletedBy *string `json:"deletedBy,omitempty"`
 }
@@ -0,0 +1,38 @@
+package model
+
+const (
+	NotificationReply   = "reply"
+	NotificationComment = "comment"
+	NotificationMention = "mention"
+)
+
+// Notification tells a user about a comment, Kind says how it concerns them.
+type Notification struct {
+	ID        string  `json:"id"`
+	UserID    string  `json:"userId"`
+	Kind      string  `json:"kind"`
+	CommentID string  `json:"commentId"`
+	PostID    string  `json:"postId"`
+	CreatedAt string  `json:"createdAt"`
+	ReadAt    *string `json:"readAt"`
+}
+
+// NotificationPreferences toggles every kind of notification of a user, all
+// of them are on unless turned off.
+type NotificationPreferences struct {
+	UserID   string `json:"userId" pg:",pk"`
+	Replies  bool   `json:"replies" pg:",use_zero"`
+	Comments bool   `json:"comments" pg:",use_zero"`
+	Mentions bool   `json:"mentions" pg:",use_zero"`
+}
+
+// DefaultNotificationPreferences are the preferences of users who never
+// changed them.
+func DefaultNotificationPreferences(userId string) *NotificationPreferences {
+	return &NotificationPreferences{
+		UserID:   userId,
+		Replies:  true,
+		Comments: true,
+		Mentions: true,
+	}
+}
@@ -8,4 +8,6 @@ type Post struct {
 	AllowComments bool    `json:"allowComments"`
 	CreatedAt     string  `json:"createdAt"`
 	DeletedAt     *string `json:"deletedAt"`
+	// DeletedBy is the user who deleted the post, directly or by a cascade.
+	DeletedBy *string `json:"deletedBy,omitempty"`
 }
@@ -0,0 +1,10 @@
+package model
+
+type Session struct {
+	ID             string  `json:"id"`
+	UserID         string  `json:"userId"`
+	RefreshTokenID string  `json:"refreshTokenId"`
+	CreatedAt      string  `json:"createdAt"`
+	ExpiresAt      string  `json:"expiresAt"`
+	RevokedAt      *string `json:"revokedAt"`
+}
@@ -1,10 +1,17 @@
 package model
 
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type User struct {
 	ID        string  `json:"id"`
 	Username  string  `json:"username"`
 	Email     string  `json:"email"`
 	Password  string  `json:"password"`
+	Role      string  `json:"role"`
 	CreatedAt string  `json:"createdAt"`
 	DeletedAt *string `json:"deletedAt"`
 }
@@ -0,0 +1,33 @@
+package model
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// DeliveryDead deliveries ran out of attempts, they form the dead letter
+	// list.
+	DeliveryDead = "dead"
+)
+
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events" pg:",array"`
+	Secret    string   `json:"secret"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+// WebhookDelivery is an event to be sent to a webhook. Deliveries are kept
+// once done and make up the delivery log.
+type WebhookDelivery struct {
+	ID             string  `json:"id"`
+	WebhookID      string  `json:"webhookId"`
+	Event          string  `json:"event"`
+	Payload        string  `json:"payload"`
+	Status         string  `json:"status"`
+	Attempts       int     `json:"attempts" pg:",use_zero"`
+	NextAttemptAt  string  `json:"nextAttemptAt"`
+	LastError      *string `json:"lastError"`
+	LastStatusCode *int    `json:"lastStatusCode"`
+	CreatedAt      string  `json:"createdAt"`
+	DeliveredAt    *string `json:"deliveredAt"`
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"strings"
+
 	model2 "github.com/k0ch3gar/ozon-task/internal/graph/model"
 	"github.com/k0ch3gar/ozon-task/internal/storage/model"
 )
@@ -11,33 +13,50 @@ func FromStorageUser(user *model.User) *model2.User {
 			ID:        user.ID,
 			Username:  user.Username,
 			Email:     user.Email,
+			Role:      FromStorageRole(user.Role),
 			CreatedAt: user.CreatedAt,
 			Deleted:   false,
 		}
 	}
 
-	dead := model2.DeadUser
-	dead.ID = user.ID
+	dead := NewDeadUser(user.ID)
 	dead.CreatedAt = user.CreatedAt
-	dead.Deleted = true
 
 	return dead
 }
 
+// NewDeadUser returns a copy of model.DeadUser, the placeholder must never be
+// modified in place because it is shared by all requests.
+func NewDeadUser(userId string) *model2.User {
+	dead := *model2.DeadUser
+	dead.ID = userId
+	return &dead
+}
+
 func FromApiUser(user *model2.User) *model.User {
 	return &model.User{
 		ID:        user.ID,
 		Username:  user.Username,
 		Email:     user.Email,
+		Role:      ToStorageRole(user.Role),
 		CreatedAt: user.CreatedAt,
 	}
 }
 
+func FromStorageRole(role string) model2.Role {
+	return model2.Role(strings.ToUpper(role))
+}
+
+func ToStorageRole(role model2.Role) string {
+	return strings.ToLower(string(role))
+}
+
+// FromUserInput leaves Password empty: it is set by UserService from the hash
+// of the input password and is never copied verbatim.
 func FromUserInput(userInput *model2.UserInput) *model.User {
 	return &model.User{
 		Username: userInput.Username,
 		Email:    userInput.Email,
-		Password: userInput.Password,
 	}
 }
 
@@ -53,14 +72,25 @@ func FromDbPost(post *model.Post) *model2.Post {
 		}
 	}
 
-	dead := model2.DeadPost
-	dead.ID = post.ID
+	dead := NewDeadPost(post.ID)
 	dead.CreatedAt = post.CreatedAt
-	dead.Deleted = true
 
 	return dead
 }
 
+func NewDeadPost(postId string) *model2.Post {
+	dead := *model2.DeadPost
+	dead.ID = postId
+	return &dead
+}
+
+func NewDeadComment(commentId string, postId string) *model2.Comment {
+	dead := *model2.DeadComment
+	dead.ID = commentId
+	dead.ParentPostID = postId
+	return &dead
+}
+
 func FromApiPost(post *model2.Post) *model.Post {
 	return &model.Post{
 		ID:            post.ID,
@@ -72,16 +102,26 @@ func FromApiPost(post *model2.Post) *model.Post {
 	}
 }
 
-func FromPostInput(postInput *model2.PostInput) *model.Post {
+func FromPostInput(postInput *model2.PostInput, authorId string) *model.Post {
 	return &model.Post{
 		Title:         postInput.Title,
 		Body:          postInput.Body,
-		AuthorID:      &postInput.AuthorID,
+		AuthorID:      &authorId,
 		AllowComments: true,
 	}
 }
 
+// FromStorageComment turns deleted comments into tombstones: they keep their
+// place in the tree, but body and author are redacted.
 func FromStorageComment(comment *model.Comment) *model2.Comment {
+	if comment.DeletedAt != nil {
+		dead := NewDeadComment(comment.ID, comment.ParentPostID)
+		dead.ParentCommentID = comment.ParentCommentID
+		dead.CreatedAt = comment.CreatedAt
+
+		return dead
+	}
+
 	return &model2.Comment{
 		ID:              comment.ID,
 		AuthorID:        comment.AuthorID,
@@ -89,7 +129,6 @@ func FromStorageComment(comment *model.Comment) *model2.Comment {
 		ParentCommentID: comment.ParentCommentID,
 		Body:            comment.Body,
 		CreatedAt:       comment.CreatedAt,
-		Deleted:         comment.DeletedAt != nil,
 	}
 }
 
@@ -104,11 +143,83 @@ func FromApiComment(comment *model2.Comment) *model.Comment {
 	}
 }
 
-func FromCommentInput(comment *model2.CommentInput) *model.Comment {
+func FromCommentInput(comment *model2.CommentInput, authorId string) *model.Comment {
 	return &model.Comment{
-		AuthorID:        &comment.AuthorID,
+		AuthorID:        &authorId,
 		ParentPostID:    comment.ParentPostID,
 		ParentCommentID: comment.ParentCommentID,
 		Body:            comment.Body,
 	}
 }
+
+func FromStorageNotification(notification *model.Notification) *model2.Notification {
+	return &model2.Notification{
+		ID:        notification.ID,
+		Kind:      model2.NotificationKind(strings.ToUpper(notification.Kind)),
+		CommentID: notification.CommentID,
+		PostID:    notification.PostID,
+		CreatedAt: notification.CreatedAt,
+		Read:      notification.ReadAt != nil,
+	}
+}
+
+func FromStoragePreferences(preferences *model.NotificationPreferences) *model2.NotificationPreferences {
+	return &model2.NotificationPreferences{
+		Replies:  preferences.Replies,
+		Comments: preferences.Comments,
+		Mentions: preferences.Mentions,
+	}
+}
+
+func FromStorageWebhook(webhook *model.Webhook) *model2.Webhook {
+	events := make([]model2.WebhookEventType, len(webhook.Events))
+	for i, event := range webhook.Events {
+		events[i] = model2.WebhookEventType(strings.ToUpper(event))
+	}
+
+	return &model2.Webhook{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    events,
+		CreatedAt: webhook.CreatedAt,
+	}
+}
+
+func FromWebhookInput(webhook model2.WebhookInput) *model.Webhook {
+	events := make([]string, len(webhook.Events))
+	for i, event := range webhook.Events {
+		events[i] = strings.ToLower(string(event))
+	}
+
+	return &model.Webhook{
+		URL:    webhook.URL,
+		Events: events,
+		Secret: webhook.Secret,
+	}
+}
+
+// FromStorageDelivery leaves nextAttemptAt out once the delivery is done.
+func FromStorageDelivery(delivery *model.WebhookDelivery) *model2.WebhookDelivery {
+	result := &model2.WebhookDelivery{
+		ID:          delivery.ID,
+		WebhookID:   delivery.WebhookID,
+		Event:       model2.WebhookEventType(strings.ToUpper(delivery.Event)),
+		Payload:     delivery.Payload,
+		Status:      model2.WebhookDeliveryStatus(strings.ToUpper(delivery.Status)),
+		Attempts:    int32(delivery.Attempts),
+		LastError:   delivery.LastError,
+		CreatedAt:   delivery.CreatedAt,
+		DeliveredAt: delivery.DeliveredAt,
+	}
+
+	if delivery.Status == model.DeliveryPending {
+		result.NextAttemptAt = &delivery.NextAttemptAt
+	}
+
+	if delivery.LastStatusCode != nil {
+		code := int32(*delivery.LastStatusCode)
+		result.LastStatusCode = &code
+	}
+
+	return result
+}
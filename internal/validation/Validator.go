@@ -0,0 +1,223 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+)
+
+// Limits are counted in characters, the same way VARCHAR(n) columns count
+// them, so input accepted here is also accepted by Postgres.
+type Limits struct {
+	MaxUsernameLength    int
+	MaxEmailLength       int
+	MaxPasswordLength    int
+	MaxTitleLength       int
+	MaxPostBodyLength    int
+	MaxCommentBodyLength int
+}
+
+// Webhook limits follow the columns of the webhooks table, a secret shorter
+// than minSecretLength is too easy to guess.
+const (
+	maxWebhookURLLength = 2048
+	minSecretLength     = 16
+	maxSecretLength     = 256
+)
+
+type Validator struct {
+	limits Limits
+}
+
+func NewValidator(params config.ApplicationParameters) *Validator {
+	return &Validator{
+		limits: Limits{
+			MaxUsernameLength:    params.MaxUsernameLength,
+			MaxEmailLength:       params.MaxEmailLength,
+			MaxPasswordLength:    params.MaxPasswordLength,
+			MaxTitleLength:       params.MaxTitleLength,
+			MaxPostBodyLength:    params.MaxPostBodyLength,
+			MaxCommentBodyLength: params.MaxCommentBodyLength,
+		},
+	}
+}
+
+type check func(value string) string
+
+func (v *Validator) UserInput(input model.UserInput) error {
+	var fields []errs.FieldError
+	fields = v.username(fields, input.Username)
+	fields = v.email(fields, input.Email)
+	fields = v.password(fields, "password", input.Password)
+	return toError(fields)
+}
+
+func (v *Validator) Username(username string) error {
+	return toError(v.username(nil, username))
+}
+
+func (v *Validator) Email(email string) error {
+	return toError(v.email(nil, email))
+}
+
+func (v *Validator) Password(field string, password string) error {
+	return toError(v.password(nil, field, password))
+}
+
+func (v *Validator) PostInput(input model.PostInput) error {
+	var fields []errs.FieldError
+	fields = v.title(fields, input.Title)
+	fields = v.postBody(fields, input.Body)
+	return toError(fields)
+}
+
+func (v *Validator) Title(title string) error {
+	return toError(v.title(nil, title))
+}
+
+func (v *Validator) PostBody(body string) error {
+	return toError(v.postBody(nil, body))
+}
+
+func (v *Validator) CommentInput(input model.CommentInput) error {
+	return v.CommentBody(input.Body)
+}
+
+func (v *Validator) CommentBody(body string) error {
+	return toError(validate(nil, "body", body, notBlank, maxLength(v.limits.MaxCommentBodyLength), noControlCharacters(true)))
+}
+
+func (v *Validator) WebhookInput(input model.WebhookInput) error {
+	fields := validate(nil, "url", input.URL, notBlank, maxLength(maxWebhookURLLength), httpURL)
+	fields = validate(fields, "secret", input.Secret, minLength(minSecretLength), maxLength(maxSecretLength))
+	if len(input.Events) == 0 {
+		fields = append(fields, errs.FieldError{Field: "events", Message: "must not be empty"})
+	}
+
+	return toError(fields)
+}
+
+func (v *Validator) username(fields []errs.FieldError, username string) []errs.FieldError {
+	return validate(fields, "username", username, notBlank, maxLength(v.limits.MaxUsernameLength), noControlCharacters(false))
+}
+
+func (v *Validator) email(fields []errs.FieldError, email string) []errs.FieldError {
+	return validate(fields, "email", email, notBlank, maxLength(v.limits.MaxEmailLength), noControlCharacters(false), emailAddress)
+}
+
+func (v *Validator) password(fields []errs.FieldError, field string, password string) []errs.FieldError {
+	return validate(fields, field, password, notEmpty, maxLength(v.limits.MaxPasswordLength))
+}
+
+func (v *Validator) title(fields []errs.FieldError, title string) []errs.FieldError {
+	return validate(fields, "title", title, notBlank, maxLength(v.limits.MaxTitleLength), noControlCharacters(false))
+}
+
+func (v *Validator) postBody(fields []errs.FieldError, body string) []errs.FieldError {
+	return validate(fields, "body", body, notBlank, maxLength(v.limits.MaxPostBodyLength), noControlCharacters(true))
+}
+
+// validate reports at most one error per field, the first failed check.
+func validate(fields []errs.FieldError, field string, value string, checks ...check) []errs.FieldError {
+	for _, c := range checks {
+		if message := c(value); message != "" {
+			return append(fields, errs.FieldError{
+				Field:   field,
+				Message: message,
+			})
+		}
+	}
+
+	return fields
+}
+
+func toError(fields []errs.FieldError) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return errs.Validation(fields)
+}
+
+func notEmpty(value string) string {
+	if value == "" {
+		return "must not be empty"
+	}
+
+	return ""
+}
+
+func notBlank(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return "must not be empty"
+	}
+
+	return ""
+}
+
+func maxLength(limit int) check {
+	return func(value string) string {
+		if limit > 0 && utf8.RuneCountInString(value) > limit {
+			return fmt.Sprintf("must be at most %d characters long", limit)
+		}
+
+		return ""
+	}
+}
+
+func minLength(limit int) check {
+	return func(value string) string {
+		if utf8.RuneCountInString(value) < limit {
+			return fmt.Sprintf("must be at least %d characters long", limit)
+		}
+
+		return ""
+	}
+}
+
+// noControlCharacters rejects invisible characters, multiline text may still
+// contain line breaks and tabs.
+func noControlCharacters(multiline bool) check {
+	return func(value string) string {
+		if !utf8.ValidString(value) {
+			return "must be valid UTF-8"
+		}
+
+		for _, r := range value {
+			if multiline && (r == '\n' || r == '\r' || r == '\t') {
+				continue
+			}
+
+			if unicode.IsControl(r) {
+				return "must not contain control characters"
+			}
+		}
+
+		return ""
+	}
+}
+
+func emailAddress(value string) string {
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Address != value || address.Name != "" {
+		return "must be a valid email address"
+	}
+
+	return ""
+}
+
+func httpURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "must be an absolute http or https URL"
+	}
+
+	return ""
+}
@@ -0,0 +1,82 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/k0ch3gar/ozon-task/internal/config"
+	"github.com/k0ch3gar/ozon-task/internal/errs"
+	"github.com/k0ch3gar/ozon-task/internal/graph/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestValidator() *Validator {
+	return NewValidator(config.ApplicationParameters{
+		MaxUsernameLength:    32,
+		MaxEmailLength:       64,
+		MaxPasswordLength:    128,
+		MaxTitleLength:       255,
+		MaxPostBodyLength:    20000,
+		MaxCommentBodyLength: 2000,
+	})
+}
+
+func fieldErrors(t *testing.T, err error) []errs.FieldError {
+	var domainErr *errs.Error
+	if !errors.As(err, &domainErr) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+
+	assert.Equal(t, errs.CodeValidation, domainErr.Code)
+	return domainErr.Fields
+}
+
+func TestUserInputValidation(t *testing.T) {
+	v := newTestValidator()
+
+	assert.NoError(t, v.UserInput(model.UserInput{Username: "foo", Email: "foo@mail.ru", Password: "baz"}))
+
+	fields := fieldErrors(t, v.UserInput(model.UserInput{
+		Username: strings.Repeat("ф", 33),
+		Email:    "foo",
+		Password: "",
+	}))
+
+	assert.Len(t, fields, 3)
+	assert.Equal(t, "username", fields[0].Field)
+	assert.Equal(t, "email", fields[1].Field)
+	assert.Equal(t, "password", fields[2].Field)
+
+	assert.NoError(t, v.Username(strings.Repeat("ф", 32)), "limits are counted in characters, not bytes")
+	assert.Error(t, v.Username("foo\x00"))
+	assert.Error(t, v.Email("Foo <foo@mail.ru>"))
+}
+
+func TestPostAndCommentValidation(t *testing.T) {
+	v := newTestValidator()
+
+	assert.NoError(t, v.PostInput(model.PostInput{Title: "title", Body: "line1\nline2\ttabbed"}))
+
+	fields := fieldErrors(t, v.PostInput(model.PostInput{Title: "  ", Body: ""}))
+	assert.Len(t, fields, 2)
+
+	assert.Error(t, v.Title("title\nwith break"))
+	assert.Error(t, v.Title(strings.Repeat("a", 256)))
+	assert.NoError(t, v.CommentBody(strings.Repeat("a", 2000)))
+	assert.Error(t, v.CommentBody(strings.Repeat("a", 2001)))
+	assert.Error(t, v.CommentBody("bell\a"))
+}
+
+func TestWebhookValidation(t *testing.T) {
+	v := newTestValidator()
+	events := []model.WebhookEventType{model.WebhookEventTypeCommentCreated}
+
+	assert.NoError(t, v.WebhookInput(model.WebhookInput{URL: "https://hooks.example.com/ozon", Events: events, Secret: strings.Repeat("s", 16)}))
+
+	fields := fieldErrors(t, v.WebhookInput(model.WebhookInput{URL: "ftp://example.com", Secret: "short"}))
+	assert.Len(t, fields, 3)
+
+	assert.Error(t, v.WebhookInput(model.WebhookInput{URL: "/relative", Events: events, Secret: strings.Repeat("s", 16)}))
+	assert.Error(t, v.WebhookInput(model.WebhookInput{URL: "http://example.com", Events: events, Secret: strings.Repeat("s", 257)}))
+}